@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/phillip-england/totem/pkg/config"
+	"github.com/phillip-england/totem/pkg/data"
+)
+
+// runBackup implements "totem backup [file]". Without a file the snapshot goes
+// to a timestamped name in cfg.BackupDir.
+func runBackup(cfg config.Config, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: totem backup [file]")
+	}
+	if err := openDatabase(cfg); err != nil {
+		return err
+	}
+	defer data.DB.Close()
+
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	} else {
+		if err := os.MkdirAll(cfg.BackupDir, 0o700); err != nil {
+			return err
+		}
+		path = filepath.Join(cfg.BackupDir, data.BackupFileName(time.Now()))
+	}
+	if err := data.BackupTo(path); err != nil {
+		return err
+	}
+	fmt.Println("Backed up to", path)
+	if cfg.Store == "postgres" {
+		fmt.Println("Business data is in PostgreSQL and is not included; back it up with pg_dump.")
+	}
+	return nil
+}
+
+// runRestore implements "totem restore <file>". The server must be stopped:
+// swapping the file under a running process would lose its writes.
+func runRestore(cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: totem restore <file>")
+	}
+	source := args[0]
+	version, err := data.InspectBackup(source)
+	if err != nil {
+		return err
+	}
+
+	if err := openDatabase(cfg); err != nil {
+		return err
+	}
+	target, err := data.DatabaseFile()
+	data.DB.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restore %s (schema version %d) over %s? Stop the server first. [y/N] ", source, version, target)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+		return errors.New("cancelled")
+	}
+
+	kept, err := data.RestoreBackup(source, target)
+	if err != nil {
+		return err
+	}
+	if kept != "" {
+		fmt.Println("Previous database kept at", kept)
+	}
+	fmt.Println("Restored", target)
+	if version < data.LatestSchemaVersion() {
+		fmt.Println("Pending migrations will be applied on the next start.")
+	}
+	return nil
+}
+
+// scheduleBackups snapshots the database every cfg.BackupInterval until ctx
+// is done, keeping the newest cfg.BackupKeep files.
+func scheduleBackups(ctx context.Context, cfg config.Config) {
+	if err := os.MkdirAll(cfg.BackupDir, 0o700); err != nil {
+		fmt.Println("Scheduled backups disabled:", err)
+		return
+	}
+	ticker := time.NewTicker(cfg.BackupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			path := filepath.Join(cfg.BackupDir, data.BackupFileName(now))
+			if err := data.BackupTo(path); err != nil {
+				fmt.Println("Scheduled backup failed:", err)
+				continue
+			}
+			if err := data.RotateBackups(cfg.BackupDir, cfg.BackupKeep); err != nil {
+				fmt.Println("Rotating backups failed:", err)
+			}
+		}
+	}
+}
@@ -3,15 +3,16 @@ module github.com/phillip-england/totem
 go 1.25.3
 
 require (
+	github.com/PuerkitoBio/goquery v1.11.0
+	github.com/extrame/xls v0.0.1
+	github.com/lib/pq v1.12.3
 	github.com/phillip-england/vii v0.0.0
 	github.com/xuri/excelize/v2 v2.8.1
 )
 
 require (
-	github.com/PuerkitoBio/goquery v1.11.0 // indirect
 	github.com/andybalholm/cascadia v1.3.3 // indirect
 	github.com/extrame/ole2 v0.0.0-20160812065207-d69429661ad7 // indirect
-	github.com/extrame/xls v0.0.1 // indirect
 )
 
 require (
@@ -1,53 +1,227 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/phillip-england/totem/pkg/config"
 	"github.com/phillip-england/totem/pkg/data"
+	"github.com/phillip-england/totem/pkg/data/postgres"
 	"github.com/phillip-england/totem/pkg/handlers"
 	"github.com/phillip-england/vii"
 )
 
-func loadEnv() {
-	file, err := os.Open(".env")
+func main() {
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		runServer(loadConfig(args))
+	case "backup":
+		if err := runBackup(loadConfig(flagArgs(&args)), args); err != nil {
+			fmt.Fprintln(os.Stderr, "Backup failed:", err)
+			os.Exit(1)
+		}
+	case "restore":
+		if err := runRestore(loadConfig(flagArgs(&args)), args); err != nil {
+			fmt.Fprintln(os.Stderr, "Restore failed:", err)
+			os.Exit(1)
+		}
+	case "migrate":
+		action := "up"
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			action, args = args[0], args[1:]
+		}
+		if err := runMigrate(loadConfig(args), action); err != nil {
+			fmt.Fprintln(os.Stderr, "Migration failed:", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", command)
+		fmt.Fprintln(os.Stderr, "usage: totem [flags]")
+		fmt.Fprintln(os.Stderr, "       totem migrate [up|down|status] [flags]")
+		fmt.Fprintln(os.Stderr, "       totem backup [file] [flags]")
+		fmt.Fprintln(os.Stderr, "       totem restore <file> [flags]")
+		os.Exit(2)
+	}
+}
+
+// flagArgs splits leading positional arguments off *args, leaving them in
+// place and returning the flags that follow.
+func flagArgs(args *[]string) []string {
+	for i, arg := range *args {
+		if strings.HasPrefix(arg, "-") {
+			flags := (*args)[i:]
+			*args = (*args)[:i]
+			return flags
+		}
+	}
+	return nil
+}
+
+func loadConfig(args []string) config.Config {
+	cfg, err := config.Load(args)
 	if err != nil {
-		fmt.Println("Error loading .env:", err)
-		return
-	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			os.Setenv(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		cfg.Print(os.Stdout)
+		os.Exit(0)
+	}
+	return cfg
+}
+
+func openDatabase(cfg config.Config) error {
+	if cfg.DBPath == "" {
+		data.InitDB()
+		return nil
+	}
+	return data.Open(cfg.DBPath)
+}
+
+// openStore returns the business data backend chosen by cfg.Store and a
+// function that releases it. The control database and the store are bound to
+// each other on first start, and any other pairing is refused.
+func openStore(cfg config.Config) (data.Store, func(), error) {
+	if cfg.Store != "postgres" {
+		if _, err := data.BindStore("sqlite"); err != nil {
+			return nil, nil, err
 		}
+		return data.SQLiteStore{}, func() {}, nil
+	}
+	instanceID, err := data.BindStore("postgres")
+	if err != nil {
+		return nil, nil, err
+	}
+	pg, err := postgres.Open(cfg.PostgresURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := pg.Claim(instanceID); err != nil {
+		pg.Close()
+		return nil, nil, err
 	}
+	return pg, func() { pg.Close() }, nil
 }
 
-func main() {
-	loadEnv()
-	data.InitDB()
+func runServer(cfg config.Config) {
+	if err := openDatabase(cfg); err != nil {
+		fmt.Println("Error opening database:", err)
+		os.Exit(1)
+	}
+	defer data.DB.Close()
+	// Pending migrations apply automatically; a database migrated by a newer
+	// build is refused rather than risk misreading it.
+	applied, err := data.MigrateUp()
+	if err != nil {
+		fmt.Println("Error preparing database:", err)
+		data.DB.Close()
+		os.Exit(1)
+	}
+	for _, m := range applied {
+		fmt.Printf("Applied migration %d (%s)\n", m.Version, m.Name)
+	}
+	store, closeStore, err := openStore(cfg)
+	if err != nil {
+		fmt.Println("Error opening "+cfg.Store+" store:", err)
+		data.DB.Close()
+		os.Exit(1)
+	}
+	defer closeStore()
+	data.TrashRetention = cfg.TrashRetention
+	if _, err := data.PurgeTrash(store); err != nil {
+		fmt.Println("Error purging trash:", err)
+	}
+	handlers.Configure(cfg)
 
 	app := vii.NewApp()
-	app.Use(vii.MwLogger)
+	if cfg.Features.RequestLog {
+		app.Use(vii.MwLogger)
+	}
 	app.Use(handlers.AuthMiddleware)
 
-	// Load templates from the "templates" directory
-	err := app.Templates("templates", template.FuncMap{})
+	// Load templates from the configured directory
+	err = app.Templates(cfg.TemplateDir, template.FuncMap{})
 	if err != nil {
 		panic(err)
 	}
 
-	handlers.RegisterRoutes(&app)
+	handlers.RegisterRoutes(&app, store)
 
-	err = app.Serve("8080")
-	if err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.BackupInterval > 0 {
+		go scheduleBackups(ctx, cfg)
+	}
+	go scheduleTrashPurge(ctx, store)
+	if err := serve(ctx, &app, cfg); err != nil {
 		fmt.Println("Error starting server:", err)
+		data.DB.Close()
 		os.Exit(1)
 	}
 }
+
+// trashPurgeInterval is how often expired trash, and import previews nobody
+// applied, are purged while serving, on top of the trash purge at startup.
+const trashPurgeInterval = time.Hour
+
+func scheduleTrashPurge(ctx context.Context, store data.Store) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := data.PurgeTrash(store); err != nil {
+				fmt.Println("Purging trash failed:", err)
+			}
+			if _, err := data.PurgeExpiredImportPreviews(store); err != nil {
+				fmt.Println("Purging import previews failed:", err)
+			}
+		}
+	}
+}
+
+// serve runs the HTTP server until ctx is cancelled by SIGINT or SIGTERM,
+// then stops accepting connections and waits up to cfg.ShutdownTimeout for
+// in-flight requests.
+func serve(ctx context.Context, handler http.Handler, cfg config.Config) error {
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: handler}
+	errCh := make(chan error, 1)
+	go func() {
+		fmt.Println("Listening on :" + cfg.Port)
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	fmt.Println("Shutting down, draining requests...")
+	handlers.BeginShutdown()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		fmt.Println("Error draining requests:", err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
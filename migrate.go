@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/phillip-england/totem/pkg/config"
+	"github.com/phillip-england/totem/pkg/data"
+)
+
+// runMigrate implements "totem migrate [up|down|status]". down rolls back a
+// single migration per run.
+func runMigrate(cfg config.Config, action string) error {
+	if err := openDatabase(cfg); err != nil {
+		return err
+	}
+	defer data.DB.Close()
+
+	switch action {
+	case "up":
+		applied, err := data.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("Applied %d (%s)\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Already up to date.")
+		}
+	case "down":
+		m, err := data.MigrateDown()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back %d (%s)\n", m.Version, m.Name)
+	case "status":
+		version, err := data.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		statuses, err := data.GetMigrationStatus()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Database version %d, latest known %d\n\n", version, data.LatestSchemaVersion())
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, state)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if version > data.LatestSchemaVersion() {
+			return data.ErrSchemaTooNew
+		}
+	default:
+		return fmt.Errorf("unknown migrate action %q (want up, down or status)", action)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+// Package calendar maps dates onto a business calendar: weeks that start on a
+// chosen weekday and a fiscal year split into twelve periods, either calendar
+// months or whole weeks in a 4-4-5 style pattern.
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const DateLayout = "2006-01-02"
+
+// Patterns lists the supported period layouts. "monthly" uses calendar months
+// starting on the fiscal year's start day; the others give the weeks in each
+// period of a quarter.
+var Patterns = []string{"monthly", "4-4-5", "4-5-4", "5-4-4"}
+
+var patternWeeks = map[string][3]int{
+	"4-4-5": {4, 4, 5},
+	"4-5-4": {4, 5, 4},
+	"5-4-4": {5, 4, 4},
+}
+
+type Calendar struct {
+	WeekStart time.Weekday
+	Pattern   string
+	// YearStartMonth and YearStartDay anchor the fiscal year. Week-based
+	// patterns start the year on the first WeekStart day on or after the
+	// anchor, so years run 52 or 53 weeks; the extra week goes to the last
+	// period.
+	YearStartMonth time.Month
+	YearStartDay   int
+}
+
+// Default is a Monday week on calendar months and a January fiscal year, the
+// calendar every location used before calendars were configurable.
+func Default() Calendar {
+	return Calendar{WeekStart: time.Monday, Pattern: "monthly", YearStartMonth: time.January, YearStartDay: 1}
+}
+
+func (c Calendar) Validate() error {
+	var errs []error
+	if c.WeekStart < time.Sunday || c.WeekStart > time.Saturday {
+		errs = append(errs, errors.New("week start must be a weekday"))
+	}
+	if c.Pattern != "monthly" {
+		if _, ok := patternWeeks[c.Pattern]; !ok {
+			errs = append(errs, fmt.Errorf("unknown fiscal pattern %q", c.Pattern))
+		}
+	}
+	if c.YearStartMonth < time.January || c.YearStartMonth > time.December {
+		errs = append(errs, errors.New("fiscal year start month must be between 1 and 12"))
+	}
+	// Capped at 28 so every month has the day and monthly periods line up.
+	if c.YearStartDay < 1 || c.YearStartDay > 28 {
+		errs = append(errs, errors.New("fiscal year start day must be between 1 and 28"))
+	}
+	return errors.Join(errs...)
+}
+
+// Range is an inclusive span of dates.
+type Range struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (r Range) Contains(t time.Time) bool {
+	d := Date(t)
+	return !d.Before(r.Start) && !d.After(r.End)
+}
+
+// Clip limits r to the dates also in other.
+func (r Range) Clip(other Range) Range {
+	if r.Start.Before(other.Start) {
+		r.Start = other.Start
+	}
+	if r.End.After(other.End) {
+		r.End = other.End
+	}
+	return r
+}
+
+func (r Range) Label() string {
+	return r.Start.Format("Jan 2") + "–" + r.End.Format("Jan 2")
+}
+
+type Period struct {
+	Range
+	// FiscalYear is named for the calendar year the fiscal year ends in.
+	FiscalYear int
+	Number     int
+	Quarter    int
+}
+
+func (p Period) Label() string {
+	return fmt.Sprintf("FY%d P%d", p.FiscalYear, p.Number)
+}
+
+// Date truncates t to midnight in its own location.
+func Date(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Week returns the business week containing t.
+func (c Calendar) Week(t time.Time) Range {
+	d := Date(t)
+	offset := (int(d.Weekday()) - int(c.WeekStart) + 7) % 7
+	start := d.AddDate(0, 0, -offset)
+	return Range{Start: start, End: start.AddDate(0, 0, 6)}
+}
+
+// Year returns the fiscal year containing t and the year it is named for.
+func (c Calendar) Year(t time.Time) (Range, int) {
+	d := Date(t)
+	anchorYear := d.Year()
+	if d.Before(c.yearStart(anchorYear, d.Location())) {
+		anchorYear--
+	}
+	start := c.yearStart(anchorYear, d.Location())
+	end := c.yearStart(anchorYear+1, d.Location()).AddDate(0, 0, -1)
+	// A week-based year can run up to six days past its anchor, which
+	// shouldn't push it into the next year's name.
+	return Range{Start: start, End: end}, end.AddDate(0, 0, -6).Year()
+}
+
+func (c Calendar) yearStart(year int, loc *time.Location) time.Time {
+	anchor := time.Date(year, c.YearStartMonth, c.YearStartDay, 0, 0, 0, 0, loc)
+	if c.Pattern == "monthly" {
+		return anchor
+	}
+	offset := (int(c.WeekStart) - int(anchor.Weekday()) + 7) % 7
+	return anchor.AddDate(0, 0, offset)
+}
+
+// Periods returns the twelve periods of the fiscal year containing t.
+func (c Calendar) Periods(t time.Time) []Period {
+	year, name := c.Year(t)
+	periods := make([]Period, 12)
+	weeks, weekly := patternWeeks[c.Pattern]
+	start := year.Start
+	for i := range periods {
+		var next time.Time
+		switch {
+		case i == len(periods)-1:
+			next = year.End.AddDate(0, 0, 1)
+		case weekly:
+			next = start.AddDate(0, 0, 7*weeks[i%3])
+		default:
+			next = time.Date(year.Start.Year(), year.Start.Month()+time.Month(i+1), year.Start.Day(), 0, 0, 0, 0, year.Start.Location())
+		}
+		periods[i] = Period{
+			Range:      Range{Start: start, End: next.AddDate(0, 0, -1)},
+			FiscalYear: name,
+			Number:     i + 1,
+			Quarter:    i/3 + 1,
+		}
+		start = next
+	}
+	return periods
+}
+
+// Period returns the fiscal period containing t.
+func (c Calendar) Period(t time.Time) Period {
+	periods := c.Periods(t)
+	for _, p := range periods {
+		if p.Contains(t) {
+			return p
+		}
+	}
+	return periods[len(periods)-1]
+}
+
+// Quarter returns the fiscal quarter containing t and its number.
+func (c Calendar) Quarter(t time.Time) (Range, int) {
+	periods := c.Periods(t)
+	quarter := c.Period(t).Quarter
+	first := periods[(quarter-1)*3]
+	last := periods[(quarter-1)*3+2]
+	return Range{Start: first.Start, End: last.End}, quarter
+}
+
+// Unit selects the span Span groups dates by.
+type Unit string
+
+const (
+	UnitWeek    Unit = "week"
+	UnitPeriod  Unit = "period"
+	UnitQuarter Unit = "quarter"
+	UnitYear    Unit = "year"
+)
+
+// Span returns the unit containing t and a label for it.
+func (c Calendar) Span(unit Unit, t time.Time) (Range, string) {
+	switch unit {
+	case UnitPeriod:
+		p := c.Period(t)
+		return p.Range, p.Label()
+	case UnitQuarter:
+		r, quarter := c.Quarter(t)
+		_, name := c.Year(t)
+		return r, fmt.Sprintf("FY%d Q%d", name, quarter)
+	case UnitYear:
+		r, name := c.Year(t)
+		return r, fmt.Sprintf("FY%d", name)
+	default:
+		r := c.Week(t)
+		return r, r.Label()
+	}
+}
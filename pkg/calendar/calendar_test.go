@@ -0,0 +1,190 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.ParseInLocation(DateLayout, s, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func weeks(r Range) int {
+	return (int(r.End.Sub(r.Start).Hours()/24) + 1) / 7
+}
+
+func TestWeek(t *testing.T) {
+	tests := []struct {
+		weekStart  time.Weekday
+		day        string
+		start, end string
+	}{
+		{time.Monday, "2024-03-04", "2024-03-04", "2024-03-10"},
+		{time.Monday, "2024-03-10", "2024-03-04", "2024-03-10"},
+		{time.Monday, "2024-03-11", "2024-03-11", "2024-03-17"},
+		{time.Sunday, "2024-03-09", "2024-03-03", "2024-03-09"},
+		{time.Sunday, "2024-03-10", "2024-03-10", "2024-03-16"},
+		{time.Saturday, "2024-03-08", "2024-03-02", "2024-03-08"},
+		{time.Saturday, "2024-03-09", "2024-03-09", "2024-03-15"},
+		// Weeks cross the end of the year.
+		{time.Monday, "2024-12-31", "2024-12-30", "2025-01-05"},
+		{time.Wednesday, "2025-01-01", "2025-01-01", "2025-01-07"},
+	}
+
+	for _, tt := range tests {
+		c := Calendar{WeekStart: tt.weekStart}
+		got := c.Week(date(tt.day).Add(15 * time.Hour))
+		if !got.Start.Equal(date(tt.start)) || !got.End.Equal(date(tt.end)) {
+			t.Errorf("%s week of %s = %s to %s, want %s to %s", tt.weekStart, tt.day,
+				got.Start.Format(DateLayout), got.End.Format(DateLayout), tt.start, tt.end)
+		}
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	tests := []struct {
+		pattern string
+		// ends lists the last day of each period of FY2025, which starts on
+		// Monday 2025-01-06, the first Monday on or after January 1.
+		ends [12]string
+	}{
+		{"4-4-5", [12]string{
+			"2025-02-02", "2025-03-02", "2025-04-06",
+			"2025-05-04", "2025-06-01", "2025-07-06",
+			"2025-08-03", "2025-08-31", "2025-10-05",
+			"2025-11-02", "2025-11-30", "2026-01-04",
+		}},
+		{"4-5-4", [12]string{
+			"2025-02-02", "2025-03-09", "2025-04-06",
+			"2025-05-04", "2025-06-08", "2025-07-06",
+			"2025-08-03", "2025-09-07", "2025-10-05",
+			"2025-11-02", "2025-12-07", "2026-01-04",
+		}},
+	}
+
+	for _, tt := range tests {
+		c := Calendar{WeekStart: time.Monday, Pattern: tt.pattern, YearStartMonth: time.January, YearStartDay: 1}
+		periods := c.Periods(date("2025-06-15"))
+		start := date("2025-01-06")
+		for i, p := range periods {
+			end := date(tt.ends[i])
+			if !p.Start.Equal(start) || !p.End.Equal(end) {
+				t.Errorf("%s P%d = %s to %s, want %s to %s", tt.pattern, i+1,
+					p.Start.Format(DateLayout), p.End.Format(DateLayout), start.Format(DateLayout), tt.ends[i])
+			}
+			if p.Number != i+1 || p.Quarter != i/3+1 || p.FiscalYear != 2025 {
+				t.Errorf("%s period %d is %s in Q%d", tt.pattern, i+1, p.Label(), p.Quarter)
+			}
+			if p.Start.Weekday() != time.Monday {
+				t.Errorf("%s P%d starts on a %s", tt.pattern, i+1, p.Start.Weekday())
+			}
+			start = end.AddDate(0, 0, 1)
+		}
+
+		quarter, n := c.Quarter(date("2025-05-10"))
+		if n != 2 || weeks(quarter) != 13 {
+			t.Errorf("%s quarter of 2025-05-10 = Q%d with %d weeks, want Q2 with 13", tt.pattern, n, weeks(quarter))
+		}
+	}
+}
+
+func TestFiftyThreeWeekYear(t *testing.T) {
+	// January 1, 2024 is a Monday and January 1, 2025 a Wednesday, so FY2024
+	// runs from 2024-01-01 until the first Monday of 2025, 53 weeks later.
+	c := Calendar{WeekStart: time.Monday, Pattern: "4-4-5", YearStartMonth: time.January, YearStartDay: 1}
+
+	year, name := c.Year(date("2024-07-01"))
+	if name != 2024 || !year.Start.Equal(date("2024-01-01")) || !year.End.Equal(date("2025-01-05")) {
+		t.Fatalf("FY%d = %s to %s, want FY2024 from 2024-01-01 to 2025-01-05",
+			name, year.Start.Format(DateLayout), year.End.Format(DateLayout))
+	}
+	if weeks(year) != 53 {
+		t.Errorf("FY2024 has %d weeks, want 53", weeks(year))
+	}
+
+	// The extra week goes to the last period.
+	periods := c.Periods(date("2024-07-01"))
+	if got := weeks(periods[11].Range); got != 6 {
+		t.Errorf("P12 of a 53 week year has %d weeks, want 6", got)
+	}
+
+	// Days in the extra week belong to FY2024 and keep its name.
+	p := c.Period(date("2025-01-03"))
+	if p.Label() != "FY2024 P12" {
+		t.Errorf("2025-01-03 is in %s, want FY2024 P12", p.Label())
+	}
+	if p := c.Period(date("2025-01-06")); p.Label() != "FY2025 P1" {
+		t.Errorf("2025-01-06 is in %s, want FY2025 P1", p.Label())
+	}
+
+	next, _ := c.Year(date("2025-07-01"))
+	if weeks(next) != 52 {
+		t.Errorf("FY2025 has %d weeks, want 52", weeks(next))
+	}
+}
+
+func TestMonthlyFiscalYear(t *testing.T) {
+	c := Calendar{WeekStart: time.Monday, Pattern: "monthly", YearStartMonth: time.July, YearStartDay: 1}
+
+	tests := []struct {
+		day   string
+		label string
+	}{
+		{"2024-06-30", "FY2024 P12"},
+		{"2024-07-01", "FY2025 P1"},
+		{"2025-02-28", "FY2025 P8"},
+	}
+	for _, tt := range tests {
+		if p := c.Period(date(tt.day)); p.Label() != tt.label {
+			t.Errorf("%s is in %s, want %s", tt.day, p.Label(), tt.label)
+		}
+	}
+
+	periods := c.Periods(date("2025-02-28"))
+	if !periods[7].Start.Equal(date("2025-02-01")) || !periods[7].End.Equal(date("2025-02-28")) {
+		t.Errorf("P8 = %s to %s, want February", periods[7].Start.Format(DateLayout), periods[7].End.Format(DateLayout))
+	}
+}
+
+func TestSpan(t *testing.T) {
+	c := Calendar{WeekStart: time.Monday, Pattern: "4-5-4", YearStartMonth: time.January, YearStartDay: 1}
+	day := date("2025-03-05")
+
+	tests := []struct {
+		unit  Unit
+		label string
+		start string
+	}{
+		{UnitWeek, "Mar 3–Mar 9", "2025-03-03"},
+		{UnitPeriod, "FY2025 P2", "2025-02-03"},
+		{UnitQuarter, "FY2025 Q1", "2025-01-06"},
+		{UnitYear, "FY2025", "2025-01-06"},
+	}
+	for _, tt := range tests {
+		r, label := c.Span(tt.unit, day)
+		if label != tt.label || !r.Start.Equal(date(tt.start)) {
+			t.Errorf("Span(%s) = %s from %s, want %s from %s", tt.unit, label, r.Start.Format(DateLayout), tt.label, tt.start)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Default().Validate() = %v", err)
+	}
+	bad := []Calendar{
+		{WeekStart: 7, Pattern: "monthly", YearStartMonth: time.January, YearStartDay: 1},
+		{WeekStart: time.Monday, Pattern: "4-4-4", YearStartMonth: time.January, YearStartDay: 1},
+		{WeekStart: time.Monday, Pattern: "monthly", YearStartMonth: 13, YearStartDay: 1},
+		{WeekStart: time.Monday, Pattern: "monthly", YearStartMonth: time.January, YearStartDay: 29},
+	}
+	for _, c := range bad {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", c)
+		}
+	}
+}
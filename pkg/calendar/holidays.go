@@ -0,0 +1,82 @@
+package calendar
+
+import "time"
+
+// Holiday is a named day that recurs every year.
+type Holiday struct {
+	Key  string
+	Name string
+	date func(year int) (time.Month, int)
+}
+
+// Holidays lists the holidays a location can close for.
+var Holidays = []Holiday{
+	{Key: "new_years_day", Name: "New Year's Day", date: fixed(time.January, 1)},
+	{Key: "easter", Name: "Easter Sunday", date: easter},
+	{Key: "memorial_day", Name: "Memorial Day", date: nthWeekday(time.May, time.Monday, -1)},
+	{Key: "independence_day", Name: "Independence Day", date: fixed(time.July, 4)},
+	{Key: "labor_day", Name: "Labor Day", date: nthWeekday(time.September, time.Monday, 1)},
+	{Key: "thanksgiving", Name: "Thanksgiving Day", date: nthWeekday(time.November, time.Thursday, 4)},
+	{Key: "christmas_eve", Name: "Christmas Eve", date: fixed(time.December, 24)},
+	{Key: "christmas", Name: "Christmas Day", date: fixed(time.December, 25)},
+	{Key: "new_years_eve", Name: "New Year's Eve", date: fixed(time.December, 31)},
+}
+
+func HolidayByKey(key string) (Holiday, bool) {
+	for _, h := range Holidays {
+		if h.Key == key {
+			return h, true
+		}
+	}
+	return Holiday{}, false
+}
+
+// In returns the holiday's date in the given year.
+func (h Holiday) In(year int, loc *time.Location) time.Time {
+	month, day := h.date(year)
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// On reports whether t falls on the holiday.
+func (h Holiday) On(t time.Time) bool {
+	month, day := h.date(t.Year())
+	return t.Month() == month && t.Day() == day
+}
+
+func fixed(month time.Month, day int) func(int) (time.Month, int) {
+	return func(int) (time.Month, int) { return month, day }
+}
+
+// nthWeekday counts from the start of the month, or from the end when n is
+// negative.
+func nthWeekday(month time.Month, weekday time.Weekday, n int) func(int) (time.Month, int) {
+	return func(year int) (time.Month, int) {
+		if n > 0 {
+			first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+			offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+			return month, 1 + offset + 7*(n-1)
+		}
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+		offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+		return month, last.Day() - offset + 7*(n+1)
+	}
+}
+
+// easter uses the anonymous Gregorian algorithm.
+func easter(year int) (time.Month, int) {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Month(month), day
+}
@@ -0,0 +1,444 @@
+// Package config loads totem's settings from a KEY=VALUE file, the
+// environment and command-line flags, in increasing order of precedence.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Port        string
+	DBPath      string
+	TemplateDir string
+
+	// Store picks where business data lives: "sqlite" keeps it in the DBPath
+	// database, "postgres" moves it to PostgresURL. Accounts and sessions
+	// always stay in SQLite.
+	Store       string
+	PostgresURL string
+
+	SessionIdleTimeout time.Duration
+	SessionMaxLifetime time.Duration
+	RememberMeLifetime time.Duration
+	TrashRetention     time.Duration
+	ShutdownTimeout    time.Duration
+
+	// Scheduled backups run every BackupInterval into BackupDir, keeping the
+	// newest BackupKeep files. A zero interval turns them off.
+	BackupDir      string
+	BackupInterval time.Duration
+	BackupKeep     int
+
+	// CSRFSecret keeps CSRF tokens valid across restarts. When empty a random
+	// key is generated at startup.
+	CSRFSecret string
+
+	Features Features
+
+	// ConfigFile is the file settings were read from, if any.
+	ConfigFile string
+	// Exported lists the config file keys that aren't totem settings and
+	// were passed through to the environment for other code to read.
+	Exported []string
+	// PrintConfig asks main to print the effective settings and exit.
+	PrintConfig bool
+
+	sources map[string]string
+}
+
+type Features struct {
+	RememberMe     bool
+	PerformanceAPI bool
+	RequestLog     bool
+}
+
+const DefaultConfigFile = ".env"
+
+func Default() Config {
+	return Config{
+		Port:               "8080",
+		TemplateDir:        "templates",
+		Store:              "sqlite",
+		SessionIdleTimeout: 12 * time.Hour,
+		SessionMaxLifetime: 7 * 24 * time.Hour,
+		RememberMeLifetime: 30 * 24 * time.Hour,
+		TrashRetention:     30 * 24 * time.Hour,
+		ShutdownTimeout:    30 * time.Second,
+		BackupDir:          "backups",
+		BackupKeep:         14,
+		Features: Features{
+			RememberMe:     true,
+			PerformanceAPI: true,
+			RequestLog:     true,
+		},
+	}
+}
+
+// setting describes one configurable value. key is used in the config file
+// and the environment; flag is the command-line name.
+type setting struct {
+	key    string
+	flag   string
+	usage  string
+	secret bool
+	set    func(c *Config, value string) error
+	get    func(c *Config) string
+}
+
+var settings = []setting{
+	{
+		key: "TOTEM_PORT", flag: "port", usage: "HTTP port to listen on",
+		set: func(c *Config, v string) error { c.Port = v; return nil },
+		get: func(c *Config) string { return c.Port },
+	},
+	{
+		key: "TOTEM_DB_PATH", flag: "db", usage: "SQLite database file (empty uses the built-in default)",
+		set: func(c *Config, v string) error { c.DBPath = v; return nil },
+		get: func(c *Config) string { return c.DBPath },
+	},
+	{
+		key: "TOTEM_TEMPLATE_DIR", flag: "templates", usage: "directory containing HTML templates",
+		set: func(c *Config, v string) error { c.TemplateDir = v; return nil },
+		get: func(c *Config) string { return c.TemplateDir },
+	},
+	{
+		key: "TOTEM_STORE", flag: "store", usage: "business data backend: sqlite or postgres",
+		set: func(c *Config, v string) error { c.Store = strings.ToLower(v); return nil },
+		get: func(c *Config) string { return c.Store },
+	},
+	{
+		key: "TOTEM_POSTGRES_URL", flag: "postgres-url", usage: "PostgreSQL connection URL when TOTEM_STORE=postgres", secret: true,
+		set: func(c *Config, v string) error { c.PostgresURL = v; return nil },
+		get: func(c *Config) string { return c.PostgresURL },
+	},
+	{
+		key: "TOTEM_SESSION_IDLE_TIMEOUT", flag: "session-idle-timeout", usage: "sign out after this long without activity",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.SessionIdleTimeout }),
+		get: func(c *Config) string { return c.SessionIdleTimeout.String() },
+	},
+	{
+		key: "TOTEM_SESSION_MAX_LIFETIME", flag: "session-max-lifetime", usage: "absolute session lifetime regardless of activity",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.SessionMaxLifetime }),
+		get: func(c *Config) string { return c.SessionMaxLifetime.String() },
+	},
+	{
+		key: "TOTEM_REMEMBER_ME_LIFETIME", flag: "remember-me-lifetime", usage: "session lifetime when \"remember me\" is checked",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.RememberMeLifetime }),
+		get: func(c *Config) string { return c.RememberMeLifetime.String() },
+	},
+	{
+		key: "TOTEM_TRASH_RETENTION", flag: "trash-retention", usage: "how long deleted records can be restored",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.TrashRetention }),
+		get: func(c *Config) string { return c.TrashRetention.String() },
+	},
+	{
+		key: "TOTEM_SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", usage: "how long to let in-flight requests finish on SIGINT/SIGTERM",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+		get: func(c *Config) string { return c.ShutdownTimeout.String() },
+	},
+	{
+		key: "TOTEM_BACKUP_DIR", flag: "backup-dir", usage: "directory for scheduled backups and \"totem backup\"",
+		set: func(c *Config, v string) error { c.BackupDir = v; return nil },
+		get: func(c *Config) string { return c.BackupDir },
+	},
+	{
+		key: "TOTEM_BACKUP_INTERVAL", flag: "backup-interval", usage: "how often to back up while serving; 0 disables",
+		set: durationSetter(func(c *Config) *time.Duration { return &c.BackupInterval }),
+		get: func(c *Config) string { return c.BackupInterval.String() },
+	},
+	{
+		key: "TOTEM_BACKUP_KEEP", flag: "backup-keep", usage: "number of scheduled backups to keep",
+		set: func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("must be a whole number (got %q)", v)
+			}
+			c.BackupKeep = n
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.BackupKeep) },
+	},
+	{
+		key: "CSRF_SECRET", flag: "csrf-secret", usage: "key for CSRF tokens", secret: true,
+		set: func(c *Config, v string) error { c.CSRFSecret = v; return nil },
+		get: func(c *Config) string { return c.CSRFSecret },
+	},
+	{
+		key: "TOTEM_FEATURE_REMEMBER_ME", flag: "feature-remember-me", usage: "offer \"remember me\" at login",
+		set: boolSetter(func(c *Config) *bool { return &c.Features.RememberMe }),
+		get: func(c *Config) string { return strconv.FormatBool(c.Features.RememberMe) },
+	},
+	{
+		key: "TOTEM_FEATURE_PERFORMANCE_API", flag: "feature-performance-api", usage: "serve the JSON performance API",
+		set: boolSetter(func(c *Config) *bool { return &c.Features.PerformanceAPI }),
+		get: func(c *Config) string { return strconv.FormatBool(c.Features.PerformanceAPI) },
+	},
+	{
+		key: "TOTEM_FEATURE_REQUEST_LOG", flag: "feature-request-log", usage: "log each HTTP request",
+		set: boolSetter(func(c *Config) *bool { return &c.Features.RequestLog }),
+		get: func(c *Config) string { return strconv.FormatBool(c.Features.RequestLog) },
+	},
+}
+
+func durationSetter(field func(c *Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as 12h or 30m (got %q)", v)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func boolSetter(field func(c *Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("must be true or false (got %q)", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// Load builds the effective configuration for args (without the program
+// name). Flags beat environment variables, which beat the config file, which
+// beats the defaults. The config file is DefaultConfigFile unless --config or
+// TOTEM_CONFIG names another; only an explicitly named file must exist.
+//
+// Config file keys that aren't settings are exported to the environment, as
+// the old .env loader did, unless the environment already sets them. An
+// unknown key starting with TOTEM_ is almost certainly a typo and is an
+// error instead.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+	cfg.sources = map[string]string{}
+
+	fs := flag.NewFlagSet("totem", flag.ContinueOnError)
+	configFile := fs.String("config", "", "settings file of KEY=VALUE lines (default "+DefaultConfigFile+")")
+	fs.BoolVar(&cfg.PrintConfig, "print-config", false, "print the effective configuration and exit")
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		fs.Func(s.flag, s.usage+" ("+s.key+")", func(v string) error {
+			flagValues[s.key] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	path, required := DefaultConfigFile, false
+	if env := strings.TrimSpace(os.Getenv("TOTEM_CONFIG")); env != "" {
+		path, required = env, true
+	}
+	if *configFile != "" {
+		path, required = *configFile, true
+	}
+	fileValues, err := readFile(path)
+	switch {
+	case err == nil:
+		cfg.ConfigFile = path
+	case errors.Is(err, os.ErrNotExist) && !required:
+	default:
+		return cfg, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	var errs []error
+	known := make(map[string]bool, len(settings))
+	for _, s := range settings {
+		known[s.key] = true
+	}
+	for _, key := range sortedKeys(fileValues) {
+		switch {
+		case known[key]:
+		case strings.HasPrefix(key, "TOTEM_"):
+			errs = append(errs, fmt.Errorf("%s (from %s): unknown setting", key, path))
+		default:
+			if _, set := os.LookupEnv(key); !set {
+				if err := os.Setenv(key, fileValues[key]); err != nil {
+					errs = append(errs, fmt.Errorf("%s (from %s): %w", key, path, err))
+					continue
+				}
+			}
+			cfg.Exported = append(cfg.Exported, key)
+		}
+	}
+	for _, s := range settings {
+		value, ok := flagValues[s.key]
+		source := "flag --" + s.flag
+		if !ok {
+			value, ok = os.LookupEnv(s.key)
+			source = "environment"
+		}
+		if !ok {
+			value, ok = fileValues[s.key]
+			source = path
+		}
+		if !ok {
+			continue
+		}
+		if err := s.set(&cfg, strings.TrimSpace(value)); err != nil {
+			errs = append(errs, fmt.Errorf("%s (from %s): %w", s.key, source, err))
+			continue
+		}
+		cfg.sources[s.key] = source
+	}
+	if len(errs) > 0 {
+		return cfg, errors.Join(errs...)
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate checks settings that parsed but can't work together.
+func (c Config) Validate() error {
+	var errs []error
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("TOTEM_PORT: must be a number between 1 and 65535 (got %q)", c.Port))
+	}
+	if info, err := os.Stat(c.TemplateDir); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Errorf("TOTEM_TEMPLATE_DIR: %q is not a directory", c.TemplateDir))
+	}
+	if c.DBPath != "" {
+		if info, err := os.Stat(filepath.Dir(c.DBPath)); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("TOTEM_DB_PATH: directory for %q does not exist", c.DBPath))
+		}
+	}
+	switch c.Store {
+	case "sqlite":
+	case "postgres":
+		if c.PostgresURL == "" {
+			errs = append(errs, errors.New("TOTEM_POSTGRES_URL: required when TOTEM_STORE is postgres"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("TOTEM_STORE: must be sqlite or postgres (got %q)", c.Store))
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"TOTEM_SESSION_IDLE_TIMEOUT", c.SessionIdleTimeout},
+		{"TOTEM_SESSION_MAX_LIFETIME", c.SessionMaxLifetime},
+		{"TOTEM_REMEMBER_ME_LIFETIME", c.RememberMeLifetime},
+		{"TOTEM_TRASH_RETENTION", c.TrashRetention},
+		{"TOTEM_SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+	} {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be positive (got %s)", d.key, d.value))
+		}
+	}
+	if c.BackupInterval < 0 {
+		errs = append(errs, fmt.Errorf("TOTEM_BACKUP_INTERVAL: must not be negative (got %s)", c.BackupInterval))
+	}
+	if c.BackupInterval > 0 {
+		if c.BackupKeep < 1 {
+			errs = append(errs, fmt.Errorf("TOTEM_BACKUP_KEEP: must be at least 1 when backups are scheduled (got %d)", c.BackupKeep))
+		}
+		if c.BackupDir == "" {
+			errs = append(errs, errors.New("TOTEM_BACKUP_DIR: required when TOTEM_BACKUP_INTERVAL is set"))
+		}
+	}
+	if c.SessionIdleTimeout > c.SessionMaxLifetime {
+		errs = append(errs, errors.New("TOTEM_SESSION_IDLE_TIMEOUT: must not exceed TOTEM_SESSION_MAX_LIFETIME"))
+	}
+	if c.CSRFSecret != "" && len(c.CSRFSecret) < 16 {
+		errs = append(errs, errors.New("CSRF_SECRET: must be at least 16 characters"))
+	}
+	return errors.Join(errs...)
+}
+
+// Print writes the effective settings as KEY=VALUE lines, noting where each
+// came from. Secrets are redacted.
+func (c Config) Print(w io.Writer) {
+	if c.ConfigFile != "" {
+		fmt.Fprintf(w, "# config file: %s\n", c.ConfigFile)
+	} else {
+		fmt.Fprintln(w, "# config file: none")
+	}
+	for _, s := range settings {
+		value := s.get(&c)
+		if s.secret && value != "" {
+			value = "[redacted]"
+		}
+		source := c.sources[s.key]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%s=%s # %s\n", s.key, value, source)
+	}
+	if len(c.Exported) > 0 {
+		fmt.Fprintf(w, "# also exported from %s: %s\n", c.ConfigFile, strings.Join(c.Exported, ", "))
+	}
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readFile parses KEY=VALUE lines. Blank lines and lines starting with # are
+// skipped, an optional "export " prefix is allowed, double-quoted values use
+// Go escapes, single-quoted values are literal, and unquoted values end at
+// " #".
+func readFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad quoted value for %s", lineNo, key)
+			}
+			value = unquoted
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return nil, fmt.Errorf("line %d: unterminated quote for %s", lineNo, key)
+			}
+			value = value[1 : len(value)-1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const apiTokenPrefix = "totem_"
+
+var ErrAPITokenNotFound = errors.New("api token not found")
+
+type APIToken struct {
+	ID             int
+	OrganizationID int
+	Name           string
+	CreatedBy      int
+	AllLocations   bool
+	LocationIDs    []int
+	CreatedAt      time.Time
+	LastUsedAt     time.Time
+	Revoked        bool
+}
+
+// CanAccessLocation reports whether the token's location scope covers
+// locationID. Callers must also check the location is in OrganizationID.
+func (t APIToken) CanAccessLocation(locationID int) bool {
+	if t.AllLocations {
+		return true
+	}
+	for _, id := range t.LocationIDs {
+		if id == locationID {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken stores a new token and returns its secret. Only the hash of
+// the secret is persisted, so the caller must show it to the user right away.
+func CreateAPIToken(orgID int, name string, createdBy int, allLocations bool, locationIDs []int) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("token name is required")
+	}
+	if !allLocations && len(locationIDs) == 0 {
+		return "", errors.New("token must be scoped to at least one location")
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	secret := apiTokenPrefix + hex.EncodeToString(buf)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec(
+		"INSERT INTO api_tokens (organization_id, name, token_hash, created_by, all_locations, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		orgID, name, hashAPIToken(secret), createdBy, allLocations, time.Now(),
+	)
+	if err != nil {
+		return "", err
+	}
+	tokenID, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	if !allLocations {
+		for _, locationID := range locationIDs {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO api_token_locations (token_id, location_id) VALUES (?, ?)", tokenID, locationID); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func GetAPITokens(orgID int) ([]APIToken, error) {
+	rows, err := DB.Query(
+		"SELECT id, organization_id, name, created_by, all_locations, created_at, last_used_at, revoked_at FROM api_tokens WHERE organization_id = ? ORDER BY created_at DESC",
+		orgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		ids, err := getAPITokenLocationIDs(tokens[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		tokens[i].LocationIDs = ids
+	}
+	return tokens, nil
+}
+
+// GetAPITokenBySecret returns the active token matching secret and records
+// the time it was used.
+func GetAPITokenBySecret(secret string) (APIToken, error) {
+	row := DB.QueryRow(
+		"SELECT id, organization_id, name, created_by, all_locations, created_at, last_used_at, revoked_at FROM api_tokens WHERE token_hash = ? AND revoked_at IS NULL",
+		hashAPIToken(strings.TrimSpace(secret)),
+	)
+	token, err := scanAPIToken(row)
+	if err == sql.ErrNoRows {
+		return APIToken{}, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return APIToken{}, err
+	}
+	token.LocationIDs, err = getAPITokenLocationIDs(token.ID)
+	if err != nil {
+		return APIToken{}, err
+	}
+	_, _ = DB.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", time.Now(), token.ID)
+	return token, nil
+}
+
+func RevokeAPIToken(orgID, id int) error {
+	res, err := DB.Exec("UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND organization_id = ? AND revoked_at IS NULL", time.Now(), id, orgID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIToken(row rowScanner) (APIToken, error) {
+	var token APIToken
+	var lastUsed, revoked sql.NullTime
+	if err := row.Scan(&token.ID, &token.OrganizationID, &token.Name, &token.CreatedBy, &token.AllLocations, &token.CreatedAt, &lastUsed, &revoked); err != nil {
+		return APIToken{}, err
+	}
+	if lastUsed.Valid {
+		token.LastUsedAt = lastUsed.Time
+	}
+	token.Revoked = revoked.Valid
+	return token, nil
+}
+
+func getAPITokenLocationIDs(tokenID int) ([]int, error) {
+	rows, err := DB.Query("SELECT location_id FROM api_token_locations WHERE token_id = ? ORDER BY location_id", tokenID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,158 @@
+package data
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+type AuditEntry struct {
+	ID             int
+	OrganizationID int
+	UserID         int
+	Username       string
+	LocationID     int
+	Route          string
+	EntityType     string
+	EntityID       string
+	Before         string
+	After          string
+	CreatedAt      time.Time
+}
+
+// AuditEntityTypes lists the entity types recorded in the audit log, used to
+// populate the viewer's filter.
+var AuditEntityTypes = []string{
+	"api_token",
+	"backup",
+	"closure",
+	"employee",
+	"employee_alias",
+	"import",
+	"labor",
+	"location",
+	"login_lockout",
+	"organization",
+	"payroll_event",
+	"sales",
+	"session",
+	"setting",
+	"two_factor",
+	"user",
+}
+
+// AuditFilter selects entries from one organization; the other fields narrow
+// it further when set.
+type AuditFilter struct {
+	OrganizationID int
+	LocationID     int
+	UserID         int
+	EntityType     string
+	StartDate      string
+	EndDate        string
+	Limit          int
+}
+
+// CreateAuditEntry writes entry to the control database on its own. A change
+// that can share a transaction with its entry should use CreateAuditEntryTx.
+func CreateAuditEntry(entry AuditEntry) error {
+	return updateControl(func(tx *Tx) error {
+		return CreateAuditEntryTx(tx, entry)
+	})
+}
+
+// CreateAuditEntryTx writes entry inside tx, so it commits or rolls back with
+// the change it records. Entries written through a Store's transaction live
+// in the store's database; GetAuditEntries reads both.
+func CreateAuditEntryTx(tx *Tx, entry AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if entry.OrganizationID == 0 {
+		entry.OrganizationID = DefaultOrganizationID
+	}
+	_, err := tx.Exec(
+		`INSERT INTO audit_log (organization_id, user_id, username, location_id, route, entity_type, entity_id, before_value, after_value, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.OrganizationID, entry.UserID, entry.Username, entry.LocationID, entry.Route, entry.EntityType, entry.EntityID,
+		entry.Before, entry.After, entry.CreatedAt,
+	)
+	return err
+}
+
+// GetAuditEntries returns matching entries newest first, from the control
+// database and, when store keeps its own, the store's. Dates are inclusive
+// and formatted as 2006-01-02.
+func GetAuditEntries(store Store, filter AuditFilter) ([]AuditEntry, error) {
+	where := []string{"organization_id = ?"}
+	args := []any{filter.OrganizationID}
+	if filter.LocationID > 0 {
+		where = append(where, "location_id = ?")
+		args = append(args, filter.LocationID)
+	}
+	if filter.UserID > 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.EntityType != "" {
+		where = append(where, "entity_type = ?")
+		args = append(args, filter.EntityType)
+	}
+	if start, err := time.ParseInLocation("2006-01-02", filter.StartDate, time.Local); err == nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, start)
+	}
+	if end, err := time.ParseInLocation("2006-01-02", filter.EndDate, time.Local); err == nil {
+		where = append(where, "created_at < ?")
+		args = append(args, end.AddDate(0, 0, 1))
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 500
+	}
+
+	query := "SELECT id, organization_id, user_id, username, location_id, route, entity_type, entity_id, before_value, after_value, created_at FROM audit_log"
+	query += " WHERE " + strings.Join(where, " AND ")
+	query += " ORDER BY created_at DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	var entries []AuditEntry
+	read := func(tx *Tx) error {
+		found, err := queryAuditEntries(tx, query, args)
+		entries = append(entries, found...)
+		return err
+	}
+	if err := updateControl(read); err != nil {
+		return nil, err
+	}
+	if sharesControlDB(store) {
+		return entries, nil
+	}
+	if err := store.Update(read); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].CreatedAt.After(entries[j].CreatedAt) })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func queryAuditEntries(tx *Tx, query string, args []any) ([]AuditEntry, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.OrganizationID, &entry.UserID, &entry.Username, &entry.LocationID, &entry.Route,
+			&entry.EntityType, &entry.EntityID, &entry.Before, &entry.After, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const backupFilePrefix = "totem-"
+
+// BackupTo writes a consistent snapshot of the live database to path using
+// VACUUM INTO, which is safe while the server is handling requests. path must
+// not already exist.
+func BackupTo(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	_, err := DB.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// BackupFileName names a scheduled or command-line backup taken at t.
+func BackupFileName(t time.Time) string {
+	return backupFilePrefix + t.Format("20060102-150405") + ".db"
+}
+
+// DatabaseFile returns the file behind DB, so restore can find it even when
+// InitDB chose the path.
+func DatabaseFile() (string, error) {
+	rows, err := DB.Query("PRAGMA database_list")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seq int
+		var name, file string
+		if err := rows.Scan(&seq, &name, &file); err != nil {
+			return "", err
+		}
+		if name == "main" {
+			if file == "" {
+				return "", errors.New("database is in memory")
+			}
+			return file, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("main database not found")
+}
+
+// InspectBackup checks that path is an intact totem database this build can
+// run against and returns its schema version. Backups taken before
+// migrations existed report version 0.
+func InspectBackup(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var check string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&check); err != nil {
+		return 0, fmt.Errorf("not a readable SQLite database: %w", err)
+	}
+	if check != "ok" {
+		return 0, fmt.Errorf("integrity check failed: %s", check)
+	}
+
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'").Scan(&tables); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, errors.New("not a totem database: users table is missing")
+	}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&tables); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, err
+	}
+	if int(version.Int64) > LatestSchemaVersion() {
+		return int(version.Int64), fmt.Errorf("%w: backup is at version %d, this build knows up to %d", ErrSchemaTooNew, version.Int64, LatestSchemaVersion())
+	}
+	return int(version.Int64), nil
+}
+
+// RestoreBackup replaces the database file at target with the backup at
+// source. The current file is kept beside it with a .pre-restore suffix. The
+// server must not be running against target.
+func RestoreBackup(source, target string) (string, error) {
+	if _, err := InspectBackup(source); err != nil {
+		return "", err
+	}
+
+	staged := target + ".restoring"
+	os.Remove(staged)
+	if err := copyFile(source, staged); err != nil {
+		return "", err
+	}
+	kept := ""
+	if _, err := os.Stat(target); err == nil {
+		kept = target + ".pre-restore-" + time.Now().Format("20060102-150405")
+		if err := os.Rename(target, kept); err != nil {
+			os.Remove(staged)
+			return "", err
+		}
+	}
+	// Stale WAL files belong to the old database and would corrupt the new one.
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		os.Remove(target + suffix)
+	}
+	if err := os.Rename(staged, target); err != nil {
+		return kept, err
+	}
+	return kept, nil
+}
+
+// RotateBackups deletes all but the newest keep backups in dir.
+func RotateBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupFilePrefix) && strings.HasSuffix(name, ".db") {
+			names = append(names, name)
+		}
+	}
+	// Timestamped names sort chronologically.
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/phillip-england/totem/pkg/calendar"
+)
+
+var ErrClosureNotFound = errors.New("closure not found")
+
+// Closure is a one-off day a location is closed, such as a remodel or a
+// weather closing.
+type Closure struct {
+	ID         int
+	LocationID int
+	Date       string
+	Name       string
+}
+
+func GetClosures(locationID int) ([]Closure, error) {
+	rows, err := DB.Query("SELECT id, location_id, date, name FROM location_closures WHERE location_id = ? ORDER BY date", locationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var closures []Closure
+	for rows.Next() {
+		var c Closure
+		if err := rows.Scan(&c.ID, &c.LocationID, &c.Date, &c.Name); err != nil {
+			return nil, err
+		}
+		closures = append(closures, c)
+	}
+	return closures, rows.Err()
+}
+
+// AddClosure records a one-off closure, renaming it if the date is already
+// closed.
+func AddClosure(locationID int, date, name string) error {
+	return updateControl(func(tx *Tx) error {
+		return AddClosureTx(tx, locationID, date, name)
+	})
+}
+
+func DeleteClosure(locationID, id int) error {
+	return updateControl(func(tx *Tx) error {
+		return DeleteClosureTx(tx, locationID, id)
+	})
+}
+
+// AddClosureTx and DeleteClosureTx change a location's closures inside tx,
+// so callers can commit the audit entry with the change. Adding a closure on
+// a date that already has one renames it.
+func AddClosureTx(tx *Tx, locationID int, date, name string) error {
+	_, err := tx.Exec(
+		"INSERT INTO location_closures (location_id, date, name) VALUES (?, ?, ?) ON CONFLICT(location_id, date) DO UPDATE SET name = excluded.name",
+		locationID, date, name,
+	)
+	return err
+}
+
+func DeleteClosureTx(tx *Tx, locationID, id int) error {
+	res, err := tx.Exec("DELETE FROM location_closures WHERE id = ? AND location_id = ?", id, locationID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrClosureNotFound
+	}
+	return nil
+}
+
+// ValidateClosure checks a one-off closure before it is stored.
+func ValidateClosure(date, name string) error {
+	if _, err := time.Parse(calendar.DateLayout, date); err != nil {
+		return fmt.Errorf("closure date must look like 2006-01-02")
+	}
+	if strings.TrimSpace(name) == "" {
+		return errors.New("closure name is required")
+	}
+	return nil
+}
+
+// ClosedDays answers whether a location is closed on a date: a weekday marked
+// closed in its hours, one of its holidays, or a one-off closure.
+type ClosedDays struct {
+	weekdays [7]bool
+	holidays []calendar.Holiday
+	dates    map[string]string
+}
+
+func NewClosedDays(profile LocationProfile, closures []Closure) ClosedDays {
+	closed := ClosedDays{dates: map[string]string{}}
+	for day, hours := range profile.Hours {
+		closed.weekdays[day] = hours.Closed
+	}
+	for _, key := range profile.Holidays {
+		if h, ok := calendar.HolidayByKey(key); ok {
+			closed.holidays = append(closed.holidays, h)
+		}
+	}
+	for _, c := range closures {
+		closed.dates[c.Date] = c.Name
+	}
+	return closed
+}
+
+// Reason returns why the location is closed on day, or false when it is
+// open. One-off closures win over holidays, which win over weekdays.
+func (c ClosedDays) Reason(day time.Time) (string, bool) {
+	if name, ok := c.dates[day.Format(calendar.DateLayout)]; ok {
+		return name, true
+	}
+	for _, h := range c.holidays {
+		if h.On(day) {
+			return h.Name, true
+		}
+	}
+	if c.weekdays[day.Weekday()] {
+		return "Closed " + day.Weekday().String() + "s", true
+	}
+	return "", false
+}
+
+// ReasonForDate is Reason for a "2006-01-02" date. Unparseable dates count as
+// open.
+func (c ClosedDays) ReasonForDate(date string) (string, bool) {
+	day, err := time.Parse(calendar.DateLayout, date)
+	if err != nil {
+		return "", false
+	}
+	return c.Reason(day)
+}
+
+// OperatingDays counts the days in r the location is open.
+func (c ClosedDays) OperatingDays(r calendar.Range) int {
+	count := 0
+	for day := r.Start; !day.After(r.End); day = day.AddDate(0, 0, 1) {
+		if _, closed := c.Reason(day); !closed {
+			count++
+		}
+	}
+	return count
+}
+
+// ClosedDates maps each closed date in r to its reason, for marking rows in
+// day-by-day tables.
+func (c ClosedDays) ClosedDates(r calendar.Range) map[string]string {
+	dates := map[string]string{}
+	for day := r.Start; !day.After(r.End); day = day.AddDate(0, 0, 1) {
+		if reason, closed := c.Reason(day); closed {
+			dates[day.Format(calendar.DateLayout)] = reason
+		}
+	}
+	return dates
+}
+
+// OperatingSummary is a PerformanceSummary whose daily averages only count the
+// days the location was open.
+type OperatingSummary struct {
+	PerformanceSummary
+	OperatingDays int     `json:"operatingDays"`
+	ClosedDays    int     `json:"closedDays"`
+	AvgDailySales float64 `json:"avgDailySales"`
+	AvgDailyHours float64 `json:"avgDailyHours"`
+}
+
+// CalculateOperatingSummary totals records like CalculateSummary and averages
+// them over the operating days in within. Anything recorded on a closed day
+// still counts toward the totals but not the averages.
+func CalculateOperatingSummary(records []DailyPerformanceRecord, closed ClosedDays, within calendar.Range) OperatingSummary {
+	summary := OperatingSummary{PerformanceSummary: CalculateSummary(records)}
+	summary.OperatingDays = closed.OperatingDays(within)
+	summary.ClosedDays = len(closed.ClosedDates(within))
+
+	var sales, hours float64
+	for _, rec := range records {
+		if _, isClosed := closed.ReasonForDate(rec.Date); isClosed {
+			continue
+		}
+		sales += rec.TotalSales
+		hours += rec.TotalHours
+	}
+	if summary.OperatingDays > 0 {
+		summary.AvgDailySales = sales / float64(summary.OperatingDays)
+		summary.AvgDailyHours = hours / float64(summary.OperatingDays)
+	}
+	return summary
+}
+
+// ExcludeClosedDaysFromAverages recomputes the per-item averages in summary so
+// sales recorded on closed days don't count toward them. DayCount becomes the
+// number of operating days with sales; totals and percents are unchanged.
+func ExcludeClosedDaysFromAverages(store Store, locationID int, daily []DailySummary, summary *RangeSummary, closed ClosedDays) error {
+	dayPartTotals := copyTotals(summary.DayPartTotals)
+	destinationTotals := copyTotals(summary.DestinationTotals)
+	dayCount := 0
+	for _, day := range daily {
+		if day.TotalAmount == 0 {
+			continue
+		}
+		if _, isClosed := closed.ReasonForDate(day.Date); !isClosed {
+			dayCount++
+			continue
+		}
+		sales, err := store.GetSalesByDate(locationID, day.Date)
+		if err != nil {
+			return err
+		}
+		for _, sale := range sales {
+			switch sale.Category {
+			case "DayPart":
+				dayPartTotals[sale.Item] -= sale.Amount
+			case "Destination":
+				destinationTotals[sale.Item] -= sale.Amount
+			}
+		}
+	}
+
+	summary.DayCount = dayCount
+	summary.DayPartAverages = averages(dayPartTotals, dayCount)
+	summary.DestinationAverages = averages(destinationTotals, dayCount)
+	return nil
+}
+
+func copyTotals(totals map[string]float64) map[string]float64 {
+	copied := make(map[string]float64, len(totals))
+	for k, v := range totals {
+		copied[k] = v
+	}
+	return copied
+}
+
+func averages(totals map[string]float64, days int) map[string]float64 {
+	avg := make(map[string]float64, len(totals))
+	for k, v := range totals {
+		if days > 0 {
+			avg[k] = v / float64(days)
+		} else {
+			avg[k] = 0
+		}
+	}
+	return avg
+}
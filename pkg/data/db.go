@@ -0,0 +1,19 @@
+package data
+
+import "database/sql"
+
+// Open points DB at the SQLite file at path instead of the default one
+// InitDB uses. A new or empty file gets the core schema from the baseline
+// migration when MigrateUp runs.
+func Open(path string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+	DB = db
+	return nil
+}
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrAliasNotFound = errors.New("alias not found")
+
+// EmployeeAlias is another spelling of an employee's name, such as how it
+// appears in HotSchedules, that name matching should accept.
+type EmployeeAlias struct {
+	ID         int
+	LocationID int
+	EmployeeID int
+	FirstName  string
+	LastName   string
+	CreatedAt  time.Time
+}
+
+// GetEmployeeAliases returns the location's aliases grouped by employee.
+func GetEmployeeAliases(locationID int) (map[int][]EmployeeAlias, error) {
+	rows, err := DB.Query(
+		"SELECT id, location_id, employee_id, first_name, last_name, created_at FROM employee_aliases WHERE location_id = ? ORDER BY last_name, first_name",
+		locationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := map[int][]EmployeeAlias{}
+	for rows.Next() {
+		var a EmployeeAlias
+		if err := rows.Scan(&a.ID, &a.LocationID, &a.EmployeeID, &a.FirstName, &a.LastName, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		aliases[a.EmployeeID] = append(aliases[a.EmployeeID], a)
+	}
+	return aliases, rows.Err()
+}
+
+// AddEmployeeAlias records an alias, doing nothing if the employee already
+// has it, and writes audit in the same transaction.
+func AddEmployeeAlias(locationID, employeeID int, firstName, lastName string, audit AuditEntry) error {
+	firstName, lastName = strings.TrimSpace(firstName), strings.TrimSpace(lastName)
+	if firstName == "" || lastName == "" {
+		return errors.New("alias needs a first and last name")
+	}
+	return updateControl(func(tx *Tx) error {
+		if _, err := tx.Exec(
+			`INSERT INTO employee_aliases (location_id, employee_id, first_name, last_name, created_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(employee_id, first_name, last_name) DO NOTHING`,
+			locationID, employeeID, firstName, lastName, time.Now(),
+		); err != nil {
+			return err
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+func DeleteEmployeeAlias(employeeID, id int, audit AuditEntry) error {
+	return updateControl(func(tx *Tx) error {
+		res, err := tx.Exec("DELETE FROM employee_aliases WHERE id = ? AND employee_id = ?", id, employeeID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrAliasNotFound
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
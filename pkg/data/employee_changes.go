@@ -0,0 +1,96 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type EmployeeChangeKind string
+
+const (
+	EmployeeCreate    EmployeeChangeKind = "create"
+	EmployeeUpdate    EmployeeChangeKind = "update"
+	EmployeeTerminate EmployeeChangeKind = "terminate"
+	EmployeeReinstate EmployeeChangeKind = "reinstate"
+)
+
+// EmployeeChange is one roster edit in a batch passed to
+// Store.ApplyEmployeeChanges. An update writes every field, so callers start
+// from the employee's current values.
+type EmployeeChange struct {
+	Kind            EmployeeChangeKind `json:"kind"`
+	EmployeeID      int                `json:"employeeId,omitempty"`
+	FirstName       string             `json:"firstName,omitempty"`
+	LastName        string             `json:"lastName,omitempty"`
+	Birthday        string             `json:"birthday,omitempty"`
+	Department      string             `json:"department,omitempty"`
+	AnnualSalary    float64            `json:"annualSalary,omitempty"`
+	TerminationDate string             `json:"terminationDate,omitempty"`
+}
+
+// EmployeeChangeError reports which change in a batch failed. The whole batch
+// was rolled back.
+type EmployeeChangeError struct {
+	Index int
+	Err   error
+}
+
+func (e *EmployeeChangeError) Error() string {
+	return fmt.Sprintf("change %d: %v", e.Index+1, e.Err)
+}
+
+func (e *EmployeeChangeError) Unwrap() error { return e.Err }
+
+// ApplyEmployeeChanges applies changes to the location's employees in one
+// transaction. If any change fails, none of them are kept and the error is an
+// *EmployeeChangeError. Creates have their EmployeeID set to the new row's ID.
+func ApplyEmployeeChanges(locationID int, changes []EmployeeChange) error {
+	return SQLiteStore{}.Update(func(tx *Tx) error {
+		return ApplyEmployeeChangesTx(tx, locationID, changes)
+	})
+}
+
+// ApplyEmployeeChangesTx applies changes inside tx, so callers can commit
+// other bookkeeping with them. It is how every store applies a batch.
+func ApplyEmployeeChangesTx(tx *Tx, locationID int, changes []EmployeeChange) error {
+	for i, c := range changes {
+		var res sql.Result
+		var err error
+		switch c.Kind {
+		case EmployeeCreate:
+			err = tx.QueryRow("INSERT INTO employees (location_id, first_name, last_name) VALUES (?, ?, ?) RETURNING id", locationID, c.FirstName, c.LastName).
+				Scan(&changes[i].EmployeeID)
+		case EmployeeUpdate:
+			res, err = tx.Exec(
+				"UPDATE employees SET first_name = ?, last_name = ?, birthday = ?, department = ?, annual_salary = ? WHERE id = ? AND location_id = ?",
+				c.FirstName, c.LastName, c.Birthday, c.Department, c.AnnualSalary, c.EmployeeID, locationID,
+			)
+		case EmployeeTerminate:
+			res, err = tx.Exec("UPDATE employees SET terminated = ?, termination_date = ? WHERE id = ? AND location_id = ?", true, c.TerminationDate, c.EmployeeID, locationID)
+		case EmployeeReinstate:
+			res, err = tx.Exec("UPDATE employees SET terminated = ?, termination_date = '' WHERE id = ? AND location_id = ?", false, c.EmployeeID, locationID)
+		default:
+			err = fmt.Errorf("unknown employee change %q", c.Kind)
+		}
+		if err == nil && res != nil {
+			err = RequireEmployeeRow(res, c.EmployeeID)
+		}
+		if err != nil {
+			return &EmployeeChangeError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// RequireEmployeeRow turns an update that matched no employee into an error,
+// so a stale or foreign ID fails the batch instead of being skipped.
+func RequireEmployeeRow(res sql.Result, employeeID int) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("employee %d not found at this location", employeeID)
+	}
+	return nil
+}
@@ -0,0 +1,541 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ImportBio         = "bio"
+	ImportBirthdates  = "birthdates"
+	ImportDepartments = "departments"
+	ImportTimePunch   = "timepunch"
+)
+
+// A run with a plan waits as pending until someone applies it; the others
+// are recorded as applied.
+const (
+	ImportPending = "pending"
+	ImportApplied = "applied"
+)
+
+// ImportPreviewLifetime is how long a pending run waits to be applied before
+// PurgeExpiredImportPreviews removes it.
+const ImportPreviewLifetime = 24 * time.Hour
+
+var (
+	ErrImportRunNotFound   = errors.New("import run not found")
+	ErrImportRunApplied    = errors.New("import run has already been applied")
+	ErrImportRunNotApplied = errors.New("import run has not been applied")
+	ErrImportRunRolledBack = errors.New("import run has already been rolled back")
+	ErrImportPlanIndex     = errors.New("selected change is not part of the import")
+)
+
+// ImportedChange is one employee change an import run applied, with what it
+// takes to undo it.
+type ImportedChange struct {
+	Name    string         `json:"name"`
+	Summary string         `json:"summary"`
+	Change  EmployeeChange `json:"change"`
+	// Created is set when the change added the employee. Rolling back moves
+	// them to the trash.
+	Created bool `json:"created,omitempty"`
+	// Fields are the employee fields the change altered. Rolling back
+	// restores these and nothing else. In a plan they are the fields an
+	// update means to set; see applyImportChanges.
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is one employee column an import changed. Values are the
+// column's Go value: a string, bool or float64.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// employeeFields are the employee columns an import can change.
+var employeeFields = []struct {
+	column string
+	value  func(Employee) any
+}{
+	{"first_name", func(e Employee) any { return e.FirstName }},
+	{"last_name", func(e Employee) any { return e.LastName }},
+	{"birthday", func(e Employee) any { return e.Birthday }},
+	{"department", func(e Employee) any { return e.Department }},
+	{"annual_salary", func(e Employee) any { return e.AnnualSalary }},
+	{"terminated", func(e Employee) any { return e.Terminated }},
+	{"termination_date", func(e Employee) any { return e.TerminationDate }},
+}
+
+func changedFields(before, after Employee) []FieldChange {
+	var fields []FieldChange
+	for _, f := range employeeFields {
+		if b, a := f.value(before), f.value(after); b != a {
+			fields = append(fields, FieldChange{Field: f.column, Before: b, After: a})
+		}
+	}
+	return fields
+}
+
+func employeeField(emp Employee, column string) (any, bool) {
+	for _, f := range employeeFields {
+		if f.column == column {
+			return f.value(emp), true
+		}
+	}
+	return nil, false
+}
+
+// ImportConflict is a field an import changed that has been edited since.
+type ImportConflict struct {
+	Name     string
+	Field    string
+	Imported any
+	// Current is nil when the employee no longer exists.
+	Current any
+}
+
+// ImportConflictError refuses a rollback that would overwrite later edits.
+// Nothing was rolled back.
+type ImportConflictError struct {
+	Conflicts []ImportConflict
+}
+
+func (e *ImportConflictError) Error() string {
+	return fmt.Sprintf("%d field(s) changed by the import have been edited since", len(e.Conflicts))
+}
+
+// ImportRun is one uploaded or pasted import file and the changes it made.
+type ImportRun struct {
+	ID          int
+	LocationID  int
+	Kind        string
+	Filename    string
+	ContentType string
+	// Source is only loaded by GetImportRun; Size is always set.
+	Source   []byte
+	Size     int
+	UserID   int
+	Username string
+	Status   string
+	// Plan is what the import offered when it was read. Applying it keeps the
+	// chosen entries, which become Changes.
+	Plan    []ImportedChange
+	Changes []ImportedChange
+	// Unmatched holds the rows the import read but couldn't match to one
+	// employee, for linking by hand later.
+	Unmatched    []UnmatchedImportRow
+	CreatedAt    time.Time
+	RolledBackBy string
+	RolledBackAt time.Time
+}
+
+// UnmatchedImportRow is a row from an import file that matched no employee,
+// or several. Value is what the row would set, such as a birthday.
+type UnmatchedImportRow struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (r ImportRun) KindLabel() string {
+	switch r.Kind {
+	case ImportBio:
+		return "Employees (Bio)"
+	case ImportBirthdates:
+		return "Birthdates"
+	case ImportDepartments:
+		return "Departments (HotSchedules)"
+	case ImportTimePunch:
+		return "Time Punch Report"
+	}
+	return r.Kind
+}
+
+func (r ImportRun) RolledBack() bool {
+	return !r.RolledBackAt.IsZero()
+}
+
+func (r ImportRun) CanRollBack() bool {
+	return r.Status == ImportApplied && len(r.Changes) > 0 && !r.RolledBack()
+}
+
+// Import runs live in the store's database, next to the employees they
+// change, so a run's changes are recorded in the same transaction that
+// applies them.
+
+// CreateImportRun stores the run's source file and plan and returns its ID.
+// Runs with a plan start out pending; see ApplyImportPlan.
+func CreateImportRun(store Store, run ImportRun) (int, error) {
+	if run.CreatedAt.IsZero() {
+		run.CreatedAt = time.Now()
+	}
+	if run.Source == nil {
+		run.Source = []byte{}
+	}
+	run.Status = ImportApplied
+	plan := ""
+	if len(run.Plan) > 0 {
+		run.Status = ImportPending
+		encoded, err := json.Marshal(run.Plan)
+		if err != nil {
+			return 0, err
+		}
+		plan = string(encoded)
+	}
+	unmatched := ""
+	if len(run.Unmatched) > 0 {
+		encoded, err := json.Marshal(run.Unmatched)
+		if err != nil {
+			return 0, err
+		}
+		unmatched = string(encoded)
+	}
+	var id int
+	err := store.Update(func(tx *Tx) error {
+		return tx.QueryRow(
+			`INSERT INTO import_runs (location_id, kind, filename, content_type, source, user_id, username, status, plan, unmatched, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+			run.LocationID, run.Kind, run.Filename, run.ContentType, run.Source, run.UserID, run.Username, run.Status, plan, unmatched, run.CreatedAt,
+		).Scan(&id)
+	})
+	return id, err
+}
+
+// ApplyImportPlan applies the selected entries of a pending run's plan, by
+// index, and marks the run applied, all in one transaction. Only the stored
+// plan is applied, and only once: a run that is no longer pending fails with
+// ErrImportRunApplied. The run is returned with Changes holding what was
+// kept; a failed change is reported as an *EmployeeChangeError indexing
+// Changes as they would have been. audit describes each applied change; the
+// entries are written in the same transaction.
+func ApplyImportPlan(store Store, locationID, runID int, selected []int, audit func(ImportedChange) AuditEntry) (ImportRun, error) {
+	var run ImportRun
+	err := store.Update(func(tx *Tx) error {
+		var err error
+		run, err = claimImportRun(tx, locationID, runID, ImportPending, ImportApplied)
+		if err != nil {
+			return err
+		}
+		changes := make([]ImportedChange, 0, len(selected))
+		for _, i := range selected {
+			if i < 0 || i >= len(run.Plan) {
+				return ErrImportPlanIndex
+			}
+			changes = append(changes, run.Plan[i])
+		}
+		run.Changes = changes
+		if err := applyImportChanges(tx, locationID, changes, audit); err != nil {
+			return err
+		}
+		return saveImportChanges(tx, runID, changes)
+	})
+	return run, err
+}
+
+// ApplyImportChanges applies changes to an applied run's location and
+// appends them to the run in one transaction, so rows linked by hand after
+// the import are rolled back with it. The changes are returned as recorded,
+// with created employees' IDs filled in; a failed change is reported as an
+// *EmployeeChangeError. audit is as for ApplyImportPlan.
+func ApplyImportChanges(store Store, locationID, runID int, changes []ImportedChange, audit func(ImportedChange) AuditEntry) ([]ImportedChange, error) {
+	recorded := append([]ImportedChange(nil), changes...)
+	err := store.Update(func(tx *Tx) error {
+		run, err := claimImportRun(tx, locationID, runID, ImportApplied, ImportApplied)
+		if err != nil {
+			return err
+		}
+		if err := applyImportChanges(tx, locationID, recorded, audit); err != nil {
+			return err
+		}
+		return saveImportChanges(tx, runID, append(run.Changes, recorded...))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recorded, nil
+}
+
+// claimImportRun moves the run from status from to status to, failing unless
+// it is in from and not rolled back, and returns it. The update also locks
+// the run's row until tx ends, so two requests can't both act on it.
+func claimImportRun(tx *Tx, locationID, runID int, from, to string) (ImportRun, error) {
+	res, err := tx.Exec(
+		"UPDATE import_runs SET status = ? WHERE id = ? AND location_id = ? AND status = ? AND rolled_back_at IS NULL",
+		to, runID, locationID, from,
+	)
+	if err != nil {
+		return ImportRun{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return ImportRun{}, err
+	}
+	run, err := scanImportRun(tx.QueryRow("SELECT "+importRunColumns+" FROM import_runs WHERE id = ? AND location_id = ?", runID, locationID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImportRun{}, ErrImportRunNotFound
+	}
+	if err != nil || n == 1 {
+		return run, err
+	}
+	switch {
+	case run.RolledBack():
+		return run, ErrImportRunRolledBack
+	case run.Status == ImportPending:
+		return run, ErrImportRunNotApplied
+	default:
+		return run, ErrImportRunApplied
+	}
+}
+
+// applyImportChanges applies changes one at a time inside tx and works out
+// which fields each one altered by comparing the employee before and after.
+// Created employees' IDs are filled in. An update that lists the fields it
+// sets is applied on top of the employee as they are now, so a plan saved
+// at preview doesn't undo edits made to other fields since. Each change that
+// altered anything is audited.
+func applyImportChanges(tx *Tx, locationID int, changes []ImportedChange, audit func(ImportedChange) AuditEntry) error {
+	for i := range changes {
+		c := &changes[i]
+		c.Created = c.Change.Kind == EmployeeCreate
+		planned := c.Fields
+		c.Fields = nil
+		var before Employee
+		if !c.Created {
+			emp, err := employeeInTx(tx, locationID, c.Change.EmployeeID)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = fmt.Errorf("employee %d not found at this location", c.Change.EmployeeID)
+			}
+			if err != nil {
+				return &EmployeeChangeError{Index: i, Err: err}
+			}
+			before = emp
+		}
+		if c.Change.Kind == EmployeeUpdate && len(planned) > 0 {
+			c.Change = rebasedUpdate(before, planned)
+		}
+		batch := []EmployeeChange{c.Change}
+		if err := ApplyEmployeeChangesTx(tx, locationID, batch); err != nil {
+			var changeErr *EmployeeChangeError
+			if errors.As(err, &changeErr) {
+				changeErr.Index = i
+			}
+			return err
+		}
+		c.Change = batch[0]
+		if !c.Created {
+			after, err := employeeInTx(tx, locationID, c.Change.EmployeeID)
+			if err != nil {
+				return &EmployeeChangeError{Index: i, Err: err}
+			}
+			c.Fields = changedFields(before, after)
+		}
+		if c.Created || len(c.Fields) > 0 {
+			if err := CreateAuditEntryTx(tx, audit(*c)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rebasedUpdate is an update that sets fields on emp and leaves the rest as
+// they are.
+func rebasedUpdate(emp Employee, fields []FieldChange) EmployeeChange {
+	change := EmployeeChange{
+		Kind:         EmployeeUpdate,
+		EmployeeID:   emp.ID,
+		FirstName:    emp.FirstName,
+		LastName:     emp.LastName,
+		Birthday:     emp.Birthday,
+		Department:   emp.Department,
+		AnnualSalary: emp.AnnualSalary,
+	}
+	for _, f := range fields {
+		value, _ := f.After.(string)
+		switch f.Field {
+		case "first_name":
+			change.FirstName = value
+		case "last_name":
+			change.LastName = value
+		case "birthday":
+			change.Birthday = value
+		case "department":
+			change.Department = value
+		}
+	}
+	return change
+}
+
+func saveImportChanges(tx *Tx, runID int, changes []ImportedChange) error {
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE import_runs SET changes = ? WHERE id = ?", string(encoded), runID)
+	return err
+}
+
+// PurgeExpiredImportPreviews deletes pending runs, and the files kept with
+// them, once they are older than ImportPreviewLifetime.
+func PurgeExpiredImportPreviews(store Store) (int, error) {
+	var purged int64
+	err := store.Update(func(tx *Tx) error {
+		res, err := tx.Exec("DELETE FROM import_runs WHERE status = ? AND created_at < ?", ImportPending, time.Now().Add(-ImportPreviewLifetime))
+		if err != nil {
+			return err
+		}
+		purged, err = res.RowsAffected()
+		return err
+	})
+	return int(purged), err
+}
+
+// RollBackImportRun undoes a run in one transaction: each field it changed is
+// put back, newest change first, and the run is marked rolled back. If any of
+// those fields no longer holds the value the import wrote, nothing changes
+// and the error is an *ImportConflictError listing them. Employees the run
+// created are left for the caller to move to the trash; they are the run's
+// changes with Created set. audit describes each change whose fields were
+// put back, and the entries are written in the same transaction.
+func RollBackImportRun(store Store, locationID, runID int, username string, audit func(ImportedChange) AuditEntry) (ImportRun, error) {
+	var run ImportRun
+	err := store.Update(func(tx *Tx) error {
+		var err error
+		run, err = claimImportRun(tx, locationID, runID, ImportApplied, ImportApplied)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE import_runs SET rolled_back_by = ?, rolled_back_at = ? WHERE id = ?", username, time.Now(), runID); err != nil {
+			return err
+		}
+
+		var conflicts []ImportConflict
+		var restored []ImportedChange
+		for i := len(run.Changes) - 1; i >= 0; i-- {
+			c := run.Changes[i]
+			if c.Created || len(c.Fields) == 0 {
+				continue
+			}
+			current, err := employeeInTx(tx, locationID, c.Change.EmployeeID)
+			if errors.Is(err, sql.ErrNoRows) {
+				for _, f := range c.Fields {
+					conflicts = append(conflicts, ImportConflict{Name: c.Name, Field: f.Field, Imported: f.After})
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			restored = append(restored, c)
+			for _, f := range c.Fields {
+				// Only columns listed in employeeFields get this far, so
+				// the name is safe to put in the statement.
+				value, ok := employeeField(current, f.Field)
+				if !ok {
+					return fmt.Errorf("import run %d changed unknown field %q", runID, f.Field)
+				}
+				if value != f.After {
+					conflicts = append(conflicts, ImportConflict{Name: c.Name, Field: f.Field, Imported: f.After, Current: value})
+					continue
+				}
+				if _, err := tx.Exec(
+					"UPDATE employees SET "+f.Field+" = ? WHERE id = ? AND location_id = ?",
+					f.Before, c.Change.EmployeeID, locationID,
+				); err != nil {
+					return err
+				}
+			}
+		}
+		if len(conflicts) > 0 {
+			return &ImportConflictError{Conflicts: conflicts}
+		}
+		for _, c := range restored {
+			if err := CreateAuditEntryTx(tx, audit(c)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return run, err
+}
+
+const importRunColumns = "id, location_id, kind, filename, content_type, length(source), user_id, username, status, plan, changes, unmatched, created_at, rolled_back_by, rolled_back_at"
+
+// GetImportRuns returns the location's runs newest first, without their
+// source files. Pending previews are left out until they are applied.
+func GetImportRuns(store Store, locationID int) ([]ImportRun, error) {
+	var runs []ImportRun
+	err := store.Update(func(tx *Tx) error {
+		rows, err := tx.Query(
+			"SELECT "+importRunColumns+" FROM import_runs WHERE location_id = ? AND status <> ? ORDER BY created_at DESC, id DESC",
+			locationID, ImportPending,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			run, err := scanImportRun(rows)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+		}
+		return rows.Err()
+	})
+	return runs, err
+}
+
+// GetImportRun returns one of the location's runs with its source file.
+func GetImportRun(store Store, locationID, id int) (ImportRun, error) {
+	var run ImportRun
+	err := store.Update(func(tx *Tx) error {
+		var err error
+		run, err = scanImportRun(tx.QueryRow(
+			"SELECT "+importRunColumns+" FROM import_runs WHERE id = ? AND location_id = ?",
+			id, locationID,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrImportRunNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return tx.QueryRow("SELECT source FROM import_runs WHERE id = ?", id).Scan(&run.Source)
+	})
+	return run, err
+}
+
+func scanImportRun(row rowScanner) (ImportRun, error) {
+	var run ImportRun
+	var plan, changes, unmatched string
+	var rolledBackAt sql.NullTime
+	if err := row.Scan(&run.ID, &run.LocationID, &run.Kind, &run.Filename, &run.ContentType, &run.Size, &run.UserID, &run.Username,
+		&run.Status, &plan, &changes, &unmatched, &run.CreatedAt, &run.RolledBackBy, &rolledBackAt); err != nil {
+		return ImportRun{}, err
+	}
+	if plan != "" {
+		if err := json.Unmarshal([]byte(plan), &run.Plan); err != nil {
+			return ImportRun{}, err
+		}
+	}
+	if changes != "" {
+		if err := json.Unmarshal([]byte(changes), &run.Changes); err != nil {
+			return ImportRun{}, err
+		}
+	}
+	if unmatched != "" {
+		if err := json.Unmarshal([]byte(unmatched), &run.Unmatched); err != nil {
+			return ImportRun{}, err
+		}
+	}
+	if rolledBackAt.Valid {
+		run.RolledBackAt = rolledBackAt.Time
+	}
+	return run, nil
+}
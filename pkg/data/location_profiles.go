@@ -0,0 +1,190 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/phillip-england/totem/pkg/calendar"
+
+	// Embedded so timezones resolve on hosts without a zoneinfo database.
+	_ "time/tzdata"
+)
+
+// LocationProfile is the metadata kept alongside a location: where it is,
+// when it is open and the goals its numbers are measured against.
+type LocationProfile struct {
+	LocationID int
+	// Timezone is an IANA name such as America/Chicago. Empty means the
+	// server's zone.
+	Timezone string
+	Address  string
+	// Hours is indexed by time.Weekday.
+	Hours              [7]DayHours
+	TargetProductivity float64
+	TargetLaborPercent float64
+	// Calendar decides week, period and fiscal-year boundaries for the
+	// location's rollups.
+	Calendar calendar.Calendar
+	// Holidays holds the calendar.Holidays keys the location closes for.
+	Holidays []string
+}
+
+// NewLocationProfile returns the profile of a location with no stored
+// metadata.
+func NewLocationProfile(locationID int) LocationProfile {
+	return LocationProfile{LocationID: locationID, Calendar: calendar.Default()}
+}
+
+// DayHours holds opening and closing times as 24-hour "15:04" strings.
+type DayHours struct {
+	Open   string `json:"open"`
+	Close  string `json:"close"`
+	Closed bool   `json:"closed"`
+}
+
+// TimeLocation returns the location's timezone, falling back to the server's
+// zone when none is set or it can't be loaded.
+func (p LocationProfile) TimeLocation() *time.Location {
+	if p.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// Now is the current time at the location.
+func (p LocationProfile) Now() time.Time {
+	return time.Now().In(p.TimeLocation())
+}
+
+func (p LocationProfile) Validate() error {
+	var errs []error
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("unknown timezone %q", p.Timezone))
+		}
+	}
+	for day, hours := range p.Hours {
+		if hours.Closed || (hours.Open == "" && hours.Close == "") {
+			continue
+		}
+		open, err1 := time.Parse("15:04", hours.Open)
+		close, err2 := time.Parse("15:04", hours.Close)
+		if err1 != nil || err2 != nil {
+			errs = append(errs, fmt.Errorf("%s: open and close must both be times like 06:30", time.Weekday(day)))
+			continue
+		}
+		// Closing at or before opening means the location closes after
+		// midnight, which is allowed; only identical times are rejected.
+		if open.Equal(close) {
+			errs = append(errs, fmt.Errorf("%s: open and close times are the same", time.Weekday(day)))
+		}
+	}
+	if p.TargetProductivity < 0 {
+		errs = append(errs, errors.New("target productivity must not be negative"))
+	}
+	if p.TargetLaborPercent < 0 || p.TargetLaborPercent > 100 {
+		errs = append(errs, errors.New("target labor percent must be between 0 and 100"))
+	}
+	if err := p.Calendar.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, key := range p.Holidays {
+		if _, ok := calendar.HolidayByKey(key); !ok {
+			errs = append(errs, fmt.Errorf("unknown holiday %q", key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EncodeHours and DecodeHours convert Hours to and from the JSON stored by
+// each Store.
+func EncodeHours(hours [7]DayHours) string {
+	encoded, _ := json.Marshal(hours)
+	return string(encoded)
+}
+
+func DecodeHours(value string) [7]DayHours {
+	var hours [7]DayHours
+	if strings.TrimSpace(value) != "" {
+		_ = json.Unmarshal([]byte(value), &hours)
+	}
+	return hours
+}
+
+// EncodeHolidays and DecodeHolidays store Holidays as a comma-separated list.
+func EncodeHolidays(keys []string) string {
+	return strings.Join(keys, ",")
+}
+
+func DecodeHolidays(value string) []string {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// GetLocationProfile returns the stored profile, or an empty one when the
+// location has never been given metadata.
+func GetLocationProfile(locationID int) (LocationProfile, error) {
+	profile := NewLocationProfile(locationID)
+	var hours, holidays string
+	err := DB.QueryRow(
+		`SELECT timezone, address, hours, target_productivity, target_labor_percent,
+			week_start, fiscal_pattern, fiscal_year_start_month, fiscal_year_start_day, holidays
+		FROM location_profiles WHERE location_id = ?`,
+		locationID,
+	).Scan(&profile.Timezone, &profile.Address, &hours, &profile.TargetProductivity, &profile.TargetLaborPercent,
+		&profile.Calendar.WeekStart, &profile.Calendar.Pattern, &profile.Calendar.YearStartMonth, &profile.Calendar.YearStartDay, &holidays)
+	if errors.Is(err, sql.ErrNoRows) {
+		return profile, nil
+	}
+	if err != nil {
+		return profile, err
+	}
+	profile.Hours = DecodeHours(hours)
+	profile.Holidays = DecodeHolidays(holidays)
+	return profile, nil
+}
+
+func SaveLocationProfile(profile LocationProfile) error {
+	return updateControl(func(tx *Tx) error {
+		return SaveLocationProfileTx(tx, profile)
+	})
+}
+
+// SaveLocationProfileTx saves profile inside tx, so callers can commit its
+// audit entry with it.
+func SaveLocationProfileTx(tx *Tx, profile LocationProfile) error {
+	_, err := tx.Exec(
+		`INSERT INTO location_profiles (location_id, timezone, address, hours, target_productivity, target_labor_percent,
+			week_start, fiscal_pattern, fiscal_year_start_month, fiscal_year_start_day, holidays)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(location_id) DO UPDATE SET
+			timezone = excluded.timezone,
+			address = excluded.address,
+			hours = excluded.hours,
+			target_productivity = excluded.target_productivity,
+			target_labor_percent = excluded.target_labor_percent,
+			week_start = excluded.week_start,
+			fiscal_pattern = excluded.fiscal_pattern,
+			fiscal_year_start_month = excluded.fiscal_year_start_month,
+			fiscal_year_start_day = excluded.fiscal_year_start_day,
+			holidays = excluded.holidays`,
+		profile.LocationID, profile.Timezone, profile.Address, EncodeHours(profile.Hours),
+		profile.TargetProductivity, profile.TargetLaborPercent,
+		int(profile.Calendar.WeekStart), profile.Calendar.Pattern, int(profile.Calendar.YearStartMonth), profile.Calendar.YearStartDay,
+		EncodeHolidays(profile.Holidays),
+	)
+	return err
+}
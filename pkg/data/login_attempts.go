@@ -0,0 +1,252 @@
+package data
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const (
+	ThrottleUsername = "username"
+	ThrottleIP       = "ip"
+)
+
+// Login throttling policy. The first few failures are free, then each failure
+// adds an exponentially growing delay until LoginLockoutThreshold is reached,
+// after which the key is locked out for LoginLockoutBase doubling per failure.
+var (
+	LoginFreeAttempts     = 2
+	LoginLockoutThreshold = 5
+	LoginLockoutBase      = 15 * time.Minute
+	LoginLockoutMax       = 24 * time.Hour
+)
+
+type LoginAttempt struct {
+	ID        int
+	Username  string
+	IP        string
+	Success   bool
+	CreatedAt time.Time
+}
+
+type LoginThrottle struct {
+	Kind          string
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+func (t LoginThrottle) Locked(now time.Time) bool {
+	return now.Before(t.LockedUntil)
+}
+
+// LoginBackoff returns how long a key must wait after its nth consecutive
+// failure.
+func LoginBackoff(failures int) time.Duration {
+	if failures <= LoginFreeAttempts {
+		return 0
+	}
+	if failures < LoginLockoutThreshold {
+		return time.Duration(1<<(failures-LoginFreeAttempts)) * time.Second
+	}
+	wait := LoginLockoutBase
+	for i := LoginLockoutThreshold; i < failures && wait < LoginLockoutMax; i++ {
+		wait *= 2
+	}
+	if wait > LoginLockoutMax {
+		wait = LoginLockoutMax
+	}
+	return wait
+}
+
+func normalizeThrottleKey(kind, key string) string {
+	key = strings.TrimSpace(key)
+	if kind == ThrottleUsername {
+		key = strings.ToLower(key)
+	}
+	return key
+}
+
+// LoginBlockedUntil reports the latest time either the username or the IP is
+// blocked until, or the zero time when neither is blocked.
+func LoginBlockedUntil(username, ip string) (time.Time, error) {
+	now := time.Now()
+	var blockedUntil time.Time
+	for _, kv := range [][2]string{{ThrottleUsername, username}, {ThrottleIP, ip}} {
+		throttle, err := GetLoginThrottle(kv[0], kv[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+		if throttle.Locked(now) && throttle.LockedUntil.After(blockedUntil) {
+			blockedUntil = throttle.LockedUntil
+		}
+	}
+	return blockedUntil, nil
+}
+
+func GetLoginThrottle(kind, key string) (LoginThrottle, error) {
+	key = normalizeThrottleKey(kind, key)
+	throttle := LoginThrottle{Kind: kind, Key: key}
+	if key == "" {
+		return throttle, nil
+	}
+	var lastFailure, lockedUntil sql.NullTime
+	err := DB.QueryRow(
+		"SELECT failures, last_failure_at, locked_until FROM login_throttles WHERE kind = ? AND key = ?",
+		kind, key,
+	).Scan(&throttle.Failures, &lastFailure, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return throttle, nil
+	}
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	throttle.LastFailureAt = lastFailure.Time
+	throttle.LockedUntil = lockedUntil.Time
+	return throttle, nil
+}
+
+// RecordLoginAttempt logs an attempt and updates the throttles. Failures count
+// against both the username and the IP; a success resets the username.
+func RecordLoginAttempt(username, ip string, success bool) error {
+	now := time.Now()
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(
+		"INSERT INTO login_attempts (username, ip, success, created_at) VALUES (?, ?, ?, ?)",
+		strings.TrimSpace(username), ip, success, now,
+	); err != nil {
+		return err
+	}
+
+	if success {
+		if _, err := tx.Exec("DELETE FROM login_throttles WHERE kind = ? AND key = ?", ThrottleUsername, normalizeThrottleKey(ThrottleUsername, username)); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	for _, kv := range [][2]string{{ThrottleUsername, username}, {ThrottleIP, ip}} {
+		key := normalizeThrottleKey(kv[0], kv[1])
+		if key == "" {
+			continue
+		}
+		var failures int
+		err := tx.QueryRow("SELECT failures FROM login_throttles WHERE kind = ? AND key = ?", kv[0], key).Scan(&failures)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		failures++
+		lockedUntil := now.Add(LoginBackoff(failures))
+		if _, err := tx.Exec(
+			`INSERT INTO login_throttles (kind, key, failures, last_failure_at, locked_until) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(kind, key) DO UPDATE SET failures = excluded.failures, last_failure_at = excluded.last_failure_at, locked_until = excluded.locked_until`,
+			kv[0], key, failures, now, lockedUntil,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetLoginThrottles returns every key with recorded failures, most recent
+// failure first.
+func GetLoginThrottles() ([]LoginThrottle, error) {
+	rows, err := DB.Query("SELECT kind, key, failures, last_failure_at, locked_until FROM login_throttles ORDER BY last_failure_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var throttles []LoginThrottle
+	for rows.Next() {
+		var throttle LoginThrottle
+		var lastFailure, lockedUntil sql.NullTime
+		if err := rows.Scan(&throttle.Kind, &throttle.Key, &throttle.Failures, &lastFailure, &lockedUntil); err != nil {
+			return nil, err
+		}
+		throttle.LastFailureAt = lastFailure.Time
+		throttle.LockedUntil = lockedUntil.Time
+		throttles = append(throttles, throttle)
+	}
+	return throttles, rows.Err()
+}
+
+func ClearLoginThrottle(kind, key string) error {
+	_, err := DB.Exec("DELETE FROM login_throttles WHERE kind = ? AND key = ?", kind, normalizeThrottleKey(kind, key))
+	return err
+}
+
+func GetRecentLoginAttempts(limit int) ([]LoginAttempt, error) {
+	rows, err := DB.Query("SELECT id, username, ip, success, created_at FROM login_attempts ORDER BY created_at DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []LoginAttempt
+	for rows.Next() {
+		var attempt LoginAttempt
+		if err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.IP, &attempt.Success, &attempt.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// LoginScope is the slice of login throttles and attempts an organization's
+// admins may see: their own users' usernames and the IPs those usernames were
+// tried from.
+type LoginScope struct {
+	Usernames map[string]bool
+	IPs       map[string]bool
+}
+
+func LoginScopeForUsers(users []User) (LoginScope, error) {
+	scope := LoginScope{Usernames: map[string]bool{}, IPs: map[string]bool{}}
+	if len(users) == 0 {
+		return scope, nil
+	}
+	placeholders := make([]string, 0, len(users))
+	args := make([]any, 0, len(users))
+	for _, u := range users {
+		name := normalizeThrottleKey(ThrottleUsername, u.Username)
+		scope.Usernames[name] = true
+		placeholders = append(placeholders, "?")
+		args = append(args, name)
+	}
+	rows, err := DB.Query("SELECT DISTINCT ip FROM login_attempts WHERE lower(username) IN ("+strings.Join(placeholders, ", ")+")", args...)
+	if err != nil {
+		return scope, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return scope, err
+		}
+		scope.IPs[ip] = true
+	}
+	return scope, rows.Err()
+}
+
+func (s LoginScope) HasThrottle(kind, key string) bool {
+	key = normalizeThrottleKey(kind, key)
+	switch kind {
+	case ThrottleUsername:
+		return s.Usernames[key]
+	case ThrottleIP:
+		return s.IPs[key]
+	}
+	return false
+}
+
+func (s LoginScope) HasAttempt(attempt LoginAttempt) bool {
+	return s.Usernames[normalizeThrottleKey(ThrottleUsername, attempt.Username)]
+}
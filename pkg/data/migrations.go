@@ -0,0 +1,638 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Migration is one numbered schema change. Up and Down run in a single
+// transaction; Down must undo exactly what Up did.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var (
+	ErrSchemaTooNew   = errors.New("database schema is newer than this build of totem")
+	ErrNothingToUndo  = errors.New("no migrations have been applied")
+	ErrBaselineUndone = errors.New("the baseline migration cannot be rolled back")
+)
+
+// migrations must stay in version order, and a released migration must never
+// be edited; add a new one instead. Version 1 is the core schema InitDB
+// creates (locations, employees, users, sessions, sales, labor and payroll
+// events). Its statements are no-ops on a database InitDB made and create the
+// tables in an empty file opened with Open.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS locations (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				number TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS employees (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				location_id INTEGER NOT NULL,
+				first_name TEXT NOT NULL,
+				last_name TEXT NOT NULL,
+				time_punch_name TEXT NOT NULL DEFAULT '',
+				birthday TEXT NOT NULL DEFAULT '',
+				department TEXT NOT NULL DEFAULT '',
+				terminated BOOLEAN NOT NULL DEFAULT 0,
+				termination_date TEXT NOT NULL DEFAULT '',
+				annual_salary REAL NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_employees_location_id ON employees (location_id)`,
+			`CREATE TABLE IF NOT EXISTS payroll_events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				location_id INTEGER NOT NULL,
+				employee_id INTEGER NOT NULL,
+				date TEXT NOT NULL,
+				event_type TEXT NOT NULL,
+				description TEXT NOT NULL,
+				amount REAL NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_payroll_events_location_date ON payroll_events (location_id, date)`,
+			`CREATE TABLE IF NOT EXISTS sales (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				location_id INTEGER NOT NULL,
+				date TEXT NOT NULL,
+				category TEXT NOT NULL,
+				item TEXT NOT NULL,
+				amount REAL NOT NULL,
+				UNIQUE (location_id, date, category, item)
+			)`,
+			`CREATE TABLE IF NOT EXISTS labor (
+				location_id INTEGER NOT NULL,
+				date TEXT NOT NULL,
+				regular_hours REAL NOT NULL DEFAULT 0,
+				overtime_hours REAL NOT NULL DEFAULT 0,
+				regular_wages REAL NOT NULL DEFAULT 0,
+				overtime_wages REAL NOT NULL DEFAULT 0,
+				PRIMARY KEY (location_id, date)
+			)`,
+			`CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL UNIQUE,
+				password_hash TEXT NOT NULL,
+				role TEXT NOT NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS sessions (
+				key TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				payload TEXT NOT NULL,
+				expires_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "user_locations",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS user_locations (
+				user_id INTEGER NOT NULL,
+				location_id INTEGER NOT NULL,
+				PRIMARY KEY (user_id, location_id)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS user_locations`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "api_tokens",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS api_tokens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				created_by INTEGER NOT NULL,
+				all_locations BOOLEAN NOT NULL DEFAULT 0,
+				created_at DATETIME NOT NULL,
+				last_used_at DATETIME,
+				revoked_at DATETIME
+			)`,
+			`CREATE TABLE IF NOT EXISTS api_token_locations (
+				token_id INTEGER NOT NULL,
+				location_id INTEGER NOT NULL,
+				PRIMARY KEY (token_id, location_id)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS api_token_locations`,
+			`DROP TABLE IF EXISTS api_tokens`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "login_throttling",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS login_attempts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL,
+				ip TEXT NOT NULL,
+				success BOOLEAN NOT NULL,
+				created_at DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_login_attempts_created_at ON login_attempts (created_at)`,
+			`CREATE TABLE IF NOT EXISTS login_throttles (
+				kind TEXT NOT NULL,
+				key TEXT NOT NULL,
+				failures INTEGER NOT NULL DEFAULT 0,
+				last_failure_at DATETIME,
+				locked_until DATETIME,
+				PRIMARY KEY (kind, key)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS login_throttles`,
+			`DROP TABLE IF EXISTS login_attempts`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "session_info",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS session_info (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				session_key TEXT NOT NULL UNIQUE,
+				user_id INTEGER NOT NULL,
+				ip TEXT NOT NULL DEFAULT '',
+				user_agent TEXT NOT NULL DEFAULT '',
+				remember_me BOOLEAN NOT NULL DEFAULT 0,
+				created_at DATETIME NOT NULL,
+				last_seen_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL,
+				idle_expires_at DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_session_info_user_id ON session_info (user_id)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS session_info`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "two_factor",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS app_settings (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS user_totp (
+				user_id INTEGER PRIMARY KEY,
+				secret TEXT NOT NULL,
+				enabled BOOLEAN NOT NULL DEFAULT 0,
+				enrolled_at DATETIME,
+				last_step INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE IF NOT EXISTS user_recovery_codes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				code_hash TEXT NOT NULL,
+				used_at DATETIME
+			)`,
+			`CREATE TABLE IF NOT EXISTS login_challenges (
+				token_hash TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				remember_me BOOLEAN NOT NULL DEFAULT 0,
+				expires_at DATETIME NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS login_challenges`,
+			`DROP TABLE IF EXISTS user_recovery_codes`,
+			`DROP TABLE IF EXISTS user_totp`,
+			`DROP TABLE IF EXISTS app_settings`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "session_signing_keys",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS session_signing_keys (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				secret TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				retired_at DATETIME
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS session_signing_keys`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "disabled_users",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS disabled_users (
+				user_id INTEGER PRIMARY KEY,
+				disabled_at DATETIME NOT NULL
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS disabled_users`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "audit_log",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS audit_log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL DEFAULT 0,
+				username TEXT NOT NULL DEFAULT '',
+				location_id INTEGER NOT NULL DEFAULT 0,
+				route TEXT NOT NULL,
+				entity_type TEXT NOT NULL,
+				entity_id TEXT NOT NULL DEFAULT '',
+				before_value TEXT NOT NULL DEFAULT '',
+				after_value TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_location_id ON audit_log (location_id)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS audit_log`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "trash",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS trash (
+				entity_type TEXT NOT NULL,
+				entity_id INTEGER NOT NULL,
+				location_id INTEGER NOT NULL DEFAULT 0,
+				label TEXT NOT NULL DEFAULT '',
+				deleted_by INTEGER NOT NULL DEFAULT 0,
+				deleted_at DATETIME NOT NULL,
+				PRIMARY KEY (entity_type, entity_id)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS trash`,
+		},
+	},
+	{
+		Version: 11,
+		Name:    "organizations",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS organizations (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL UNIQUE,
+				created_at DATETIME NOT NULL
+			)`,
+			`INSERT INTO organizations (id, name, created_at) VALUES (1, 'Default Organization', CURRENT_TIMESTAMP)`,
+			`CREATE TABLE IF NOT EXISTS organization_locations (
+				location_id INTEGER PRIMARY KEY,
+				organization_id INTEGER NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS organization_users (
+				user_id INTEGER PRIMARY KEY,
+				organization_id INTEGER NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS super_admins (
+				user_id INTEGER PRIMARY KEY
+			)`,
+			// Existing owners keep seeing everything they saw before.
+			`INSERT INTO super_admins (user_id) SELECT id FROM users WHERE role IN ('owner', 'admin')`,
+			`CREATE TABLE IF NOT EXISTS organization_settings (
+				organization_id INTEGER NOT NULL,
+				key TEXT NOT NULL,
+				value TEXT NOT NULL,
+				PRIMARY KEY (organization_id, key)
+			)`,
+			`INSERT INTO organization_settings (organization_id, key, value) SELECT 1, key, value FROM app_settings`,
+			`DROP TABLE app_settings`,
+			`ALTER TABLE audit_log ADD COLUMN organization_id INTEGER NOT NULL DEFAULT 1`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_organization_id ON audit_log (organization_id)`,
+			`ALTER TABLE api_tokens ADD COLUMN organization_id INTEGER NOT NULL DEFAULT 1`,
+		},
+		Down: []string{
+			`ALTER TABLE api_tokens DROP COLUMN organization_id`,
+			`DROP INDEX IF EXISTS idx_audit_log_organization_id`,
+			`ALTER TABLE audit_log DROP COLUMN organization_id`,
+			`CREATE TABLE IF NOT EXISTS app_settings (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			)`,
+			`INSERT INTO app_settings (key, value) SELECT key, value FROM organization_settings WHERE organization_id = 1`,
+			`DROP TABLE IF EXISTS organization_settings`,
+			`DROP TABLE IF EXISTS super_admins`,
+			`DROP TABLE IF EXISTS organization_users`,
+			`DROP TABLE IF EXISTS organization_locations`,
+			`DROP TABLE IF EXISTS organizations`,
+		},
+	},
+	{
+		Version: 12,
+		Name:    "location_profiles",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS location_profiles (
+				location_id INTEGER PRIMARY KEY,
+				timezone TEXT NOT NULL DEFAULT '',
+				address TEXT NOT NULL DEFAULT '',
+				hours TEXT NOT NULL DEFAULT '',
+				target_productivity REAL NOT NULL DEFAULT 0,
+				target_labor_percent REAL NOT NULL DEFAULT 0
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS location_profiles`,
+		},
+	},
+	{
+		Version: 13,
+		Name:    "location_calendars",
+		Up: []string{
+			`ALTER TABLE location_profiles ADD COLUMN week_start INTEGER NOT NULL DEFAULT 1`,
+			`ALTER TABLE location_profiles ADD COLUMN fiscal_pattern TEXT NOT NULL DEFAULT 'monthly'`,
+			`ALTER TABLE location_profiles ADD COLUMN fiscal_year_start_month INTEGER NOT NULL DEFAULT 1`,
+			`ALTER TABLE location_profiles ADD COLUMN fiscal_year_start_day INTEGER NOT NULL DEFAULT 1`,
+		},
+		Down: []string{
+			`ALTER TABLE location_profiles DROP COLUMN fiscal_year_start_day`,
+			`ALTER TABLE location_profiles DROP COLUMN fiscal_year_start_month`,
+			`ALTER TABLE location_profiles DROP COLUMN fiscal_pattern`,
+			`ALTER TABLE location_profiles DROP COLUMN week_start`,
+		},
+	},
+	{
+		Version: 14,
+		Name:    "closed_days",
+		Up: []string{
+			`ALTER TABLE location_profiles ADD COLUMN holidays TEXT NOT NULL DEFAULT ''`,
+			`CREATE TABLE IF NOT EXISTS location_closures (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				location_id INTEGER NOT NULL,
+				date TEXT NOT NULL,
+				name TEXT NOT NULL,
+				UNIQUE (location_id, date)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS location_closures`,
+			`ALTER TABLE location_profiles DROP COLUMN holidays`,
+		},
+	},
+	{
+		Version: 15,
+		Name:    "import_runs",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS import_runs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				location_id INTEGER NOT NULL,
+				kind TEXT NOT NULL,
+				filename TEXT NOT NULL DEFAULT '',
+				content_type TEXT NOT NULL DEFAULT '',
+				source BLOB NOT NULL,
+				user_id INTEGER NOT NULL DEFAULT 0,
+				username TEXT NOT NULL DEFAULT '',
+				changes TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				rolled_back_by TEXT NOT NULL DEFAULT '',
+				rolled_back_at DATETIME
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_import_runs_location ON import_runs (location_id, created_at)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS import_runs`,
+		},
+	},
+	{
+		Version: 16,
+		Name:    "employee_aliases",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS employee_aliases (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				location_id INTEGER NOT NULL,
+				employee_id INTEGER NOT NULL,
+				first_name TEXT NOT NULL,
+				last_name TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				UNIQUE (employee_id, first_name, last_name)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_employee_aliases_location ON employee_aliases (location_id)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS employee_aliases`,
+		},
+	},
+	{
+		Version: 17,
+		Name:    "session_organization",
+		Up: []string{
+			`ALTER TABLE session_info ADD COLUMN organization_id INTEGER NOT NULL DEFAULT 0`,
+		},
+		Down: []string{
+			`ALTER TABLE session_info DROP COLUMN organization_id`,
+		},
+	},
+	{
+		Version: 18,
+		Name:    "store_binding",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS store_binding (
+				id INTEGER PRIMARY KEY CHECK (id = 1),
+				backend TEXT NOT NULL,
+				instance_id TEXT NOT NULL,
+				bound_at DATETIME NOT NULL
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS store_binding`,
+		},
+	},
+	{
+		Version: 19,
+		Name:    "import_plans",
+		Up: []string{
+			`ALTER TABLE import_runs ADD COLUMN status TEXT NOT NULL DEFAULT 'applied'`,
+			`ALTER TABLE import_runs ADD COLUMN plan TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE import_runs DROP COLUMN plan`,
+			`ALTER TABLE import_runs DROP COLUMN status`,
+		},
+	},
+	{
+		Version: 20,
+		Name:    "import_unmatched_rows",
+		Up: []string{
+			`ALTER TABLE import_runs ADD COLUMN unmatched TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE import_runs DROP COLUMN unmatched`,
+		},
+	},
+}
+
+func Migrations() []Migration {
+	return migrations
+}
+
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func ensureMigrationsTable() error {
+	_, err := DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`)
+	return err
+}
+
+// SchemaVersion returns the highest applied migration, or 0 for a database
+// that predates schema_migrations.
+func SchemaVersion() (int, error) {
+	if err := ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := DB.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// CheckSchemaVersion refuses to run against a database migrated by a newer
+// build, whose schema this binary may misread or corrupt.
+func CheckSchemaVersion() error {
+	version, err := SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > LatestSchemaVersion() {
+		return fmt.Errorf("%w: database is at version %d, this build knows up to %d", ErrSchemaTooNew, version, LatestSchemaVersion())
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration in order and returns the ones it
+// applied.
+func MigrateUp() ([]Migration, error) {
+	if err := CheckSchemaVersion(); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var ran []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := runMigration(m, m.Up, true); err != nil {
+			return ran, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+// MigrateDown rolls back the most recently applied migration.
+func MigrateDown() (Migration, error) {
+	if err := CheckSchemaVersion(); err != nil {
+		return Migration{}, err
+	}
+	version, err := SchemaVersion()
+	if err != nil {
+		return Migration{}, err
+	}
+	if version == 0 {
+		return Migration{}, ErrNothingToUndo
+	}
+	if version == 1 {
+		return Migration{}, ErrBaselineUndone
+	}
+	for _, m := range migrations {
+		if m.Version != version {
+			continue
+		}
+		if err := runMigration(m, m.Down, false); err != nil {
+			return m, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		return m, nil
+	}
+	return Migration{}, fmt.Errorf("applied migration %d is unknown to this build", version)
+}
+
+func GetMigrationStatus() ([]MigrationStatus, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt})
+	}
+	return statuses, nil
+}
+
+func appliedMigrations() (map[int]time.Time, error) {
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := DB.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func runMigration(m Migration, stmts []string, up bool) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,292 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultOrganizationID owns every location and user that was never assigned
+// to an organization, including everything created before organizations
+// existed and locations kept in an external Store.
+const DefaultOrganizationID = 1
+
+var ErrLastSuperAdmin = errors.New("cannot remove the last super-admin")
+
+type Organization struct {
+	ID        int
+	Name      string
+	CreatedAt time.Time
+}
+
+func GetOrganizations() ([]Organization, error) {
+	rows, err := DB.Query("SELECT id, name, created_at FROM organizations ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []Organization
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+func GetOrganizationByID(id int) (Organization, error) {
+	var org Organization
+	err := DB.QueryRow("SELECT id, name, created_at FROM organizations WHERE id = ?", id).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	return org, err
+}
+
+func CreateOrganization(name string) (int, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, errors.New("organization name is required")
+	}
+	res, err := DB.Exec("INSERT INTO organizations (name, created_at) VALUES (?, ?)", name, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// OrganizationIDForUser returns the organization the user belongs to.
+// Super-admins keep their membership when they switch organizations; the
+// organization they are working in is kept per session instead.
+func OrganizationIDForUser(userID int) (int, error) {
+	return organizationIDFrom("SELECT organization_id FROM organization_users WHERE user_id = ?", userID)
+}
+
+func OrganizationIDForLocation(locationID int) (int, error) {
+	return organizationIDFrom("SELECT organization_id FROM organization_locations WHERE location_id = ?", locationID)
+}
+
+func organizationIDFrom(query string, id int) (int, error) {
+	var orgID int
+	err := DB.QueryRow(query, id).Scan(&orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultOrganizationID, nil
+	}
+	return orgID, err
+}
+
+// MoveUserToOrganization moves a user into orgID and drops their location
+// assignments, which point into the old organization, writing audit in the
+// same transaction. It refuses to move the last admin out of an
+// organization.
+func MoveUserToOrganization(userID, orgID int, audit AuditEntry) error {
+	current, err := OrganizationIDForUser(userID)
+	if err != nil {
+		return err
+	}
+	if current == orgID {
+		return nil
+	}
+	return updateControl(func(tx *Tx) error {
+		if err := ensureAnotherAdmin(tx, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO organization_users (user_id, organization_id) VALUES (?, ?) ON CONFLICT(user_id) DO UPDATE SET organization_id = excluded.organization_id",
+			userID, orgID,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM user_locations WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+func SetLocationOrganization(locationID, orgID int) error {
+	_, err := DB.Exec(
+		"INSERT INTO organization_locations (location_id, organization_id) VALUES (?, ?) ON CONFLICT(location_id) DO UPDATE SET organization_id = excluded.organization_id",
+		locationID, orgID,
+	)
+	return err
+}
+
+// CreateUserInOrganization creates a user and places them in orgID, writing
+// audit, with the new ID as its EntityID, together with the membership. The
+// core CreateUser commits on its own, so a user whose membership or entry
+// can't be recorded is removed again.
+func CreateUserInOrganization(orgID int, username, password, role string, audit AuditEntry) (int, error) {
+	id, err := CreateUser(username, password, role)
+	if err != nil {
+		return 0, err
+	}
+	audit.EntityID = strconv.Itoa(id)
+	err = updateControl(func(tx *Tx) error {
+		if _, err := tx.Exec(
+			"INSERT INTO organization_users (user_id, organization_id) VALUES (?, ?) ON CONFLICT(user_id) DO UPDATE SET organization_id = excluded.organization_id",
+			id, orgID,
+		); err != nil {
+			return err
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+	if err != nil {
+		_, _ = DB.Exec("DELETE FROM users WHERE id = ?", id)
+		return 0, err
+	}
+	return id, nil
+}
+
+func DeleteLocationOrganization(locationID int) error {
+	_, err := DB.Exec("DELETE FROM organization_locations WHERE location_id = ?", locationID)
+	return err
+}
+
+// CreateLocationInOrganization inserts a location and its organization
+// mapping in one transaction and returns the new location's ID.
+func CreateLocationInOrganization(orgID int, name, number string) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec("INSERT INTO locations (name, number) VALUES (?, ?)", name, number)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO organization_locations (location_id, organization_id) VALUES (?, ?) ON CONFLICT(location_id) DO UPDATE SET organization_id = excluded.organization_id",
+		id, orgID,
+	); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+func getOrganizationMap(query string) (map[int]int, error) {
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := map[int]int{}
+	for rows.Next() {
+		var id, orgID int
+		if err := rows.Scan(&id, &orgID); err != nil {
+			return nil, err
+		}
+		orgs[id] = orgID
+	}
+	return orgs, rows.Err()
+}
+
+// LocationsInOrganization keeps the locations that belong to orgID.
+func LocationsInOrganization(locations []CfaLocation, orgID int) ([]CfaLocation, error) {
+	orgs, err := getOrganizationMap("SELECT location_id, organization_id FROM organization_locations")
+	if err != nil {
+		return nil, err
+	}
+	var kept []CfaLocation
+	for _, loc := range locations {
+		if organizationOf(orgs, loc.ID) == orgID {
+			kept = append(kept, loc)
+		}
+	}
+	return kept, nil
+}
+
+func GetUsersInOrganization(orgID int) ([]User, error) {
+	users, err := GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	orgs, err := getOrganizationMap("SELECT user_id, organization_id FROM organization_users")
+	if err != nil {
+		return nil, err
+	}
+	var kept []User
+	for _, u := range users {
+		if organizationOf(orgs, u.ID) == orgID {
+			kept = append(kept, u)
+		}
+	}
+	return kept, nil
+}
+
+func organizationOf(orgs map[int]int, id int) int {
+	if orgID, ok := orgs[id]; ok {
+		return orgID
+	}
+	return DefaultOrganizationID
+}
+
+// IsSuperAdmin reports whether the user may create organizations and switch
+// between them. Super-admins act with their own role inside whichever
+// organization they have switched to.
+func IsSuperAdmin(userID int) bool {
+	var count int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM super_admins WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return false
+	}
+	return count > 0
+}
+
+func GetSuperAdminIDs() (map[int]bool, error) {
+	rows, err := DB.Query("SELECT user_id FROM super_admins")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+func SetSuperAdmin(userID int, enabled bool, audit AuditEntry) error {
+	stmt := "INSERT OR IGNORE INTO super_admins (user_id) VALUES (?)"
+	if !enabled {
+		stmt = "DELETE FROM super_admins WHERE user_id = ?"
+	}
+	return updateControl(func(tx *Tx) error {
+		if !enabled {
+			if err := ensureAnotherSuperAdmin(tx, userID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			return err
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+func ensureAnotherSuperAdmin(tx *Tx, userID int) error {
+	var total, self int
+	err := tx.QueryRow(
+		"SELECT COUNT(*), COALESCE(SUM(CASE WHEN user_id = ? THEN 1 ELSE 0 END), 0) FROM super_admins", userID,
+	).Scan(&total, &self)
+	if err != nil {
+		return err
+	}
+	if self > 0 && total == 1 {
+		return ErrLastSuperAdmin
+	}
+	return nil
+}
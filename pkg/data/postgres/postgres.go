@@ -0,0 +1,711 @@
+// Package postgres implements data.Store on PostgreSQL so several stores'
+// worth of business data can live in one shared database.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/phillip-england/totem/pkg/data"
+)
+
+// schema mirrors the SQLite core tables. Statements are idempotent and run on
+// every Open.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS locations (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		number TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS location_profiles (
+		location_id INTEGER PRIMARY KEY REFERENCES locations (id) ON DELETE CASCADE,
+		timezone TEXT NOT NULL DEFAULT '',
+		address TEXT NOT NULL DEFAULT '',
+		hours TEXT NOT NULL DEFAULT '',
+		target_productivity DOUBLE PRECISION NOT NULL DEFAULT 0,
+		target_labor_percent DOUBLE PRECISION NOT NULL DEFAULT 0
+	)`,
+	`ALTER TABLE location_profiles ADD COLUMN IF NOT EXISTS week_start INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE location_profiles ADD COLUMN IF NOT EXISTS fiscal_pattern TEXT NOT NULL DEFAULT 'monthly'`,
+	`ALTER TABLE location_profiles ADD COLUMN IF NOT EXISTS fiscal_year_start_month INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE location_profiles ADD COLUMN IF NOT EXISTS fiscal_year_start_day INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE location_profiles ADD COLUMN IF NOT EXISTS holidays TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS location_closures (
+		id SERIAL PRIMARY KEY,
+		location_id INTEGER NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+		date TEXT NOT NULL,
+		name TEXT NOT NULL,
+		UNIQUE (location_id, date)
+	)`,
+	`CREATE TABLE IF NOT EXISTS employees (
+		id SERIAL PRIMARY KEY,
+		location_id INTEGER NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+		first_name TEXT NOT NULL,
+		last_name TEXT NOT NULL,
+		time_punch_name TEXT NOT NULL DEFAULT '',
+		birthday TEXT NOT NULL DEFAULT '',
+		department TEXT NOT NULL DEFAULT '',
+		terminated BOOLEAN NOT NULL DEFAULT FALSE,
+		termination_date TEXT NOT NULL DEFAULT '',
+		annual_salary DOUBLE PRECISION NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_employees_location_id ON employees (location_id)`,
+	`CREATE TABLE IF NOT EXISTS payroll_events (
+		id SERIAL PRIMARY KEY,
+		location_id INTEGER NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+		employee_id INTEGER NOT NULL REFERENCES employees (id) ON DELETE CASCADE,
+		date TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		description TEXT NOT NULL,
+		amount DOUBLE PRECISION NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_payroll_events_location_date ON payroll_events (location_id, date)`,
+	`CREATE TABLE IF NOT EXISTS sales (
+		id SERIAL PRIMARY KEY,
+		location_id INTEGER NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+		date TEXT NOT NULL,
+		category TEXT NOT NULL,
+		item TEXT NOT NULL,
+		amount DOUBLE PRECISION NOT NULL,
+		UNIQUE (location_id, date, category, item)
+	)`,
+	`CREATE TABLE IF NOT EXISTS labor (
+		location_id INTEGER NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+		date TEXT NOT NULL,
+		regular_hours DOUBLE PRECISION NOT NULL DEFAULT 0,
+		overtime_hours DOUBLE PRECISION NOT NULL DEFAULT 0,
+		regular_wages DOUBLE PRECISION NOT NULL DEFAULT 0,
+		overtime_wages DOUBLE PRECISION NOT NULL DEFAULT 0,
+		PRIMARY KEY (location_id, date)
+	)`,
+	`CREATE TABLE IF NOT EXISTS import_runs (
+		id SERIAL PRIMARY KEY,
+		location_id INTEGER NOT NULL REFERENCES locations (id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		filename TEXT NOT NULL DEFAULT '',
+		content_type TEXT NOT NULL DEFAULT '',
+		source BYTEA NOT NULL,
+		user_id INTEGER NOT NULL DEFAULT 0,
+		username TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'applied',
+		plan TEXT NOT NULL DEFAULT '',
+		changes TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		rolled_back_by TEXT NOT NULL DEFAULT '',
+		rolled_back_at TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_import_runs_location ON import_runs (location_id, created_at)`,
+	`ALTER TABLE import_runs ADD COLUMN IF NOT EXISTS unmatched TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id SERIAL PRIMARY KEY,
+		organization_id INTEGER NOT NULL DEFAULT 1,
+		user_id INTEGER NOT NULL DEFAULT 0,
+		username TEXT NOT NULL DEFAULT '',
+		location_id INTEGER NOT NULL DEFAULT 0,
+		route TEXT NOT NULL,
+		entity_type TEXT NOT NULL,
+		entity_id TEXT NOT NULL DEFAULT '',
+		before_value TEXT NOT NULL DEFAULT '',
+		after_value TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_log_organization ON audit_log (organization_id, created_at)`,
+	`CREATE TABLE IF NOT EXISTS control_database (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		instance_id TEXT NOT NULL
+	)`,
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+var _ data.Store = (*Store)(nil)
+
+// Open connects to the database at url (a postgres:// URL or key=value DSN)
+// and creates any missing tables.
+func Open(url string) (*Store, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+// Claim records instanceID as the control database this store serves.
+// Organizations, trash and import history for these rows live in that
+// control database, so a store already claimed by another one is refused.
+func (s *Store) Claim(instanceID string) error {
+	if _, err := s.db.Exec("INSERT INTO control_database (id, instance_id) VALUES (1, $1) ON CONFLICT (id) DO NOTHING", instanceID); err != nil {
+		return err
+	}
+	var claimed string
+	if err := s.db.QueryRow("SELECT instance_id FROM control_database WHERE id = 1").Scan(&claimed); err != nil {
+		return err
+	}
+	if claimed != instanceID {
+		return fmt.Errorf("%w: this postgres database is in use by another totem control database", data.ErrStoreMismatch)
+	}
+	return nil
+}
+
+func (s *Store) Update(fn func(tx *data.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	return data.RunTx(tx, true, fn)
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Locations
+
+func (s *Store) GetAllLocations() ([]data.CfaLocation, error) {
+	rows, err := s.db.Query("SELECT id, name, number FROM locations ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locations []data.CfaLocation
+	for rows.Next() {
+		var loc data.CfaLocation
+		if err := rows.Scan(&loc.ID, &loc.Name, &loc.Number); err != nil {
+			return nil, err
+		}
+		locations = append(locations, loc)
+	}
+	return locations, rows.Err()
+}
+
+func (s *Store) GetLocationByID(id int) (data.CfaLocation, error) {
+	var loc data.CfaLocation
+	err := s.db.QueryRow("SELECT id, name, number FROM locations WHERE id = $1", id).Scan(&loc.ID, &loc.Name, &loc.Number)
+	return loc, err
+}
+
+// CreateLocation inserts the location and records its organization in the
+// control database before committing. The two databases can't share a
+// transaction, so the mapping is removed again if the commit fails.
+func (s *Store) CreateLocation(orgID int, name, number string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var id int
+	if err := tx.QueryRow("INSERT INTO locations (name, number) VALUES ($1, $2) RETURNING id", name, number).Scan(&id); err != nil {
+		return 0, err
+	}
+	if err := data.SetLocationOrganization(id, orgID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		_ = data.DeleteLocationOrganization(id)
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *Store) UpdateLocation(id int, name, number string) error {
+	_, err := s.db.Exec("UPDATE locations SET name = $1, number = $2 WHERE id = $3", name, number, id)
+	return err
+}
+
+// DeleteLocation removes the location and, through ON DELETE CASCADE, its
+// employees, payroll events, sales, labor and import runs.
+func (s *Store) DeleteLocation(id int) error {
+	_, err := s.db.Exec("DELETE FROM locations WHERE id = $1", id)
+	return err
+}
+
+func (s *Store) GetLocationProfile(locationID int) (data.LocationProfile, error) {
+	profile := data.NewLocationProfile(locationID)
+	var hours, holidays string
+	err := s.db.QueryRow(
+		`SELECT timezone, address, hours, target_productivity, target_labor_percent,
+			week_start, fiscal_pattern, fiscal_year_start_month, fiscal_year_start_day, holidays
+		FROM location_profiles WHERE location_id = $1`,
+		locationID,
+	).Scan(&profile.Timezone, &profile.Address, &hours, &profile.TargetProductivity, &profile.TargetLaborPercent,
+		&profile.Calendar.WeekStart, &profile.Calendar.Pattern, &profile.Calendar.YearStartMonth, &profile.Calendar.YearStartDay, &holidays)
+	if errors.Is(err, sql.ErrNoRows) {
+		return profile, nil
+	}
+	if err != nil {
+		return profile, err
+	}
+	profile.Hours = data.DecodeHours(hours)
+	profile.Holidays = data.DecodeHolidays(holidays)
+	return profile, nil
+}
+
+func (s *Store) SaveLocationProfile(profile data.LocationProfile) error {
+	_, err := s.db.Exec(
+		`INSERT INTO location_profiles (location_id, timezone, address, hours, target_productivity, target_labor_percent,
+			week_start, fiscal_pattern, fiscal_year_start_month, fiscal_year_start_day, holidays)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (location_id) DO UPDATE SET
+			timezone = EXCLUDED.timezone,
+			address = EXCLUDED.address,
+			hours = EXCLUDED.hours,
+			target_productivity = EXCLUDED.target_productivity,
+			target_labor_percent = EXCLUDED.target_labor_percent,
+			week_start = EXCLUDED.week_start,
+			fiscal_pattern = EXCLUDED.fiscal_pattern,
+			fiscal_year_start_month = EXCLUDED.fiscal_year_start_month,
+			fiscal_year_start_day = EXCLUDED.fiscal_year_start_day,
+			holidays = EXCLUDED.holidays`,
+		profile.LocationID, profile.Timezone, profile.Address, data.EncodeHours(profile.Hours),
+		profile.TargetProductivity, profile.TargetLaborPercent,
+		int(profile.Calendar.WeekStart), profile.Calendar.Pattern, int(profile.Calendar.YearStartMonth), profile.Calendar.YearStartDay,
+		data.EncodeHolidays(profile.Holidays),
+	)
+	return err
+}
+
+func (s *Store) GetClosures(locationID int) ([]data.Closure, error) {
+	rows, err := s.db.Query("SELECT id, location_id, date, name FROM location_closures WHERE location_id = $1 ORDER BY date", locationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var closures []data.Closure
+	for rows.Next() {
+		var c data.Closure
+		if err := rows.Scan(&c.ID, &c.LocationID, &c.Date, &c.Name); err != nil {
+			return nil, err
+		}
+		closures = append(closures, c)
+	}
+	return closures, rows.Err()
+}
+
+func (s *Store) AddClosure(locationID int, date, name string) error {
+	_, err := s.db.Exec(
+		"INSERT INTO location_closures (location_id, date, name) VALUES ($1, $2, $3) ON CONFLICT (location_id, date) DO UPDATE SET name = EXCLUDED.name",
+		locationID, date, name,
+	)
+	return err
+}
+
+func (s *Store) DeleteClosure(locationID, id int) error {
+	res, err := s.db.Exec("DELETE FROM location_closures WHERE id = $1 AND location_id = $2", id, locationID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return data.ErrClosureNotFound
+	}
+	return nil
+}
+
+// Employees
+
+const employeeColumns = `id, location_id, first_name, last_name, time_punch_name, birthday,
+	department, terminated, termination_date, annual_salary`
+
+func scanEmployee(row interface{ Scan(...any) error }) (data.Employee, error) {
+	var emp data.Employee
+	err := row.Scan(&emp.ID, &emp.LocationID, &emp.FirstName, &emp.LastName, &emp.TimePunchName, &emp.Birthday,
+		&emp.Department, &emp.Terminated, &emp.TerminationDate, &emp.AnnualSalary)
+	return emp, err
+}
+
+func (s *Store) queryEmployees(where string, args ...any) ([]data.Employee, error) {
+	rows, err := s.db.Query("SELECT "+employeeColumns+" FROM employees WHERE "+where+" ORDER BY last_name, first_name", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var employees []data.Employee
+	for rows.Next() {
+		emp, err := scanEmployee(rows)
+		if err != nil {
+			return nil, err
+		}
+		employees = append(employees, emp)
+	}
+	return employees, rows.Err()
+}
+
+func (s *Store) GetEmployeeByID(id int) (data.Employee, error) {
+	return scanEmployee(s.db.QueryRow("SELECT "+employeeColumns+" FROM employees WHERE id = $1", id))
+}
+
+// GetEmployeesByLocation returns the active roster, matching the SQLite
+// backend.
+func (s *Store) GetEmployeesByLocation(locationID int) ([]data.Employee, error) {
+	return s.GetActiveEmployeesByLocation(locationID)
+}
+
+func (s *Store) GetActiveEmployeesByLocation(locationID int) ([]data.Employee, error) {
+	return s.queryEmployees("location_id = $1 AND NOT terminated", locationID)
+}
+
+func (s *Store) GetTerminatedEmployeesByLocation(locationID int) ([]data.Employee, error) {
+	return s.queryEmployees("location_id = $1 AND terminated", locationID)
+}
+
+func (s *Store) GetAllEmployeesByLocation(locationID int) ([]data.Employee, error) {
+	return s.queryEmployees("location_id = $1", locationID)
+}
+
+func (s *Store) CreateEmployee(locationID int, firstName, lastName string) error {
+	_, err := s.db.Exec("INSERT INTO employees (location_id, first_name, last_name) VALUES ($1, $2, $3)", locationID, firstName, lastName)
+	return err
+}
+
+func (s *Store) UpdateEmployee(id int, firstName, lastName, birthday, department string, annualSalary float64) error {
+	_, err := s.db.Exec(
+		"UPDATE employees SET first_name = $1, last_name = $2, birthday = $3, department = $4, annual_salary = $5 WHERE id = $6",
+		firstName, lastName, birthday, department, annualSalary, id,
+	)
+	return err
+}
+
+func (s *Store) TerminateEmployee(id int, terminationDate string) error {
+	_, err := s.db.Exec("UPDATE employees SET terminated = TRUE, termination_date = $1 WHERE id = $2", terminationDate, id)
+	return err
+}
+
+func (s *Store) ReinstateEmployee(id int) error {
+	_, err := s.db.Exec("UPDATE employees SET terminated = FALSE, termination_date = '' WHERE id = $1", id)
+	return err
+}
+
+func (s *Store) DeleteEmployee(id int) error {
+	_, err := s.db.Exec("DELETE FROM employees WHERE id = $1", id)
+	return err
+}
+
+func (s *Store) ApplyEmployeeChanges(locationID int, changes []data.EmployeeChange) error {
+	return s.Update(func(tx *data.Tx) error {
+		return data.ApplyEmployeeChangesTx(tx, locationID, changes)
+	})
+}
+
+// Payroll events
+
+func (s *Store) GetPayrollEventsByLocation(locationID int, startDate, endDate string) ([]data.PayrollEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT p.id, p.location_id, p.employee_id, COALESCE(e.first_name || ' ' || e.last_name, ''),
+			p.date, p.event_type, p.description, p.amount
+		FROM payroll_events p
+		LEFT JOIN employees e ON e.id = p.employee_id
+		WHERE p.location_id = $1 AND p.date BETWEEN $2 AND $3
+		ORDER BY p.date DESC, p.id DESC`,
+		locationID, startDate, endDate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []data.PayrollEvent
+	for rows.Next() {
+		var event data.PayrollEvent
+		if err := rows.Scan(&event.ID, &event.LocationID, &event.EmployeeID, &event.EmployeeName,
+			&event.Date, &event.EventType, &event.Description, &event.Amount); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *Store) CreatePayrollEvent(locationID, employeeID int, date, eventType, description string, amount float64) error {
+	_, err := s.db.Exec(
+		"INSERT INTO payroll_events (location_id, employee_id, date, event_type, description, amount) VALUES ($1, $2, $3, $4, $5, $6)",
+		locationID, employeeID, date, eventType, description, amount,
+	)
+	return err
+}
+
+func (s *Store) DeletePayrollEvent(id int) error {
+	_, err := s.db.Exec("DELETE FROM payroll_events WHERE id = $1", id)
+	return err
+}
+
+// Sales
+
+// GetSalesByDate returns the day's records with Percent set to each item's
+// share of its category.
+func (s *Store) GetSalesByDate(locationID int, date string) ([]data.SaleRecord, error) {
+	rows, err := s.db.Query(
+		"SELECT id, location_id, date, category, item, amount FROM sales WHERE location_id = $1 AND date = $2 ORDER BY category, id",
+		locationID, date,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []data.SaleRecord
+	totals := map[string]float64{}
+	for rows.Next() {
+		var rec data.SaleRecord
+		if err := rows.Scan(&rec.ID, &rec.LocationID, &rec.Date, &rec.Category, &rec.Item, &rec.Amount); err != nil {
+			return nil, err
+		}
+		totals[rec.Category] += rec.Amount
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range records {
+		if total := totals[records[i].Category]; total > 0 {
+			records[i].Percent = records[i].Amount / total * 100
+		}
+	}
+	return records, nil
+}
+
+// SaveSalesBatch replaces the day's sales with records.
+func (s *Store) SaveSalesBatch(locationID int, date string, records []data.SaleRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM sales WHERE location_id = $1 AND date = $2", locationID, date); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if _, err := tx.Exec(
+			`INSERT INTO sales (location_id, date, category, item, amount) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (location_id, date, category, item) DO UPDATE SET amount = EXCLUDED.amount`,
+			locationID, date, rec.Category, rec.Item, rec.Amount,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetSalesSummaries totals each day in the range and the range as a whole.
+// A day's total is the sum of its day parts; destinations break the same
+// dollars down another way.
+func (s *Store) GetSalesSummaries(locationID int, startDate, endDate string) ([]data.DailySummary, data.RangeSummary, error) {
+	summary := data.RangeSummary{
+		DayPartTotals:       map[string]float64{},
+		DayPartAverages:     map[string]float64{},
+		DayPartPercents:     map[string]float64{},
+		DestinationTotals:   map[string]float64{},
+		DestinationAverages: map[string]float64{},
+		DestinationPercents: map[string]float64{},
+	}
+	rows, err := s.db.Query(
+		"SELECT date, category, item, amount FROM sales WHERE location_id = $1 AND date BETWEEN $2 AND $3 ORDER BY date",
+		locationID, startDate, endDate,
+	)
+	if err != nil {
+		return nil, summary, err
+	}
+	defer rows.Close()
+
+	var days []data.DailySummary
+	index := map[string]int{}
+	destinationTotal := 0.0
+	for rows.Next() {
+		var date, category, item string
+		var amount float64
+		if err := rows.Scan(&date, &category, &item, &amount); err != nil {
+			return nil, summary, err
+		}
+		switch category {
+		case "DayPart":
+			i, ok := index[date]
+			if !ok {
+				i = len(days)
+				index[date] = i
+				days = append(days, data.DailySummary{Date: date, DayOfWeek: weekday(date)})
+			}
+			days[i].TotalAmount += amount
+			summary.DayPartTotals[item] += amount
+			summary.TotalAmount += amount
+		case "Destination":
+			summary.DestinationTotals[item] += amount
+			destinationTotal += amount
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, summary, err
+	}
+
+	summary.DayCount = len(days)
+	for item, total := range summary.DayPartTotals {
+		if summary.DayCount > 0 {
+			summary.DayPartAverages[item] = total / float64(summary.DayCount)
+		}
+		if summary.TotalAmount > 0 {
+			summary.DayPartPercents[item] = total / summary.TotalAmount * 100
+		}
+	}
+	for item, total := range summary.DestinationTotals {
+		if summary.DayCount > 0 {
+			summary.DestinationAverages[item] = total / float64(summary.DayCount)
+		}
+		if destinationTotal > 0 {
+			summary.DestinationPercents[item] = total / destinationTotal * 100
+		}
+	}
+	return days, summary, nil
+}
+
+func (s *Store) GetTotalSalesByLocation(locationID int, startDate, endDate string) (float64, error) {
+	var total float64
+	err := s.db.QueryRow(
+		"SELECT COALESCE(SUM(amount), 0) FROM sales WHERE location_id = $1 AND category = 'DayPart' AND date BETWEEN $2 AND $3",
+		locationID, startDate, endDate,
+	).Scan(&total)
+	return total, err
+}
+
+// Labor
+
+func (s *Store) GetLaborByDate(locationID int, date string) (data.LaborRecord, error) {
+	var rec data.LaborRecord
+	err := s.db.QueryRow(
+		"SELECT regular_hours, overtime_hours, regular_wages, overtime_wages FROM labor WHERE location_id = $1 AND date = $2",
+		locationID, date,
+	).Scan(&rec.RegularHours, &rec.OvertimeHours, &rec.RegularWages, &rec.OvertimeWages)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rec, nil
+	}
+	return rec, err
+}
+
+func (s *Store) SaveLabor(locationID int, date string, regularHours, overtimeHours, regularWages, overtimeWages float64) error {
+	_, err := s.db.Exec(
+		`INSERT INTO labor (location_id, date, regular_hours, overtime_hours, regular_wages, overtime_wages)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (location_id, date) DO UPDATE SET
+			regular_hours = EXCLUDED.regular_hours,
+			overtime_hours = EXCLUDED.overtime_hours,
+			regular_wages = EXCLUDED.regular_wages,
+			overtime_wages = EXCLUDED.overtime_wages`,
+		locationID, date, regularHours, overtimeHours, regularWages, overtimeWages,
+	)
+	return err
+}
+
+// Performance
+
+// GetPerformanceReport returns one record per calendar day in the range,
+// joining day-part sales with labor.
+func (s *Store) GetPerformanceReport(locationID int, startDate, endDate string) ([]data.DailyPerformanceRecord, error) {
+	start, err := time.Parse("2006-01-02", startDate)
+	if err != nil {
+		return nil, err
+	}
+	end, err := time.Parse("2006-01-02", endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	records := map[string]*data.DailyPerformanceRecord{}
+	var ordered []*data.DailyPerformanceRecord
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		rec := &data.DailyPerformanceRecord{Date: day.Format("2006-01-02"), DayOfWeek: day.Weekday().String()}
+		records[rec.Date] = rec
+		ordered = append(ordered, rec)
+	}
+
+	rows, err := s.db.Query(
+		"SELECT date, SUM(amount) FROM sales WHERE location_id = $1 AND category = 'DayPart' AND date BETWEEN $2 AND $3 GROUP BY date",
+		locationID, startDate, endDate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var date string
+		var total float64
+		if err := rows.Scan(&date, &total); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if rec, ok := records[date]; ok {
+			rec.TotalSales = total
+			rec.HasSales = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(
+		"SELECT date, regular_hours, overtime_hours, regular_wages, overtime_wages FROM labor WHERE location_id = $1 AND date BETWEEN $2 AND $3",
+		locationID, startDate, endDate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var date string
+		var regular, overtime, regularWages, overtimeWages float64
+		if err := rows.Scan(&date, &regular, &overtime, &regularWages, &overtimeWages); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if rec, ok := records[date]; ok {
+			rec.TotalHours = regular + overtime
+			rec.OvertimeHours = overtime
+			rec.TotalWages = regularWages + overtimeWages
+			rec.HasLabor = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := make([]data.DailyPerformanceRecord, 0, len(ordered))
+	for _, rec := range ordered {
+		if rec.TotalHours > 0 {
+			rec.Productivity = rec.TotalSales / rec.TotalHours
+		}
+		if rec.TotalSales > 0 {
+			rec.LaborPercent = rec.TotalWages / rec.TotalSales * 100
+		}
+		report = append(report, *rec)
+	}
+	return report, nil
+}
+
+func weekday(date string) string {
+	day, err := time.Parse("2006-01-02", strings.TrimSpace(date))
+	if err != nil {
+		return ""
+	}
+	return day.Weekday().String()
+}
@@ -0,0 +1,34 @@
+package postgres
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/phillip-england/totem/pkg/data"
+	"github.com/phillip-england/totem/pkg/data/storetest"
+)
+
+// TestStore runs the store contract against the database at
+// TOTEM_TEST_POSTGRES_URL. Locations are created and deleted there, so point
+// it at a database kept for tests.
+func TestStore(t *testing.T) {
+	url := os.Getenv("TOTEM_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TOTEM_TEST_POSTGRES_URL is not set")
+	}
+	if err := data.Open(filepath.Join(t.TempDir(), "control.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer data.DB.Close()
+	if _, err := data.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	storetest.Run(t, store)
+}
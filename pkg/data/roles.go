@@ -0,0 +1,95 @@
+package data
+
+import "strings"
+
+type Permission string
+
+const (
+	PermViewSales       Permission = "view_sales"
+	PermEnterSales      Permission = "enter_sales"
+	PermEnterLabor      Permission = "enter_labor"
+	PermViewPayroll     Permission = "view_payroll"
+	PermEditPayroll     Permission = "edit_payroll"
+	PermViewEmployees   Permission = "view_employees"
+	PermManageEmployees Permission = "manage_employees"
+	PermManageLocations Permission = "manage_locations"
+	PermManageUsers     Permission = "manage_users"
+	PermViewAudit       Permission = "view_audit"
+	PermManageBackups   Permission = "manage_backups"
+)
+
+const (
+	RoleOwner       = "owner"
+	RoleDirector    = "director"
+	RoleShiftLeader = "shift_leader"
+	RoleViewer      = "viewer"
+
+	// Legacy role names stored before roles carried permissions.
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Roles lists the assignable roles in order of decreasing access.
+var Roles = []string{RoleOwner, RoleDirector, RoleShiftLeader, RoleViewer}
+
+var RoleLabels = map[string]string{
+	RoleOwner:       "Owner",
+	RoleDirector:    "Director",
+	RoleShiftLeader: "Shift Leader",
+	RoleViewer:      "Viewer (read-only)",
+}
+
+var rolePermissions = map[string][]Permission{
+	RoleOwner: {
+		PermViewSales, PermEnterSales, PermEnterLabor,
+		PermViewPayroll, PermEditPayroll,
+		PermViewEmployees, PermManageEmployees,
+		PermManageLocations, PermManageUsers,
+		PermViewAudit, PermManageBackups,
+	},
+	RoleDirector: {
+		PermViewSales, PermEnterSales, PermEnterLabor,
+		PermViewPayroll, PermEditPayroll,
+		PermViewEmployees, PermManageEmployees,
+	},
+	RoleShiftLeader: {
+		PermViewSales, PermEnterSales, PermEnterLabor,
+		PermViewEmployees,
+	},
+	RoleViewer: {
+		PermViewSales, PermViewEmployees,
+	},
+}
+
+// NormalizeRole maps stored role values, including the legacy "admin" and
+// "user" roles, onto one of Roles. Unknown roles normalize to "".
+func NormalizeRole(role string) string {
+	role = strings.ToLower(strings.TrimSpace(role))
+	switch role {
+	case RoleAdmin:
+		return RoleOwner
+	case RoleUser:
+		return RoleViewer
+	}
+	if _, ok := rolePermissions[role]; ok {
+		return role
+	}
+	return ""
+}
+
+func IsValidRole(role string) bool {
+	return NormalizeRole(role) != ""
+}
+
+func RoleHasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[NormalizeRole(role)] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func RolePermissions(role string) []Permission {
+	return rolePermissions[NormalizeRole(role)]
+}
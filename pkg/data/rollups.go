@@ -0,0 +1,61 @@
+package data
+
+import (
+	"sort"
+	"time"
+
+	"github.com/phillip-england/totem/pkg/calendar"
+)
+
+// PerformanceRollup totals the daily records that fall in one calendar span.
+type PerformanceRollup struct {
+	Label   string           `json:"label"`
+	Start   string           `json:"start"`
+	End     string           `json:"end"`
+	Summary OperatingSummary `json:"summary"`
+}
+
+// RollupPerformance groups records by the calendar's unit, oldest first.
+// Spans are clipped to within so a week that straddles the start of a period
+// only shows the days inside it. Records are dated in loc. Each span is
+// summarized with CalculateOperatingSummary, so its daily averages skip the
+// days the location was closed.
+func RollupPerformance(records []DailyPerformanceRecord, cal calendar.Calendar, unit calendar.Unit, within calendar.Range, loc *time.Location, closed ClosedDays) []PerformanceRollup {
+	type bucket struct {
+		span    calendar.Range
+		label   string
+		records []DailyPerformanceRecord
+	}
+	buckets := map[time.Time]*bucket{}
+	for _, rec := range records {
+		day, err := time.ParseInLocation(calendar.DateLayout, rec.Date, loc)
+		if err != nil || !within.Contains(day) {
+			continue
+		}
+		span, label := cal.Span(unit, day)
+		b, ok := buckets[span.Start]
+		if !ok {
+			b = &bucket{span: span.Clip(within), label: label}
+			buckets[span.Start] = b
+		}
+		b.records = append(b.records, rec)
+	}
+
+	rollups := make([]PerformanceRollup, 0, len(buckets))
+	for _, b := range buckets {
+		label := b.label
+		if unit == calendar.UnitWeek {
+			label = b.span.Label()
+		}
+		rollups = append(rollups, PerformanceRollup{
+			Label:   label,
+			Start:   b.span.Start.Format(calendar.DateLayout),
+			End:     b.span.End.Format(calendar.DateLayout),
+			Summary: CalculateOperatingSummary(b.records, closed, b.span),
+		})
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].Start < rollups[j].Start
+	})
+	return rollups
+}
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SessionInfo is the bookkeeping kept next to each session: where it was
+// created from and the sliding idle expiry that is extended on activity.
+// ExpiresAt mirrors the session's absolute expiry.
+type SessionInfo struct {
+	ID            int
+	SessionKey    string
+	UserID        int
+	IP            string
+	UserAgent     string
+	RememberMe    bool
+	CreatedAt     time.Time
+	LastSeenAt    time.Time
+	ExpiresAt     time.Time
+	IdleExpiresAt time.Time
+}
+
+func CreateSessionInfo(info SessionInfo) error {
+	_, err := DB.Exec(
+		`INSERT INTO session_info (session_key, user_id, ip, user_agent, remember_me, created_at, last_seen_at, expires_at, idle_expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		info.SessionKey, info.UserID, info.IP, info.UserAgent, info.RememberMe,
+		info.CreatedAt, info.LastSeenAt, info.ExpiresAt, info.IdleExpiresAt,
+	)
+	return err
+}
+
+const sessionInfoColumns = "id, session_key, user_id, ip, user_agent, remember_me, created_at, last_seen_at, expires_at, idle_expires_at"
+
+func scanSessionInfo(row rowScanner) (SessionInfo, error) {
+	var info SessionInfo
+	err := row.Scan(&info.ID, &info.SessionKey, &info.UserID, &info.IP, &info.UserAgent, &info.RememberMe,
+		&info.CreatedAt, &info.LastSeenAt, &info.ExpiresAt, &info.IdleExpiresAt)
+	return info, err
+}
+
+func GetSessionInfoByKey(key string) (SessionInfo, error) {
+	return scanSessionInfo(DB.QueryRow("SELECT "+sessionInfoColumns+" FROM session_info WHERE session_key = ?", key))
+}
+
+func GetSessionInfoByID(id int) (SessionInfo, error) {
+	return scanSessionInfo(DB.QueryRow("SELECT "+sessionInfoColumns+" FROM session_info WHERE id = ?", id))
+}
+
+// GetActiveSessionInfos lists sessions that have neither hit their absolute
+// nor their idle expiry, newest activity first.
+func GetActiveSessionInfos() ([]SessionInfo, error) {
+	now := time.Now()
+	rows, err := DB.Query(
+		"SELECT "+sessionInfoColumns+" FROM session_info WHERE expires_at > ? AND idle_expires_at > ? ORDER BY user_id, last_seen_at DESC",
+		now, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var infos []SessionInfo
+	for rows.Next() {
+		info, err := scanSessionInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+// TouchSessionInfo records activity and slides the idle expiry forward. It
+// never moves the idle expiry past the absolute expiry.
+func TouchSessionInfo(key string, seenAt, idleExpiresAt time.Time) error {
+	_, err := DB.Exec(
+		"UPDATE session_info SET last_seen_at = ?, idle_expires_at = MIN(?, expires_at) WHERE session_key = ?",
+		seenAt, idleExpiresAt, key,
+	)
+	return err
+}
+
+func DeleteSessionInfoByKey(key string) error {
+	_, err := DB.Exec("DELETE FROM session_info WHERE session_key = ?", key)
+	return err
+}
+
+func DeleteSessionInfosByUserID(userID int) error {
+	_, err := DB.Exec("DELETE FROM session_info WHERE user_id = ?", userID)
+	return err
+}
+
+func PurgeExpiredSessionInfos() error {
+	now := time.Now()
+	_, err := DB.Exec("DELETE FROM session_info WHERE expires_at <= ? OR idle_expires_at <= ?", now, now)
+	return err
+}
+
+// RevokeSession ends a session and removes its bookkeeping.
+func RevokeSession(key string) error {
+	if err := DeleteSessionByKey(key); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	return DeleteSessionInfoByKey(key)
+}
+
+// SetSessionOrganization records the organization a super-admin is working
+// in for one session. It does not touch their organization membership, so
+// switching in one browser leaves their other sessions where they were.
+func SetSessionOrganization(key string, organizationID int) error {
+	result, err := DB.Exec("UPDATE session_info SET organization_id = ? WHERE session_key = ?", organizationID, key)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SessionOrganizationID returns the organization chosen for a session, or
+// 0 when none has been chosen.
+func SessionOrganizationID(key string) (int, error) {
+	var organizationID int
+	err := DB.QueryRow("SELECT organization_id FROM session_info WHERE session_key = ?", key).Scan(&organizationID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return organizationID, err
+}
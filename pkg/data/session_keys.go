@@ -0,0 +1,176 @@
+package data
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// SessionKeyGracePeriod is how long a rotated-out signing key keeps verifying
+// cookies, giving active sessions time to be re-signed with the new key.
+var SessionKeyGracePeriod = 7 * 24 * time.Hour
+
+type SessionSigningKey struct {
+	ID        int
+	Secret    []byte
+	CreatedAt time.Time
+	RetiredAt time.Time
+}
+
+func (k SessionSigningKey) Current() bool {
+	return k.RetiredAt.IsZero()
+}
+
+// Valid reports whether cookies signed with k are still accepted at now.
+func (k SessionSigningKey) Valid(now time.Time) bool {
+	return k.Current() || now.Before(k.RetiredAt.Add(SessionKeyGracePeriod))
+}
+
+func scanSessionSigningKey(row rowScanner) (SessionSigningKey, error) {
+	var key SessionSigningKey
+	var secret string
+	var retiredAt sql.NullTime
+	if err := row.Scan(&key.ID, &secret, &key.CreatedAt, &retiredAt); err != nil {
+		return SessionSigningKey{}, err
+	}
+	decoded, err := hex.DecodeString(secret)
+	if err != nil {
+		return SessionSigningKey{}, err
+	}
+	key.Secret = decoded
+	key.RetiredAt = retiredAt.Time
+	return key, nil
+}
+
+// GetCurrentSessionSigningKey returns the key new cookies are signed with,
+// creating the first key on demand.
+func GetCurrentSessionSigningKey() (SessionSigningKey, error) {
+	key, err := scanSessionSigningKey(DB.QueryRow(
+		"SELECT id, secret, created_at, retired_at FROM session_signing_keys WHERE retired_at IS NULL ORDER BY id DESC LIMIT 1",
+	))
+	if err == sql.ErrNoRows {
+		if err := insertSessionSigningKey(DB); err != nil {
+			return SessionSigningKey{}, err
+		}
+		return GetCurrentSessionSigningKey()
+	}
+	return key, err
+}
+
+func GetSessionSigningKeyByID(id int) (SessionSigningKey, error) {
+	return scanSessionSigningKey(DB.QueryRow(
+		"SELECT id, secret, created_at, retired_at FROM session_signing_keys WHERE id = ?", id,
+	))
+}
+
+func GetSessionSigningKeys() ([]SessionSigningKey, error) {
+	rows, err := DB.Query("SELECT id, secret, created_at, retired_at FROM session_signing_keys ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SessionSigningKey
+	for rows.Next() {
+		key, err := scanSessionSigningKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RotateSessionSigningKey retires the current key and starts signing with a
+// new one. Keys whose grace period has passed are deleted.
+func RotateSessionSigningKey() error {
+	now := time.Now()
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("UPDATE session_signing_keys SET retired_at = ? WHERE retired_at IS NULL", now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM session_signing_keys WHERE retired_at IS NOT NULL AND retired_at < ?", now.Add(-SessionKeyGracePeriod)); err != nil {
+		return err
+	}
+	if err := insertSessionSigningKey(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insertSessionSigningKey(db execer) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	_, err := db.Exec(
+		"INSERT INTO session_signing_keys (secret, created_at) VALUES (?, ?)",
+		hex.EncodeToString(secret), time.Now(),
+	)
+	return err
+}
+
+// NewSessionSecret returns the random per-session secret stored as the
+// session payload and covered by the cookie signature.
+func NewSessionSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// RevokeUserSessions signs a user out everywhere.
+func RevokeUserSessions(userID int) error {
+	if err := DeleteSessionsByUserID(userID); err != nil {
+		return err
+	}
+	return DeleteSessionInfosByUserID(userID)
+}
+
+// revokeUserSessions signs a user out everywhere inside tx, so the change
+// that calls for it can't commit while their sessions live on.
+func revokeUserSessions(tx *Tx, userID int) error {
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM session_info WHERE user_id = ?", userID)
+	return err
+}
+
+// RevokeAllSessions signs every user out. Sessions are only honoured while
+// they have session info, so dropping those rows ends them all.
+func RevokeAllSessions() error {
+	rows, err := DB.Query("SELECT session_key FROM session_info")
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		_ = DeleteSessionByKey(key)
+	}
+	_, err = DB.Exec("DELETE FROM session_info")
+	return err
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+const (
+	SettingRequireAdmin2FA = "require_admin_2fa"
+	// SettingNameMatchThreshold is the score, from 0 to 1, a name must reach
+	// for imports and the time punch summary to treat it as an employee.
+	SettingNameMatchThreshold = "name_match_threshold"
+)
+
+// Settings belong to an organization; each organization starts with none
+// set.
+func GetSetting(orgID int, key string) (string, error) {
+	var value string
+	err := DB.QueryRow("SELECT value FROM organization_settings WHERE organization_id = ? AND key = ?", orgID, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func SetSetting(orgID int, key, value string) error {
+	_, err := DB.Exec(
+		`INSERT INTO organization_settings (organization_id, key, value) VALUES (?, ?, ?)
+		ON CONFLICT(organization_id, key) DO UPDATE SET value = excluded.value`,
+		orgID, key, value,
+	)
+	return err
+}
+
+func GetBoolSetting(orgID int, key string) bool {
+	value, err := GetSetting(orgID, key)
+	if err != nil {
+		return false
+	}
+	enabled, _ := strconv.ParseBool(value)
+	return enabled
+}
+
+func SetBoolSetting(orgID int, key string, enabled bool) error {
+	return SetSetting(orgID, key, strconv.FormatBool(enabled))
+}
+
+// GetFloatSetting returns fallback when the setting is unset or not a number.
+func GetFloatSetting(orgID int, key string, fallback float64) float64 {
+	value, err := GetSetting(orgID, key)
+	if err != nil || value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
+func SetFloatSetting(orgID int, key string, value float64) error {
+	return SetSetting(orgID, key, strconv.FormatFloat(value, 'f', -1, 64))
+}
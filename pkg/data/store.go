@@ -0,0 +1,168 @@
+package data
+
+import "context"
+
+// Store is the business data a deployment keeps: locations, employees,
+// payroll events, sales and labor. Handlers receive one through
+// RegisterRoutes so the backend can be swapped.
+//
+// Accounts, sessions, audit, trash, organizations and the other control
+// tables stay in the SQLite database behind DB, and many of them refer to
+// store rows by ID. A control database is therefore only valid with the
+// store it was first started against; see BindStore. Import runs, and audit
+// entries for changes made through Update, are kept in the store's database
+// so they commit with the rows they describe.
+type Store interface {
+	Ping(ctx context.Context) error
+
+	GetAllLocations() ([]CfaLocation, error)
+	GetLocationByID(id int) (CfaLocation, error)
+	// CreateLocation adds a location to orgID and returns its ID.
+	CreateLocation(orgID int, name, number string) (int, error)
+	UpdateLocation(id int, name, number string) error
+	DeleteLocation(id int) error
+	GetLocationProfile(locationID int) (LocationProfile, error)
+	SaveLocationProfile(profile LocationProfile) error
+	GetClosures(locationID int) ([]Closure, error)
+	AddClosure(locationID int, date, name string) error
+	DeleteClosure(locationID, id int) error
+
+	GetEmployeeByID(id int) (Employee, error)
+	GetEmployeesByLocation(locationID int) ([]Employee, error)
+	GetActiveEmployeesByLocation(locationID int) ([]Employee, error)
+	GetTerminatedEmployeesByLocation(locationID int) ([]Employee, error)
+	GetAllEmployeesByLocation(locationID int) ([]Employee, error)
+	CreateEmployee(locationID int, firstName, lastName string) error
+	UpdateEmployee(id int, firstName, lastName, birthday, department string, annualSalary float64) error
+	TerminateEmployee(id int, terminationDate string) error
+	ReinstateEmployee(id int) error
+	DeleteEmployee(id int) error
+	ApplyEmployeeChanges(locationID int, changes []EmployeeChange) error
+
+	GetPayrollEventsByLocation(locationID int, startDate, endDate string) ([]PayrollEvent, error)
+	CreatePayrollEvent(locationID, employeeID int, date, eventType, description string, amount float64) error
+	DeletePayrollEvent(id int) error
+
+	GetSalesByDate(locationID int, date string) ([]SaleRecord, error)
+	SaveSalesBatch(locationID int, date string, records []SaleRecord) error
+	GetSalesSummaries(locationID int, startDate, endDate string) ([]DailySummary, RangeSummary, error)
+	GetTotalSalesByLocation(locationID int, startDate, endDate string) (float64, error)
+
+	GetLaborByDate(locationID int, date string) (LaborRecord, error)
+	SaveLabor(locationID int, date string, regularHours, overtimeHours, regularWages, overtimeWages float64) error
+
+	GetPerformanceReport(locationID int, startDate, endDate string) ([]DailyPerformanceRecord, error)
+
+	// Update runs fn in one transaction on the store's database, committing
+	// when it returns nil. Bookkeeping that must change together with
+	// business rows, such as import runs, is written through it.
+	Update(fn func(tx *Tx) error) error
+}
+
+// SQLiteStore is the default Store, backed by the package-level SQLite
+// functions.
+type SQLiteStore struct{}
+
+var _ Store = SQLiteStore{}
+
+// sharesControlDB reports whether store keeps its rows in the control
+// database, so work on both can commit in one transaction.
+func sharesControlDB(store Store) bool {
+	if f, ok := store.(trashFilter); ok {
+		store = f.Store
+	}
+	_, shared := store.(SQLiteStore)
+	return shared
+}
+
+func (SQLiteStore) Ping(ctx context.Context) error { return DB.PingContext(ctx) }
+
+func (SQLiteStore) GetAllLocations() ([]CfaLocation, error)     { return GetAllLocations() }
+func (SQLiteStore) GetLocationByID(id int) (CfaLocation, error) { return GetLocationByID(id) }
+func (SQLiteStore) CreateLocation(orgID int, name, number string) (int, error) {
+	return CreateLocationInOrganization(orgID, name, number)
+}
+func (SQLiteStore) UpdateLocation(id int, name, number string) error {
+	return UpdateLocation(id, name, number)
+}
+
+// DeleteLocation removes the location and every row the store keeps for it
+// in one transaction, as the PostgreSQL store's ON DELETE CASCADE does.
+func (SQLiteStore) DeleteLocation(id int) error {
+	return updateControl(func(tx *Tx) error {
+		return deleteLocationRows(tx, id)
+	})
+}
+func (SQLiteStore) GetLocationProfile(locationID int) (LocationProfile, error) {
+	return GetLocationProfile(locationID)
+}
+func (SQLiteStore) SaveLocationProfile(profile LocationProfile) error {
+	return SaveLocationProfile(profile)
+}
+func (SQLiteStore) GetClosures(locationID int) ([]Closure, error) { return GetClosures(locationID) }
+func (SQLiteStore) AddClosure(locationID int, date, name string) error {
+	return AddClosure(locationID, date, name)
+}
+func (SQLiteStore) DeleteClosure(locationID, id int) error { return DeleteClosure(locationID, id) }
+
+func (SQLiteStore) GetEmployeeByID(id int) (Employee, error) { return GetEmployeeByID(id) }
+func (SQLiteStore) GetEmployeesByLocation(locationID int) ([]Employee, error) {
+	return GetEmployeesByLocation(locationID)
+}
+func (SQLiteStore) GetActiveEmployeesByLocation(locationID int) ([]Employee, error) {
+	return GetActiveEmployeesByLocation(locationID)
+}
+func (SQLiteStore) GetTerminatedEmployeesByLocation(locationID int) ([]Employee, error) {
+	return GetTerminatedEmployeesByLocation(locationID)
+}
+func (SQLiteStore) GetAllEmployeesByLocation(locationID int) ([]Employee, error) {
+	return GetAllEmployeesByLocation(locationID)
+}
+func (SQLiteStore) CreateEmployee(locationID int, firstName, lastName string) error {
+	return CreateEmployee(locationID, firstName, lastName)
+}
+func (SQLiteStore) UpdateEmployee(id int, firstName, lastName, birthday, department string, annualSalary float64) error {
+	return UpdateEmployee(id, firstName, lastName, birthday, department, annualSalary)
+}
+func (SQLiteStore) TerminateEmployee(id int, terminationDate string) error {
+	return TerminateEmployee(id, terminationDate)
+}
+func (SQLiteStore) ReinstateEmployee(id int) error { return ReinstateEmployee(id) }
+func (SQLiteStore) DeleteEmployee(id int) error    { return DeleteEmployee(id) }
+func (SQLiteStore) ApplyEmployeeChanges(locationID int, changes []EmployeeChange) error {
+	return ApplyEmployeeChanges(locationID, changes)
+}
+
+func (SQLiteStore) GetPayrollEventsByLocation(locationID int, startDate, endDate string) ([]PayrollEvent, error) {
+	return GetPayrollEventsByLocation(locationID, startDate, endDate)
+}
+func (SQLiteStore) CreatePayrollEvent(locationID, employeeID int, date, eventType, description string, amount float64) error {
+	return CreatePayrollEvent(locationID, employeeID, date, eventType, description, amount)
+}
+func (SQLiteStore) DeletePayrollEvent(id int) error { return DeletePayrollEvent(id) }
+
+func (SQLiteStore) GetSalesByDate(locationID int, date string) ([]SaleRecord, error) {
+	return GetSalesByDate(locationID, date)
+}
+func (SQLiteStore) SaveSalesBatch(locationID int, date string, records []SaleRecord) error {
+	return SaveSalesBatch(locationID, date, records)
+}
+func (SQLiteStore) GetSalesSummaries(locationID int, startDate, endDate string) ([]DailySummary, RangeSummary, error) {
+	return GetSalesSummaries(locationID, startDate, endDate)
+}
+func (SQLiteStore) GetTotalSalesByLocation(locationID int, startDate, endDate string) (float64, error) {
+	return GetTotalSalesByLocation(locationID, startDate, endDate)
+}
+
+func (SQLiteStore) GetLaborByDate(locationID int, date string) (LaborRecord, error) {
+	return GetLaborByDate(locationID, date)
+}
+func (SQLiteStore) SaveLabor(locationID int, date string, regularHours, overtimeHours, regularWages, overtimeWages float64) error {
+	return SaveLabor(locationID, date, regularHours, overtimeHours, regularWages, overtimeWages)
+}
+
+func (SQLiteStore) GetPerformanceReport(locationID int, startDate, endDate string) ([]DailyPerformanceRecord, error) {
+	return GetPerformanceReport(locationID, startDate, endDate)
+}
+
+func (SQLiteStore) Update(fn func(tx *Tx) error) error { return updateControl(fn) }
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrStoreMismatch = errors.New("control database belongs to a different store")
+
+// BindStore ties the control database to the backend it serves and returns
+// the control database's instance ID. The first call records the binding;
+// later calls fail with ErrStoreMismatch when backend differs, since the IDs
+// kept in the control tables would point at the wrong rows. Backends that
+// live outside this file should also claim the instance ID so that two
+// control databases can't share one store.
+func BindStore(backend string) (string, error) {
+	var bound, instanceID string
+	err := DB.QueryRow("SELECT backend, instance_id FROM store_binding WHERE id = 1").Scan(&bound, &instanceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		instanceID = hex.EncodeToString(buf)
+		_, err = DB.Exec(
+			"INSERT INTO store_binding (id, backend, instance_id, bound_at) VALUES (1, ?, ?, ?)",
+			backend, instanceID, time.Now(),
+		)
+		return instanceID, err
+	}
+	if err != nil {
+		return "", err
+	}
+	if bound != backend {
+		return "", fmt.Errorf("%w: it was set up with the %s store, not %s", ErrStoreMismatch, bound, backend)
+	}
+	return instanceID, nil
+}
@@ -0,0 +1,147 @@
+package data
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Tx is a transaction on a store's database, handed out by Store.Update.
+// Queries are written with ? placeholders and rewritten for backends that
+// number them, so bookkeeping that has to commit together with business rows
+// is written once in this package for every backend.
+type Tx struct {
+	tx       *sql.Tx
+	numbered bool
+}
+
+func (t *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.tx.Exec(t.rebind(query), args...)
+}
+
+func (t *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.tx.Query(t.rebind(query), args...)
+}
+
+func (t *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return t.tx.QueryRow(t.rebind(query), args...)
+}
+
+func (t *Tx) rebind(query string) string {
+	if !t.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// RunTx runs fn inside tx, committing when it returns nil and rolling back
+// otherwise. numbered rewrites ? placeholders as $1, $2, ... for PostgreSQL.
+// Store.Update implementations are built on it.
+func RunTx(tx *sql.Tx, numbered bool, fn func(*Tx) error) error {
+	defer func() { _ = tx.Rollback() }()
+	if err := fn(&Tx{tx: tx, numbered: numbered}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// updateControl runs fn in one transaction on the control database.
+func updateControl(fn func(*Tx) error) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	return RunTx(tx, false, fn)
+}
+
+// locationTables are the store tables whose rows belong to a location.
+var locationTables = []string{"employees", "payroll_events", "sales", "labor", "location_closures", "import_runs", "location_profiles"}
+
+// deleteLocationRows deletes a location and every row the store keeps for it
+// inside tx. PostgreSQL would cascade most of these; spelling them out gives
+// every backend the same result.
+func deleteLocationRows(tx *Tx, id int) error {
+	for _, table := range locationTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE location_id = ?", id); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("DELETE FROM locations WHERE id = ?", id)
+	return err
+}
+
+// employeeInTx reads one of the location's employees inside tx, so a change
+// can be compared against the row it is about to replace.
+func employeeInTx(tx *Tx, locationID, id int) (Employee, error) {
+	var emp Employee
+	err := tx.QueryRow(
+		`SELECT id, location_id, first_name, last_name, time_punch_name, birthday, department, terminated, termination_date, annual_salary
+		FROM employees WHERE id = ? AND location_id = ?`,
+		id, locationID,
+	).Scan(
+		&emp.ID, &emp.LocationID, &emp.FirstName, &emp.LastName, &emp.TimePunchName, &emp.Birthday,
+		&emp.Department, &emp.Terminated, &emp.TerminationDate, &emp.AnnualSalary)
+	return emp, err
+}
+
+// CreatePayrollEventTx adds a payroll event inside tx and returns its ID, so
+// the event and its audit entry commit together.
+func CreatePayrollEventTx(tx *Tx, locationID, employeeID int, date, eventType, description string, amount float64) (int, error) {
+	var id int
+	err := tx.QueryRow(
+		"INSERT INTO payroll_events (location_id, employee_id, date, event_type, description, amount) VALUES (?, ?, ?, ?, ?, ?) RETURNING id",
+		locationID, employeeID, date, eventType, description, amount,
+	).Scan(&id)
+	return id, err
+}
+
+// UpdateLocationTx renames a location inside tx.
+func UpdateLocationTx(tx *Tx, id int, name, number string) error {
+	_, err := tx.Exec("UPDATE locations SET name = ?, number = ? WHERE id = ?", name, number, id)
+	return err
+}
+
+// SaveSalesBatchTx replaces the location's sales for date with records inside
+// tx.
+func SaveSalesBatchTx(tx *Tx, locationID int, date string, records []SaleRecord) error {
+	if _, err := tx.Exec("DELETE FROM sales WHERE location_id = ? AND date = ?", locationID, date); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if _, err := tx.Exec(
+			`INSERT INTO sales (location_id, date, category, item, amount) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(location_id, date, category, item) DO UPDATE SET amount = excluded.amount`,
+			locationID, date, rec.Category, rec.Item, rec.Amount,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveLaborTx stores the location's labor for date inside tx, replacing what
+// was there.
+func SaveLaborTx(tx *Tx, locationID int, date string, regularHours, overtimeHours, regularWages, overtimeWages float64) error {
+	_, err := tx.Exec(
+		`INSERT INTO labor (location_id, date, regular_hours, overtime_hours, regular_wages, overtime_wages)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(location_id, date) DO UPDATE SET
+			regular_hours = excluded.regular_hours,
+			overtime_hours = excluded.overtime_hours,
+			regular_wages = excluded.regular_wages,
+			overtime_wages = excluded.overtime_wages`,
+		locationID, date, regularHours, overtimeHours, regularWages, overtimeWages,
+	)
+	return err
+}
@@ -0,0 +1,20 @@
+package storetest_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/phillip-england/totem/pkg/data"
+	"github.com/phillip-england/totem/pkg/data/storetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	if err := data.Open(filepath.Join(t.TempDir(), "totem.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer data.DB.Close()
+	if _, err := data.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	storetest.Run(t, data.SQLiteStore{})
+}
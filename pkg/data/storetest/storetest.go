@@ -0,0 +1,454 @@
+// Package storetest is the contract every data.Store must meet. Each
+// backend's tests pass a store to Run, so a behaviour one backend has and
+// another lacks fails a subtest instead of turning up in production.
+package storetest
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/phillip-england/totem/pkg/data"
+)
+
+// Run checks store against the contract. Each subtest works in a location of
+// its own, deleted when it finishes, so store may already hold data. The
+// control database behind data.DB must be open and migrated, since new
+// locations are assigned to an organization there.
+func Run(t *testing.T, store data.Store) {
+	t.Run("Locations", func(t *testing.T) { testLocations(t, store) })
+	t.Run("Employees", func(t *testing.T) { testEmployees(t, store) })
+	t.Run("EmployeeChanges", func(t *testing.T) { testEmployeeChanges(t, store) })
+	t.Run("PayrollEvents", func(t *testing.T) { testPayrollEvents(t, store) })
+	t.Run("Sales", func(t *testing.T) { testSales(t, store) })
+	t.Run("Labor", func(t *testing.T) { testLabor(t, store) })
+	t.Run("Closures", func(t *testing.T) { testClosures(t, store) })
+	t.Run("LocationProfile", func(t *testing.T) { testLocationProfile(t, store) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, store) })
+	t.Run("DeleteLocation", func(t *testing.T) { testDeleteLocation(t, store) })
+}
+
+func testLocations(t *testing.T, store data.Store) {
+	id := newLocation(t, store)
+	loc, err := store.GetLocationByID(id)
+	if err != nil {
+		t.Fatalf("GetLocationByID: %v", err)
+	}
+	if loc.ID != id || loc.Number != "00000" {
+		t.Errorf("GetLocationByID = %+v, want ID %d and number 00000", loc, id)
+	}
+
+	if err := store.UpdateLocation(id, "Renamed", "12345"); err != nil {
+		t.Fatalf("UpdateLocation: %v", err)
+	}
+	loc, err = store.GetLocationByID(id)
+	if err != nil {
+		t.Fatalf("GetLocationByID: %v", err)
+	}
+	if loc.Name != "Renamed" || loc.Number != "12345" {
+		t.Errorf("after UpdateLocation got %q %q, want Renamed 12345", loc.Name, loc.Number)
+	}
+
+	locations, err := store.GetAllLocations()
+	if err != nil {
+		t.Fatalf("GetAllLocations: %v", err)
+	}
+	found := false
+	for _, l := range locations {
+		found = found || l.ID == id
+	}
+	if !found {
+		t.Errorf("GetAllLocations doesn't include location %d", id)
+	}
+}
+
+func testEmployees(t *testing.T, store data.Store) {
+	locationID := newLocation(t, store)
+	emp := newEmployee(t, store, locationID, "Ann", "Lee")
+	if emp.LocationID != locationID || emp.Terminated {
+		t.Fatalf("new employee = %+v, want active at location %d", emp, locationID)
+	}
+
+	if err := store.UpdateEmployee(emp.ID, "Anne", "Lee", "1990-05-01", "FOH", 35000); err != nil {
+		t.Fatalf("UpdateEmployee: %v", err)
+	}
+	got, err := store.GetEmployeeByID(emp.ID)
+	if err != nil {
+		t.Fatalf("GetEmployeeByID: %v", err)
+	}
+	if got.FirstName != "Anne" || got.Birthday != "1990-05-01" || got.Department != "FOH" || got.AnnualSalary != 35000 {
+		t.Errorf("after UpdateEmployee got %+v", got)
+	}
+
+	if err := store.TerminateEmployee(emp.ID, "2024-03-01"); err != nil {
+		t.Fatalf("TerminateEmployee: %v", err)
+	}
+	if has(t, store.GetActiveEmployeesByLocation, locationID, emp.ID) {
+		t.Error("terminated employee is still active")
+	}
+	if has(t, store.GetEmployeesByLocation, locationID, emp.ID) {
+		t.Error("GetEmployeesByLocation includes a terminated employee")
+	}
+	if !has(t, store.GetTerminatedEmployeesByLocation, locationID, emp.ID) {
+		t.Error("terminated employee isn't listed as terminated")
+	}
+	if !has(t, store.GetAllEmployeesByLocation, locationID, emp.ID) {
+		t.Error("GetAllEmployeesByLocation leaves out a terminated employee")
+	}
+	got, err = store.GetEmployeeByID(emp.ID)
+	if err != nil {
+		t.Fatalf("GetEmployeeByID: %v", err)
+	}
+	if !got.Terminated || got.TerminationDate != "2024-03-01" {
+		t.Errorf("after TerminateEmployee got terminated %v on %q", got.Terminated, got.TerminationDate)
+	}
+
+	if err := store.ReinstateEmployee(emp.ID); err != nil {
+		t.Fatalf("ReinstateEmployee: %v", err)
+	}
+	got, err = store.GetEmployeeByID(emp.ID)
+	if err != nil {
+		t.Fatalf("GetEmployeeByID: %v", err)
+	}
+	if got.Terminated || got.TerminationDate != "" {
+		t.Errorf("after ReinstateEmployee got terminated %v on %q", got.Terminated, got.TerminationDate)
+	}
+
+	if err := store.DeleteEmployee(emp.ID); err != nil {
+		t.Fatalf("DeleteEmployee: %v", err)
+	}
+	if _, err := store.GetEmployeeByID(emp.ID); err == nil {
+		t.Error("GetEmployeeByID found a deleted employee")
+	}
+}
+
+func testEmployeeChanges(t *testing.T, store data.Store) {
+	locationID := newLocation(t, store)
+	ann := newEmployee(t, store, locationID, "Ann", "Lee")
+
+	changes := []data.EmployeeChange{
+		{Kind: data.EmployeeCreate, FirstName: "Bob", LastName: "Ray"},
+		{Kind: data.EmployeeUpdate, EmployeeID: ann.ID, FirstName: "Ann", LastName: "Lee", Department: "BOH"},
+		{Kind: data.EmployeeTerminate, EmployeeID: ann.ID, TerminationDate: "2024-03-01"},
+	}
+	if err := store.ApplyEmployeeChanges(locationID, changes); err != nil {
+		t.Fatalf("ApplyEmployeeChanges: %v", err)
+	}
+	bob := findEmployee(t, store, locationID, "Bob", "Ray")
+	if changes[0].EmployeeID != bob.ID {
+		t.Errorf("created employee's ID = %d, want %d", changes[0].EmployeeID, bob.ID)
+	}
+	got, err := store.GetEmployeeByID(ann.ID)
+	if err != nil {
+		t.Fatalf("GetEmployeeByID: %v", err)
+	}
+	if got.Department != "BOH" || !got.Terminated {
+		t.Errorf("after ApplyEmployeeChanges got %+v", got)
+	}
+
+	// A change to another location's employee fails the batch and undoes
+	// the changes before it.
+	other := newLocation(t, store)
+	stranger := newEmployee(t, store, other, "Cal", "Day")
+	err = store.ApplyEmployeeChanges(locationID, []data.EmployeeChange{
+		{Kind: data.EmployeeReinstate, EmployeeID: ann.ID},
+		{Kind: data.EmployeeTerminate, EmployeeID: stranger.ID, TerminationDate: "2024-03-01"},
+	})
+	var changeErr *data.EmployeeChangeError
+	if !errors.As(err, &changeErr) || changeErr.Index != 1 {
+		t.Fatalf("ApplyEmployeeChanges error = %v, want an *EmployeeChangeError at index 1", err)
+	}
+	got, err = store.GetEmployeeByID(ann.ID)
+	if err != nil {
+		t.Fatalf("GetEmployeeByID: %v", err)
+	}
+	if !got.Terminated {
+		t.Error("a failed batch kept the change before the failure")
+	}
+	got, err = store.GetEmployeeByID(stranger.ID)
+	if err != nil {
+		t.Fatalf("GetEmployeeByID: %v", err)
+	}
+	if got.Terminated {
+		t.Error("a batch changed another location's employee")
+	}
+}
+
+func testPayrollEvents(t *testing.T, store data.Store) {
+	locationID := newLocation(t, store)
+	emp := newEmployee(t, store, locationID, "Ann", "Lee")
+	if err := store.CreatePayrollEvent(locationID, emp.ID, "2024-02-10", "Bonus", "Great month", 150); err != nil {
+		t.Fatalf("CreatePayrollEvent: %v", err)
+	}
+	if err := store.CreatePayrollEvent(locationID, emp.ID, "2024-03-10", "Bonus", "Next month", 50); err != nil {
+		t.Fatalf("CreatePayrollEvent: %v", err)
+	}
+
+	events, err := store.GetPayrollEventsByLocation(locationID, "2024-02-01", "2024-02-29")
+	if err != nil {
+		t.Fatalf("GetPayrollEventsByLocation: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events in February, want 1", len(events))
+	}
+	event := events[0]
+	if event.EmployeeID != emp.ID || event.EmployeeName != "Ann Lee" || event.Date != "2024-02-10" || event.Amount != 150 {
+		t.Errorf("got event %+v", event)
+	}
+
+	if err := store.DeletePayrollEvent(event.ID); err != nil {
+		t.Fatalf("DeletePayrollEvent: %v", err)
+	}
+	events, err = store.GetPayrollEventsByLocation(locationID, "2024-02-01", "2024-02-29")
+	if err != nil {
+		t.Fatalf("GetPayrollEventsByLocation: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("got %d events after DeletePayrollEvent, want 0", len(events))
+	}
+}
+
+func testSales(t *testing.T, store data.Store) {
+	locationID := newLocation(t, store)
+	records := []data.SaleRecord{
+		{Category: "Breakfast", Item: "Drive Thru", Amount: 100},
+		{Category: "Breakfast", Item: "Front Counter", Amount: 300},
+	}
+	if err := store.SaveSalesBatch(locationID, "2024-02-10", records); err != nil {
+		t.Fatalf("SaveSalesBatch: %v", err)
+	}
+
+	got, err := store.GetSalesByDate(locationID, "2024-02-10")
+	if err != nil {
+		t.Fatalf("GetSalesByDate: %v", err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("got %d records, want %d", len(got), len(records))
+	}
+	for _, rec := range got {
+		want := 25.0
+		if rec.Item == "Front Counter" {
+			want = 75
+		}
+		if math.Abs(rec.Percent-want) > 0.001 {
+			t.Errorf("%s is %.2f%% of its category, want %.2f%%", rec.Item, rec.Percent, want)
+		}
+	}
+
+	total, err := store.GetTotalSalesByLocation(locationID, "2024-02-01", "2024-02-29")
+	if err != nil {
+		t.Fatalf("GetTotalSalesByLocation: %v", err)
+	}
+	if total != 400 {
+		t.Errorf("GetTotalSalesByLocation = %v, want 400", total)
+	}
+}
+
+func testLabor(t *testing.T, store data.Store) {
+	locationID := newLocation(t, store)
+	if err := store.SaveLabor(locationID, "2024-02-10", 40, 2, 600, 45); err != nil {
+		t.Fatalf("SaveLabor: %v", err)
+	}
+	if err := store.SaveLabor(locationID, "2024-02-10", 38, 0, 570, 0); err != nil {
+		t.Fatalf("SaveLabor: %v", err)
+	}
+	got, err := store.GetLaborByDate(locationID, "2024-02-10")
+	if err != nil {
+		t.Fatalf("GetLaborByDate: %v", err)
+	}
+	want := data.LaborRecord{RegularHours: 38, RegularWages: 570}
+	if got != want {
+		t.Errorf("GetLaborByDate = %+v, want the second save %+v", got, want)
+	}
+
+	got, err = store.GetLaborByDate(locationID, "2024-02-11")
+	if err != nil {
+		t.Fatalf("GetLaborByDate for a day without labor: %v", err)
+	}
+	if got != (data.LaborRecord{}) {
+		t.Errorf("GetLaborByDate for a day without labor = %+v, want zero", got)
+	}
+}
+
+func testClosures(t *testing.T, store data.Store) {
+	locationID := newLocation(t, store)
+	if err := store.AddClosure(locationID, "2024-07-04", "Holiday"); err != nil {
+		t.Fatalf("AddClosure: %v", err)
+	}
+	if err := store.AddClosure(locationID, "2024-07-04", "Independence Day"); err != nil {
+		t.Fatalf("AddClosure on the same date: %v", err)
+	}
+	closures, err := store.GetClosures(locationID)
+	if err != nil {
+		t.Fatalf("GetClosures: %v", err)
+	}
+	if len(closures) != 1 || closures[0].Name != "Independence Day" {
+		t.Fatalf("GetClosures = %+v, want one closure renamed to Independence Day", closures)
+	}
+
+	if err := store.DeleteClosure(locationID, closures[0].ID); err != nil {
+		t.Fatalf("DeleteClosure: %v", err)
+	}
+	if err := store.DeleteClosure(locationID, closures[0].ID); !errors.Is(err, data.ErrClosureNotFound) {
+		t.Errorf("DeleteClosure of a deleted closure = %v, want ErrClosureNotFound", err)
+	}
+}
+
+func testLocationProfile(t *testing.T, store data.Store) {
+	locationID := newLocation(t, store)
+	profile, err := store.GetLocationProfile(locationID)
+	if err != nil {
+		t.Fatalf("GetLocationProfile without a saved profile: %v", err)
+	}
+	if profile.LocationID != locationID || profile.Address != "" {
+		t.Errorf("unsaved profile = %+v, want the defaults", profile)
+	}
+
+	profile.Timezone = "America/Chicago"
+	profile.Address = "1 Main St"
+	profile.TargetProductivity = 80
+	profile.Holidays = []string{"christmas"}
+	if err := store.SaveLocationProfile(profile); err != nil {
+		t.Fatalf("SaveLocationProfile: %v", err)
+	}
+	got, err := store.GetLocationProfile(locationID)
+	if err != nil {
+		t.Fatalf("GetLocationProfile: %v", err)
+	}
+	if got.Timezone != profile.Timezone || got.Address != profile.Address || got.TargetProductivity != 80 ||
+		len(got.Holidays) != 1 || got.Holidays[0] != "christmas" {
+		t.Errorf("GetLocationProfile = %+v, want %+v", got, profile)
+	}
+}
+
+func testUpdate(t *testing.T, store data.Store) {
+	locationID := newLocation(t, store)
+	failed := errors.New("failed")
+	err := store.Update(func(tx *data.Tx) error {
+		if err := data.ApplyEmployeeChangesTx(tx, locationID, []data.EmployeeChange{{Kind: data.EmployeeCreate, FirstName: "Ann", LastName: "Lee"}}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("Update = %v, want the error fn returned", err)
+	}
+	if employees, _ := store.GetAllEmployeesByLocation(locationID); len(employees) != 0 {
+		t.Error("Update kept a change after fn failed")
+	}
+
+	var count int
+	err = store.Update(func(tx *data.Tx) error {
+		if err := data.ApplyEmployeeChangesTx(tx, locationID, []data.EmployeeChange{{Kind: data.EmployeeCreate, FirstName: "Bob", LastName: "Ray"}}); err != nil {
+			return err
+		}
+		return tx.QueryRow("SELECT COUNT(*) FROM employees WHERE location_id = ? AND last_name = ?", locationID, "Ray").Scan(&count)
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("inside Update the new employee was counted %d times, want 1", count)
+	}
+	findEmployee(t, store, locationID, "Bob", "Ray")
+}
+
+// testDeleteLocation checks that nothing the store keeps for a location
+// outlives it. The PostgreSQL store relies on ON DELETE CASCADE while the
+// SQLite store deletes each table's rows itself, so a table added to one
+// and not the other shows up here.
+func testDeleteLocation(t *testing.T, store data.Store) {
+	locationID := newLocation(t, store)
+	emp := newEmployee(t, store, locationID, "Ann", "Lee")
+	mustDo(t, "CreatePayrollEvent", store.CreatePayrollEvent(locationID, emp.ID, "2024-02-10", "Bonus", "", 10))
+	mustDo(t, "SaveSalesBatch", store.SaveSalesBatch(locationID, "2024-02-10", []data.SaleRecord{{Category: "Lunch", Item: "Drive Thru", Amount: 10}}))
+	mustDo(t, "SaveLabor", store.SaveLabor(locationID, "2024-02-10", 8, 0, 120, 0))
+	mustDo(t, "AddClosure", store.AddClosure(locationID, "2024-12-25", "Christmas"))
+	profile := data.NewLocationProfile(locationID)
+	profile.Address = "1 Main St"
+	mustDo(t, "SaveLocationProfile", store.SaveLocationProfile(profile))
+	_, err := data.CreateImportRun(store, data.ImportRun{LocationID: locationID, Kind: data.ImportTimePunch, Source: []byte("report")})
+	mustDo(t, "CreateImportRun", err)
+
+	mustDo(t, "DeleteLocation", store.DeleteLocation(locationID))
+
+	if _, err := store.GetLocationByID(locationID); err == nil {
+		t.Error("location is still there")
+	}
+	if employees, err := store.GetAllEmployeesByLocation(locationID); err != nil || len(employees) != 0 {
+		t.Errorf("employees left behind: %d (%v)", len(employees), err)
+	}
+	if events, err := store.GetPayrollEventsByLocation(locationID, "2024-01-01", "2024-12-31"); err != nil || len(events) != 0 {
+		t.Errorf("payroll events left behind: %d (%v)", len(events), err)
+	}
+	if sales, err := store.GetSalesByDate(locationID, "2024-02-10"); err != nil || len(sales) != 0 {
+		t.Errorf("sales left behind: %d (%v)", len(sales), err)
+	}
+	if labor, err := store.GetLaborByDate(locationID, "2024-02-10"); err != nil || labor != (data.LaborRecord{}) {
+		t.Errorf("labor left behind: %+v (%v)", labor, err)
+	}
+	if closures, err := store.GetClosures(locationID); err != nil || len(closures) != 0 {
+		t.Errorf("closures left behind: %d (%v)", len(closures), err)
+	}
+	if profile, err := store.GetLocationProfile(locationID); err != nil || profile.Address != "" {
+		t.Errorf("profile left behind: %+v (%v)", profile, err)
+	}
+	if runs, err := data.GetImportRuns(store, locationID); err != nil || len(runs) != 0 {
+		t.Errorf("import runs left behind: %d (%v)", len(runs), err)
+	}
+}
+
+func newLocation(t *testing.T, store data.Store) int {
+	t.Helper()
+	id, err := store.CreateLocation(data.DefaultOrganizationID, "Store Test "+t.Name(), "00000")
+	if err != nil {
+		t.Fatalf("CreateLocation: %v", err)
+	}
+	t.Cleanup(func() { _ = store.DeleteLocation(id) })
+	return id
+}
+
+func newEmployee(t *testing.T, store data.Store, locationID int, firstName, lastName string) data.Employee {
+	t.Helper()
+	if err := store.CreateEmployee(locationID, firstName, lastName); err != nil {
+		t.Fatalf("CreateEmployee: %v", err)
+	}
+	return findEmployee(t, store, locationID, firstName, lastName)
+}
+
+func findEmployee(t *testing.T, store data.Store, locationID int, firstName, lastName string) data.Employee {
+	t.Helper()
+	employees, err := store.GetAllEmployeesByLocation(locationID)
+	if err != nil {
+		t.Fatalf("GetAllEmployeesByLocation: %v", err)
+	}
+	for _, emp := range employees {
+		if emp.FirstName == firstName && emp.LastName == lastName {
+			return emp
+		}
+	}
+	t.Fatalf("no employee named %s %s at location %d", firstName, lastName, locationID)
+	return data.Employee{}
+}
+
+// has reports whether list returns the employee for the location.
+func has(t *testing.T, list func(int) ([]data.Employee, error), locationID, employeeID int) bool {
+	t.Helper()
+	employees, err := list(locationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, emp := range employees {
+		if emp.ID == employeeID {
+			return true
+		}
+	}
+	return false
+}
+
+func mustDo(t *testing.T, what string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+}
@@ -0,0 +1,276 @@
+package data
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	TrashLocation     = "location"
+	TrashEmployee     = "employee"
+	TrashPayrollEvent = "payroll_event"
+)
+
+// TrashRetention is how long trashed records can be restored before
+// PurgeTrash deletes them for good.
+var TrashRetention = 30 * 24 * time.Hour
+
+var ErrNotTrashed = errors.New("record is not in the trash")
+
+type TrashItem struct {
+	EntityType string
+	EntityID   int
+	LocationID int
+	Label      string
+	DeletedBy  int
+	DeletedAt  time.Time
+}
+
+func (t TrashItem) PurgeAt() time.Time {
+	return t.DeletedAt.Add(TrashRetention)
+}
+
+// MoveToTrash hides a location, employee or payroll event without deleting
+// its row, and writes audit in the same transaction. label is a display name
+// kept so the trash page doesn't need to load the hidden record.
+func MoveToTrash(entityType string, entityID, locationID int, label string, deletedBy int, audit AuditEntry) error {
+	return updateControl(func(tx *Tx) error {
+		if _, err := tx.Exec(
+			`INSERT OR REPLACE INTO trash (entity_type, entity_id, location_id, label, deleted_by, deleted_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			entityType, entityID, locationID, label, deletedBy, time.Now(),
+		); err != nil {
+			return err
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+func RestoreFromTrash(entityType string, entityID int, audit AuditEntry) error {
+	return updateControl(func(tx *Tx) error {
+		res, err := tx.Exec("DELETE FROM trash WHERE entity_type = ? AND entity_id = ?", entityType, entityID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotTrashed
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+func IsTrashed(entityType string, entityID int) bool {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM trash WHERE entity_type = ? AND entity_id = ?", entityType, entityID).Scan(&count)
+	return err == nil && count > 0
+}
+
+func GetTrashedItem(entityType string, entityID int) (TrashItem, error) {
+	var item TrashItem
+	err := DB.QueryRow(
+		"SELECT entity_type, entity_id, location_id, label, deleted_by, deleted_at FROM trash WHERE entity_type = ? AND entity_id = ?",
+		entityType, entityID,
+	).Scan(&item.EntityType, &item.EntityID, &item.LocationID, &item.Label, &item.DeletedBy, &item.DeletedAt)
+	return item, err
+}
+
+func GetTrash() ([]TrashItem, error) {
+	rows, err := DB.Query("SELECT entity_type, entity_id, location_id, label, deleted_by, deleted_at FROM trash ORDER BY deleted_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []TrashItem
+	for rows.Next() {
+		var item TrashItem
+		if err := rows.Scan(&item.EntityType, &item.EntityID, &item.LocationID, &item.Label, &item.DeletedBy, &item.DeletedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func getTrashedIDs(entityType string) (map[int]bool, error) {
+	rows, err := DB.Query("SELECT entity_id FROM trash WHERE entity_type = ?", entityType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// GetActiveLocations is GetAllLocations without trashed locations.
+func GetActiveLocations(store Store) ([]CfaLocation, error) {
+	locations, err := store.GetAllLocations()
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := getTrashedIDs(TrashLocation)
+	if err != nil {
+		return nil, err
+	}
+	var active []CfaLocation
+	for _, loc := range locations {
+		if !trashed[loc.ID] {
+			active = append(active, loc)
+		}
+	}
+	return active, nil
+}
+
+// WithoutTrash wraps store so employee and payroll event lists leave out
+// trashed records, and the payroll events of trashed employees. Handlers read
+// through it, so no list can forget the filter. GetEmployeeByID still finds
+// trashed employees; callers that act on one check IsTrashed.
+func WithoutTrash(store Store) Store {
+	if _, ok := store.(trashFilter); ok {
+		return store
+	}
+	return trashFilter{store}
+}
+
+type trashFilter struct {
+	Store
+}
+
+func (f trashFilter) GetEmployeesByLocation(locationID int) ([]Employee, error) {
+	return withoutTrashedEmployees(f.Store.GetEmployeesByLocation(locationID))
+}
+
+func (f trashFilter) GetActiveEmployeesByLocation(locationID int) ([]Employee, error) {
+	return withoutTrashedEmployees(f.Store.GetActiveEmployeesByLocation(locationID))
+}
+
+func (f trashFilter) GetTerminatedEmployeesByLocation(locationID int) ([]Employee, error) {
+	return withoutTrashedEmployees(f.Store.GetTerminatedEmployeesByLocation(locationID))
+}
+
+func (f trashFilter) GetAllEmployeesByLocation(locationID int) ([]Employee, error) {
+	return withoutTrashedEmployees(f.Store.GetAllEmployeesByLocation(locationID))
+}
+
+func (f trashFilter) GetPayrollEventsByLocation(locationID int, startDate, endDate string) ([]PayrollEvent, error) {
+	events, err := f.Store.GetPayrollEventsByLocation(locationID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	trashedEvents, err := getTrashedIDs(TrashPayrollEvent)
+	if err != nil {
+		return nil, err
+	}
+	trashedEmployees, err := getTrashedIDs(TrashEmployee)
+	if err != nil {
+		return nil, err
+	}
+	kept := make([]PayrollEvent, 0, len(events))
+	for _, event := range events {
+		if !trashedEvents[event.ID] && !trashedEmployees[event.EmployeeID] {
+			kept = append(kept, event)
+		}
+	}
+	return kept, nil
+}
+
+func withoutTrashedEmployees(employees []Employee, err error) ([]Employee, error) {
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := getTrashedIDs(TrashEmployee)
+	if err != nil {
+		return nil, err
+	}
+	kept := make([]Employee, 0, len(employees))
+	for _, emp := range employees {
+		if !trashed[emp.ID] {
+			kept = append(kept, emp)
+		}
+	}
+	return kept, nil
+}
+
+// PurgeTrash permanently deletes records trashed longer than TrashRetention
+// and returns how many were removed.
+func PurgeTrash(store Store) (int, error) {
+	items, err := GetTrash()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-TrashRetention)
+	purged := 0
+	for _, item := range items {
+		if item.DeletedAt.After(cutoff) {
+			continue
+		}
+		if err := PurgeTrashedItem(store, item.EntityType, item.EntityID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// PurgeTrashedItem hard-deletes a single trashed record ahead of retention.
+// When the store shares the control database, the record, the rows kept for
+// it and its trash entry go in one transaction. Otherwise the store's rows go
+// first and the trash entry last, so a purge that fails halfway is retried.
+func PurgeTrashedItem(store Store, entityType string, entityID int) error {
+	if !IsTrashed(entityType, entityID) {
+		return ErrNotTrashed
+	}
+	var purgeStore func(tx *Tx) error
+	switch entityType {
+	case TrashLocation:
+		purgeStore = func(tx *Tx) error { return deleteLocationRows(tx, entityID) }
+	case TrashEmployee:
+		purgeStore = func(tx *Tx) error {
+			if _, err := tx.Exec("DELETE FROM payroll_events WHERE employee_id = ?", entityID); err != nil {
+				return err
+			}
+			_, err := tx.Exec("DELETE FROM employees WHERE id = ?", entityID)
+			return err
+		}
+	case TrashPayrollEvent:
+		purgeStore = func(tx *Tx) error {
+			_, err := tx.Exec("DELETE FROM payroll_events WHERE id = ?", entityID)
+			return err
+		}
+	default:
+		return errors.New("unknown trash entity type")
+	}
+	purgeControl := func(tx *Tx) error {
+		if entityType == TrashLocation {
+			if _, err := tx.Exec("DELETE FROM user_locations WHERE location_id = ?", entityID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("DELETE FROM organization_locations WHERE location_id = ?", entityID); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec("DELETE FROM trash WHERE entity_type = ? AND entity_id = ?", entityType, entityID)
+		return err
+	}
+
+	if sharesControlDB(store) {
+		return updateControl(func(tx *Tx) error {
+			if err := purgeStore(tx); err != nil {
+				return err
+			}
+			return purgeControl(tx)
+		})
+	}
+	if err := store.Update(purgeStore); err != nil {
+		return err
+	}
+	return updateControl(purgeControl)
+}
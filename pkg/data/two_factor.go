@@ -0,0 +1,262 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/phillip-england/totem/pkg/totp"
+)
+
+const (
+	recoveryCodeCount = 10
+	loginChallengeTTL = 5 * time.Minute
+	loginChallengeMax = 5
+)
+
+var (
+	ErrInvalidTOTPCode      = errors.New("invalid authentication code")
+	ErrLoginChallengeExpiry = errors.New("login challenge expired")
+)
+
+type TwoFactor struct {
+	UserID     int
+	Secret     string
+	Enabled    bool
+	EnrolledAt time.Time
+	LastStep   int64
+}
+
+func GetTwoFactor(userID int) (TwoFactor, error) {
+	tf := TwoFactor{UserID: userID}
+	var enrolledAt sql.NullTime
+	err := DB.QueryRow(
+		"SELECT secret, enabled, enrolled_at, last_step FROM user_totp WHERE user_id = ?", userID,
+	).Scan(&tf.Secret, &tf.Enabled, &enrolledAt, &tf.LastStep)
+	if err == sql.ErrNoRows {
+		return tf, nil
+	}
+	if err != nil {
+		return TwoFactor{}, err
+	}
+	tf.EnrolledAt = enrolledAt.Time
+	return tf, nil
+}
+
+// GetTwoFactorEnabledUserIDs returns the set of users that finished enrollment.
+func GetTwoFactorEnabledUserIDs() (map[int]bool, error) {
+	rows, err := DB.Query("SELECT user_id FROM user_totp WHERE enabled = 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	enabled := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		enabled[id] = true
+	}
+	return enabled, rows.Err()
+}
+
+// BeginTwoFactorEnrollment stores a fresh, not yet enabled secret for the
+// user. Enrollment is finished by ConfirmTwoFactorEnrollment.
+func BeginTwoFactorEnrollment(userID int) (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	_, err = DB.Exec(
+		`INSERT INTO user_totp (user_id, secret, enabled, last_step) VALUES (?, ?, 0, 0)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, enabled = 0, enrolled_at = NULL, last_step = 0`,
+		userID, secret,
+	)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// ConfirmTwoFactorEnrollment enables 2FA once the user proves their app
+// generates valid codes, and returns a new set of recovery codes.
+func ConfirmTwoFactorEnrollment(userID int, code string) ([]string, error) {
+	tf, err := GetTwoFactor(userID)
+	if err != nil {
+		return nil, err
+	}
+	if tf.Secret == "" {
+		return nil, errors.New("two-factor enrollment has not been started")
+	}
+	step, ok := totp.Validate(tf.Secret, code, time.Now(), 1)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	if _, err := DB.Exec(
+		"UPDATE user_totp SET enabled = 1, enrolled_at = ?, last_step = ? WHERE user_id = ?",
+		time.Now(), step, userID,
+	); err != nil {
+		return nil, err
+	}
+	return RegenerateRecoveryCodes(userID)
+}
+
+func DisableTwoFactor(userID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VerifyTwoFactorCode accepts either a current TOTP code or an unused recovery
+// code. TOTP codes are single use: a step at or before the last accepted one
+// is rejected.
+func VerifyTwoFactorCode(userID int, code string) error {
+	tf, err := GetTwoFactor(userID)
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled {
+		return errors.New("two-factor authentication is not enabled")
+	}
+	if step, ok := totp.Validate(tf.Secret, code, time.Now(), 1); ok {
+		// Claiming the step in the UPDATE itself means two logins racing
+		// with the same code can't both succeed.
+		res, err := DB.Exec("UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+	return useRecoveryCode(userID, code)
+}
+
+func RegenerateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := hex.EncodeToString(buf)
+		code := raw[:5] + "-" + raw[5:]
+		if _, err := tx.Exec(
+			"INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, hashRecoveryCode(code),
+		); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func CountUnusedRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func useRecoveryCode(userID int, code string) error {
+	res, err := DB.Exec(
+		"UPDATE user_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now(), userID, hashRecoveryCode(code),
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+// LoginChallenge is a password-verified login waiting for its second factor.
+type LoginChallenge struct {
+	UserID     int
+	RememberMe bool
+	ExpiresAt  time.Time
+	Attempts   int
+}
+
+func CreateLoginChallenge(userID int, rememberMe bool) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	_, err := DB.Exec(
+		"INSERT INTO login_challenges (token_hash, user_id, remember_me, expires_at, attempts) VALUES (?, ?, ?, ?, 0)",
+		hashChallengeToken(token), userID, rememberMe, time.Now().Add(loginChallengeTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func GetLoginChallenge(token string) (LoginChallenge, error) {
+	var challenge LoginChallenge
+	err := DB.QueryRow(
+		"SELECT user_id, remember_me, expires_at, attempts FROM login_challenges WHERE token_hash = ?",
+		hashChallengeToken(token),
+	).Scan(&challenge.UserID, &challenge.RememberMe, &challenge.ExpiresAt, &challenge.Attempts)
+	if err != nil {
+		return LoginChallenge{}, err
+	}
+	if time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= loginChallengeMax {
+		_ = DeleteLoginChallenge(token)
+		return LoginChallenge{}, ErrLoginChallengeExpiry
+	}
+	return challenge, nil
+}
+
+func RecordLoginChallengeFailure(token string) error {
+	_, err := DB.Exec("UPDATE login_challenges SET attempts = attempts + 1 WHERE token_hash = ?", hashChallengeToken(token))
+	return err
+}
+
+func DeleteLoginChallenge(token string) error {
+	_, err := DB.Exec("DELETE FROM login_challenges WHERE token_hash = ? OR expires_at < ?", hashChallengeToken(token), time.Now())
+	return err
+}
+
+func hashChallengeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
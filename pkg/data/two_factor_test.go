@@ -0,0 +1,156 @@
+package data
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phillip-england/totem/pkg/totp"
+)
+
+// openTestDB points DB at a migrated database in a temporary directory.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	if err := Open(filepath.Join(t.TempDir(), "totem.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DB.Close() })
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// enrollTwoFactor enables 2FA for userID and returns its secret and recovery
+// codes.
+func enrollTwoFactor(t *testing.T, userID int) (string, []string) {
+	t.Helper()
+	secret, err := BeginTwoFactorEnrollment(userID)
+	if err != nil {
+		t.Fatalf("BeginTwoFactorEnrollment: %v", err)
+	}
+	code, err := totp.CodeAt(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := ConfirmTwoFactorEnrollment(userID, code)
+	if err != nil {
+		t.Fatalf("ConfirmTwoFactorEnrollment: %v", err)
+	}
+	return secret, codes
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	openTestDB(t)
+	_, codes := enrollTwoFactor(t, 1)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	if err := VerifyTwoFactorCode(1, codes[0]); err != nil {
+		t.Fatalf("first use of a recovery code: %v", err)
+	}
+	if err := VerifyTwoFactorCode(1, codes[0]); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("second use of a recovery code = %v, want ErrInvalidTOTPCode", err)
+	}
+	if n, err := CountUnusedRecoveryCodes(1); err != nil || n != recoveryCodeCount-1 {
+		t.Errorf("CountUnusedRecoveryCodes = %d, %v, want %d", n, err, recoveryCodeCount-1)
+	}
+
+	// Codes are accepted without the dash and in upper case, and only for
+	// the user they were issued to.
+	_, other := enrollTwoFactor(t, 2)
+	if err := VerifyTwoFactorCode(1, other[0]); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("another user's recovery code = %v, want ErrInvalidTOTPCode", err)
+	}
+	if err := VerifyTwoFactorCode(2, "  "+other[1][:5]+other[1][6:]+"  "); err != nil {
+		t.Errorf("recovery code without its dash: %v", err)
+	}
+}
+
+func TestRegenerateRecoveryCodesReplacesOldOnes(t *testing.T) {
+	openTestDB(t)
+	_, old := enrollTwoFactor(t, 1)
+	codes, err := RegenerateRecoveryCodes(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyTwoFactorCode(1, old[1]); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("recovery code from before regenerating = %v, want ErrInvalidTOTPCode", err)
+	}
+	if err := VerifyTwoFactorCode(1, codes[1]); err != nil {
+		t.Errorf("regenerated recovery code: %v", err)
+	}
+}
+
+func TestTOTPCodesAreSingleUse(t *testing.T) {
+	openTestDB(t)
+	secret, _ := enrollTwoFactor(t, 1)
+
+	// The code confirming enrollment can't be used to log in.
+	tf, err := GetTwoFactor(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.CodeAt(secret, tf.LastStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyTwoFactorCode(1, code); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("reusing the enrollment code = %v, want ErrInvalidTOTPCode", err)
+	}
+
+	// The next step's code is inside the skew window and works once.
+	next, err := totp.CodeAt(secret, tf.LastStep+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyTwoFactorCode(1, next); err != nil {
+		t.Fatalf("next step's code: %v", err)
+	}
+	if err := VerifyTwoFactorCode(1, next); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("replaying a code = %v, want ErrInvalidTOTPCode", err)
+	}
+	if err := VerifyTwoFactorCode(1, code); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("an earlier step's code after a later one = %v, want ErrInvalidTOTPCode", err)
+	}
+}
+
+func TestConcurrentTOTPCodeIsAcceptedOnce(t *testing.T) {
+	openTestDB(t)
+	secret, _ := enrollTwoFactor(t, 1)
+	tf, err := GetTwoFactor(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.CodeAt(secret, tf.LastStep+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const logins = 8
+	errs := make([]error, logins)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = VerifyTwoFactorCode(1, code)
+		}(i)
+	}
+	wg.Wait()
+
+	accepted := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			accepted++
+		case !errors.Is(err, ErrInvalidTOTPCode):
+			t.Errorf("VerifyTwoFactorCode = %v, want nil or ErrInvalidTOTPCode", err)
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("the same code was accepted %d times, want once", accepted)
+	}
+}
@@ -0,0 +1,197 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrLastAdmin = errors.New("cannot remove the last active admin")
+
+// The user changes below take the audit entry that records them and write it
+// in the same transaction, so a change is never kept without its entry.
+
+func UpdateUserRole(userID int, role string, audit AuditEntry) error {
+	role = NormalizeRole(role)
+	if role == "" {
+		return errors.New("invalid role")
+	}
+	return updateControl(func(tx *Tx) error {
+		if !RoleHasPermission(role, PermManageUsers) {
+			if err := ensureAnotherAdmin(tx, userID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID); err != nil {
+			return err
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+// ResetUserPassword sets a new password for another user, keeping their
+// username, and signs them out everywhere. Only the core
+// UpdateUserCredentials hashes passwords and it commits on its own, so the
+// hash it writes is read back and the old one restored; the new hash is then
+// written with the session revocation and audit in one transaction.
+func ResetUserPassword(userID int, password string, audit AuditEntry) error {
+	if strings.TrimSpace(password) == "" {
+		return errors.New("password is required")
+	}
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	var oldHash, newHash string
+	if err := DB.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&oldHash); err != nil {
+		return err
+	}
+	if err := UpdateUserCredentials(userID, user.Username, password); err != nil {
+		return err
+	}
+	err = DB.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&newHash)
+	if _, restoreErr := DB.Exec("UPDATE users SET password_hash = ? WHERE id = ?", oldHash, userID); restoreErr != nil {
+		return restoreErr
+	}
+	if err != nil {
+		return err
+	}
+	return updateControl(func(tx *Tx) error {
+		if _, err := tx.Exec("UPDATE users SET password_hash = ? WHERE id = ?", newHash, userID); err != nil {
+			return err
+		}
+		if err := revokeUserSessions(tx, userID); err != nil {
+			return err
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+func IsUserDisabled(userID int) bool {
+	var count int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM disabled_users WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return false
+	}
+	return count > 0
+}
+
+func GetDisabledUserIDs() (map[int]bool, error) {
+	rows, err := DB.Query("SELECT user_id FROM disabled_users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disabled := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		disabled[id] = true
+	}
+	return disabled, rows.Err()
+}
+
+// DisableUser blocks a user from signing in and ends their sessions while
+// keeping the account for history.
+func DisableUser(userID int, audit AuditEntry) error {
+	return updateControl(func(tx *Tx) error {
+		if err := ensureAnotherAdmin(tx, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO disabled_users (user_id, disabled_at) VALUES (?, ?)", userID, time.Now()); err != nil {
+			return err
+		}
+		if err := revokeUserSessions(tx, userID); err != nil {
+			return err
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+func EnableUser(userID int, audit AuditEntry) error {
+	return updateControl(func(tx *Tx) error {
+		if _, err := tx.Exec("DELETE FROM disabled_users WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+func DeleteUser(userID int, audit AuditEntry) error {
+	return updateControl(func(tx *Tx) error {
+		if err := ensureAnotherAdmin(tx, userID); err != nil {
+			return err
+		}
+		if err := ensureAnotherSuperAdmin(tx, userID); err != nil {
+			return err
+		}
+		if err := revokeUserSessions(tx, userID); err != nil {
+			return err
+		}
+		for _, stmt := range []string{
+			"DELETE FROM user_locations WHERE user_id = ?",
+			"DELETE FROM user_totp WHERE user_id = ?",
+			"DELETE FROM user_recovery_codes WHERE user_id = ?",
+			"DELETE FROM disabled_users WHERE user_id = ?",
+			"DELETE FROM organization_users WHERE user_id = ?",
+			"DELETE FROM super_admins WHERE user_id = ?",
+			"DELETE FROM users WHERE id = ?",
+		} {
+			if _, err := tx.Exec(stmt, userID); err != nil {
+				return err
+			}
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+// ensureAnotherAdmin returns ErrLastAdmin when userID is the only enabled
+// user in their organization whose role can manage users. It reads inside tx,
+// before the change it guards, so two admins removing each other at once
+// can't both pass.
+func ensureAnotherAdmin(tx *Tx, userID int) error {
+	orgID := DefaultOrganizationID
+	err := tx.QueryRow("SELECT organization_id FROM organization_users WHERE user_id = ?", userID).Scan(&orgID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	rows, err := tx.Query(
+		`SELECT u.id, u.role FROM users u
+		LEFT JOIN organization_users ou ON ou.user_id = u.id
+		WHERE COALESCE(ou.organization_id, ?) = ?
+		AND u.id NOT IN (SELECT user_id FROM disabled_users)`,
+		DefaultOrganizationID, orgID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	isAdmin := false
+	others := 0
+	for rows.Next() {
+		var id int
+		var role string
+		if err := rows.Scan(&id, &role); err != nil {
+			return err
+		}
+		if !RoleHasPermission(role, PermManageUsers) {
+			continue
+		}
+		if id == userID {
+			isAdmin = true
+			continue
+		}
+		others++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if isAdmin && others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package data
+
+// HasAllLocationAccess reports whether the user sees every location in their
+// organization regardless of assignment. Only roles that can manage users are
+// unrestricted.
+func HasAllLocationAccess(user User) bool {
+	return RoleHasPermission(user.Role, PermManageUsers)
+}
+
+func GetLocationIDsForUser(userID int) ([]int, error) {
+	rows, err := DB.Query("SELECT location_id FROM user_locations WHERE user_id = ? ORDER BY location_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func SetUserLocations(userID int, locationIDs []int, audit AuditEntry) error {
+	return updateControl(func(tx *Tx) error {
+		if _, err := tx.Exec("DELETE FROM user_locations WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		for _, locationID := range locationIDs {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO user_locations (user_id, location_id) VALUES (?, ?)", userID, locationID); err != nil {
+				return err
+			}
+		}
+		return CreateAuditEntryTx(tx, audit)
+	})
+}
+
+// UserCanAccessLocation reports whether user may work with locationID while
+// working in orgID.
+func UserCanAccessLocation(user User, orgID, locationID int) (bool, error) {
+	if IsTrashed(TrashLocation, locationID) {
+		return false, nil
+	}
+	locationOrg, err := OrganizationIDForLocation(locationID)
+	if err != nil || locationOrg != orgID {
+		return false, err
+	}
+	if HasAllLocationAccess(user) {
+		return true, nil
+	}
+	var count int
+	err = DB.QueryRow("SELECT COUNT(*) FROM user_locations WHERE user_id = ? AND location_id = ?", user.ID, locationID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetLocationsForUser lists the locations in orgID that user is assigned to.
+func GetLocationsForUser(store Store, user User, orgID int) ([]CfaLocation, error) {
+	locations, err := GetActiveLocations(store)
+	if err != nil {
+		return nil, err
+	}
+	locations, err = LocationsInOrganization(locations, orgID)
+	if err != nil || HasAllLocationAccess(user) {
+		return locations, err
+	}
+	ids, err := GetLocationIDsForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		allowed[id] = true
+	}
+	var scoped []CfaLocation
+	for _, loc := range locations {
+		if allowed[loc.ID] {
+			scoped = append(scoped, loc)
+		}
+	}
+	return scoped, nil
+}
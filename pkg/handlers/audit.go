@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/phillip-england/totem/pkg/data"
+)
+
+// recordAudit writes an audit entry for a mutation made by the current user,
+// after the fact. Mutations that can write their entry in the same
+// transaction pass auditEntry to the data layer instead. A failure here
+// doesn't fail the request, since the change is already made, but it is
+// logged.
+func recordAudit(r *http.Request, locationID int, entityType string, entityID any, before, after any) {
+	entry := auditEntry(r, locationID, entityType, entityID, before, after)
+	if err := data.CreateAuditEntry(entry); err != nil {
+		fmt.Println("Writing audit entry failed:", entry.Route, entry.EntityType, entry.EntityID, err)
+	}
+}
+
+// auditEntry describes a mutation made by the current user. before and after
+// are stored as JSON; pass nil when a side doesn't exist, such as before on
+// a create.
+func auditEntry(r *http.Request, locationID int, entityType string, entityID any, before, after any) data.AuditEntry {
+	entry := data.AuditEntry{
+		LocationID: locationID,
+		Route:      r.Pattern,
+		EntityType: entityType,
+		Before:     auditJSON(before),
+		After:      auditJSON(after),
+	}
+	if entry.Route == "" {
+		entry.Route = r.Method + " " + r.URL.Path
+	}
+	switch id := entityID.(type) {
+	case nil:
+	case string:
+		entry.EntityID = id
+	case int:
+		if id != 0 {
+			entry.EntityID = strconv.Itoa(id)
+		}
+	default:
+		entry.EntityID = auditJSON(id)
+	}
+	if user, ok := currentUser(r); ok {
+		entry.OrganizationID = currentOrganizationID(r)
+		entry.UserID = user.ID
+		entry.Username = user.Username
+	}
+	return entry
+}
+
+// updateAudited runs fn in a store transaction and writes the entry it
+// returns in the same one, so the change and its record commit together.
+func (s *server) updateAudited(fn func(tx *data.Tx) (data.AuditEntry, error)) error {
+	return s.store.Update(func(tx *data.Tx) error {
+		entry, err := fn(tx)
+		if err != nil {
+			return err
+		}
+		return data.CreateAuditEntryTx(tx, entry)
+	})
+}
+
+// applyEmployeeChange applies one change to the location's employees and
+// writes its audit entry in the same transaction.
+func (s *server) applyEmployeeChange(r *http.Request, locationID int, change data.EmployeeChange, before, after any) error {
+	changes := []data.EmployeeChange{change}
+	return s.updateAudited(func(tx *data.Tx) (data.AuditEntry, error) {
+		err := data.ApplyEmployeeChangesTx(tx, locationID, changes)
+		return auditEntry(r, locationID, "employee", changes[0].EmployeeID, before, after), err
+	})
+}
+
+func auditJSON(value any) string {
+	if value == nil {
+		return ""
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
@@ -1,22 +1,62 @@
 package handlers
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/phillip-england/totem/pkg/config"
 	"github.com/phillip-england/totem/pkg/data"
 	"github.com/phillip-england/vii"
 )
 
-const sessionCookieName = "totem_session"
+const (
+	sessionCookieName        = "totem_session"
+	loginChallengeCookieName = "totem_login_challenge"
+	totpIssuer               = "Totem"
+)
+
+const sessionTouchInterval = time.Minute
+
+// Sessions slide: activity pushes the idle expiry out, but never past the
+// absolute lifetime fixed at login. "Remember me" widens both windows.
+// Configure overrides these from config.
+var (
+	sessionIdleTimeout    = 12 * time.Hour
+	sessionMaxLifetime    = 7 * 24 * time.Hour
+	rememberMeIdleTimeout = 30 * 24 * time.Hour
+	rememberMeMaxLifetime = 30 * 24 * time.Hour
+
+	rememberMeEnabled     = true
+	performanceAPIEnabled = true
+)
+
+// Configure applies startup configuration. It must be called before the
+// server starts handling requests.
+func Configure(cfg config.Config) {
+	sessionIdleTimeout = cfg.SessionIdleTimeout
+	sessionMaxLifetime = cfg.SessionMaxLifetime
+	rememberMeIdleTimeout = cfg.RememberMeLifetime
+	rememberMeMaxLifetime = cfg.RememberMeLifetime
+	rememberMeEnabled = cfg.Features.RememberMe
+	performanceAPIEnabled = cfg.Features.PerformanceAPI
+	if cfg.CSRFSecret != "" {
+		csrfKey = []byte(cfg.CSRFSecret)
+	}
+}
 
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := normalizeRequestPath(r)
 		session, user, ok := getSessionFromRequest(r)
 		if ok {
+			slideSession(w, session)
 			r = vii.SetContext("auth_user", user, r)
 			r = vii.SetContext("auth_session", session, r)
 		} else if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie != nil && cookie.Value != "" {
@@ -38,6 +78,11 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if strings.HasPrefix(path, "/admin") && requiresCSRFCheck(r) && !validCSRFToken(r, session) {
+			renderForbiddenMessage(w, r, "Your form session has expired or is invalid. Go back, reload the page and try again.")
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -59,35 +104,201 @@ func getSessionFromRequest(r *http.Request) (data.Session, data.User, bool) {
 	if err != nil || cookie == nil || cookie.Value == "" {
 		return data.Session{}, data.User{}, false
 	}
-	session, err := data.GetSessionByKey(cookie.Value)
+	sessionKey, keyID, signature, ok := parseSessionCookie(cookie.Value)
+	if !ok {
+		return data.Session{}, data.User{}, false
+	}
+	session, err := data.GetSessionByKey(sessionKey)
 	if err != nil {
 		return data.Session{}, data.User{}, false
 	}
+	signingKey, err := data.GetSessionSigningKeyByID(keyID)
+	if err != nil || !signingKey.Valid(time.Now()) {
+		return data.Session{}, data.User{}, false
+	}
+	if !hmac.Equal([]byte(signature), []byte(sessionSignature(signingKey, session))) {
+		return data.Session{}, data.User{}, false
+	}
 	if !session.ExpiresAt.IsZero() && time.Now().After(session.ExpiresAt) {
-		_ = data.DeleteSessionByKey(session.Key)
+		_ = data.RevokeSession(session.Key)
 		return data.Session{}, data.User{}, false
 	}
-	user, err := data.GetUserByID(session.UserID)
-	if err != nil {
+	// Sessions are only honoured while their info row exists; revoking a
+	// user's or everyone's sessions removes those rows.
+	info, err := data.GetSessionInfoByKey(session.Key)
+	if err != nil || time.Now().After(info.IdleExpiresAt) {
+		_ = data.RevokeSession(session.Key)
 		return data.Session{}, data.User{}, false
 	}
-	if isAdminUser(user) {
-		token := adminSessionPayload()
-		if token != "" && session.Payload != token {
-			_ = data.DeleteSessionByKey(session.Key)
-			return data.Session{}, data.User{}, false
-		}
-	} else if session.Payload != user.PasswordHash {
-		_ = data.DeleteSessionByKey(session.Key)
+	user, err := data.GetUserByID(session.UserID)
+	if err != nil || data.IsUserDisabled(user.ID) {
 		return data.Session{}, data.User{}, false
 	}
 	return session, user, true
 }
 
-func setSessionCookie(w http.ResponseWriter, key string, expiresAt time.Time) {
+// Session cookies carry "<session key>.<signing key id>.<signature>". The
+// signature is an HMAC over the session key and its random server-side secret,
+// so a cookie is only valid while both the session and the signing key are.
+func sessionSignature(key data.SessionSigningKey, session data.Session) string {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(session.Key))
+	mac.Write([]byte{'.'})
+	mac.Write([]byte(session.Payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signSessionCookie(session data.Session) (string, error) {
+	key, err := data.GetCurrentSessionSigningKey()
+	if err != nil {
+		return "", err
+	}
+	return session.Key + "." + strconv.Itoa(key.ID) + "." + sessionSignature(key, session), nil
+}
+
+func parseSessionCookie(value string) (string, int, string, bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return "", 0, "", false
+	}
+	keyID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, "", false
+	}
+	return parts[0], keyID, parts[2], true
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// startSession creates a session for user, records where it came from and sets
+// the session cookie.
+func startSession(w http.ResponseWriter, r *http.Request, user data.User, rememberMe bool) error {
+	secret, err := data.NewSessionSecret()
+	if err != nil {
+		return err
+	}
+	idle, lifetime := sessionIdleTimeout, sessionMaxLifetime
+	if rememberMe {
+		idle, lifetime = rememberMeIdleTimeout, rememberMeMaxLifetime
+	}
+	now := time.Now()
+	expiresAt := now.Add(lifetime)
+	idleExpiresAt := now.Add(idle)
+	sessionKey, err := data.CreateSession(user.ID, secret, expiresAt)
+	if err != nil {
+		return err
+	}
+	err = data.CreateSessionInfo(data.SessionInfo{
+		SessionKey:    sessionKey,
+		UserID:        user.ID,
+		IP:            clientIP(r),
+		UserAgent:     r.UserAgent(),
+		RememberMe:    rememberMe,
+		CreatedAt:     now,
+		LastSeenAt:    now,
+		ExpiresAt:     expiresAt,
+		IdleExpiresAt: idleExpiresAt,
+	})
+	if err != nil {
+		_ = data.DeleteSessionByKey(sessionKey)
+		return err
+	}
+	cookieValue, err := signSessionCookie(data.Session{Key: sessionKey, Payload: secret})
+	if err != nil {
+		_ = data.RevokeSession(sessionKey)
+		return err
+	}
+	setSessionCookie(w, cookieValue, idleExpiresAt)
+	return nil
+}
+
+// slideSession extends the idle expiry of an active session and re-signs its
+// cookie with the current signing key. Writes are skipped when the session
+// was touched within sessionTouchInterval.
+func slideSession(w http.ResponseWriter, session data.Session) {
+	info, err := data.GetSessionInfoByKey(session.Key)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(info.LastSeenAt) < sessionTouchInterval {
+		return
+	}
+	idle := sessionIdleTimeout
+	if info.RememberMe {
+		idle = rememberMeIdleTimeout
+	}
+	idleExpiresAt := now.Add(idle)
+	if idleExpiresAt.After(info.ExpiresAt) {
+		idleExpiresAt = info.ExpiresAt
+	}
+	if err := data.TouchSessionInfo(session.Key, now, idleExpiresAt); err != nil {
+		return
+	}
+	if cookieValue, err := signSessionCookie(session); err == nil {
+		setSessionCookie(w, cookieValue, idleExpiresAt)
+	}
+}
+
+// needsSecondFactor reports whether a password-verified login must pass the
+// 2FA step, either because the user enrolled or because admins must use it.
+func needsSecondFactor(user data.User) bool {
+	tf, err := data.GetTwoFactor(user.ID)
+	if err == nil && tf.Enabled {
+		return true
+	}
+	return isAdminUser(user) && data.GetBoolSetting(organizationIDForUser(user), data.SettingRequireAdmin2FA)
+}
+
+func loginChallengeFromRequest(r *http.Request) (data.LoginChallenge, data.User, string, bool) {
+	cookie, err := r.Cookie(loginChallengeCookieName)
+	if err != nil || cookie == nil || cookie.Value == "" {
+		return data.LoginChallenge{}, data.User{}, "", false
+	}
+	challenge, err := data.GetLoginChallenge(cookie.Value)
+	if err != nil {
+		return data.LoginChallenge{}, data.User{}, "", false
+	}
+	user, err := data.GetUserByID(challenge.UserID)
+	if err != nil {
+		return data.LoginChallenge{}, data.User{}, "", false
+	}
+	return challenge, user, cookie.Value, true
+}
+
+func setLoginChallengeCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginChallengeCookieName,
+		Value:    token,
+		Path:     "/login",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int((5 * time.Minute).Seconds()),
+	})
+}
+
+func clearLoginChallengeCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginChallengeCookieName,
+		Value:    "",
+		Path:     "/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  time.Unix(0, 0),
+	})
+}
+
+func setSessionCookie(w http.ResponseWriter, value string, expiresAt time.Time) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionCookieName,
-		Value:    key,
+		Value:    value,
 		Path:     "/",
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
@@ -108,11 +319,124 @@ func clearSessionCookie(w http.ResponseWriter) {
 }
 
 func isAdminUser(user data.User) bool {
-	return user.Role == "admin"
+	return data.RoleHasPermission(user.Role, data.PermManageUsers)
+}
+
+func hasPermission(r *http.Request, perm data.Permission) bool {
+	user, ok := currentUser(r)
+	if !ok {
+		return false
+	}
+	return data.RoleHasPermission(user.Role, perm)
+}
+
+// requirePermission wraps a route handler so it only runs for users whose
+// role grants perm. Routes with an {id} location segment additionally require
+// the user to be assigned to that location, and routes with a {userId}
+// segment require the target user to be in the same organization. Only
+// super-admins may act on another super-admin, since taking over one account
+// reaches every organization. Everyone else receives the 403 page.
+func requirePermission(perm data.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasPermission(r, perm) {
+			renderForbidden(w, r)
+			return
+		}
+		if userID, err := strconv.Atoi(r.PathValue("userId")); err == nil {
+			orgID, err := data.OrganizationIDForUser(userID)
+			if err != nil || orgID != currentOrganizationID(r) {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			if data.IsSuperAdmin(userID) && !isSuperAdmin(r) {
+				renderForbidden(w, r)
+				return
+			}
+		}
+		if id, err := strconv.Atoi(r.PathValue("id")); err == nil {
+			if data.IsTrashed(data.TrashLocation, id) {
+				http.Error(w, "Location not found", http.StatusNotFound)
+				return
+			}
+			if !canAccessLocation(r, id) {
+				renderForbidden(w, r)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// requireSuperAdmin guards organization management and instance-wide
+// operations that cross organizations.
+func requireSuperAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isSuperAdmin(r) {
+			renderForbidden(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func isSuperAdmin(r *http.Request) bool {
+	user, ok := currentUser(r)
+	return ok && data.IsSuperAdmin(user.ID)
+}
+
+// currentOrganizationID is the organization the signed-in user is working
+// in, or 0 when it can't be determined, which matches nothing. Super-admins
+// work in the organization chosen for their session, falling back to their
+// own membership until they switch.
+func currentOrganizationID(r *http.Request) int {
+	user, ok := currentUser(r)
+	if !ok {
+		return 0
+	}
+	if session, ok := currentSession(r); ok && data.IsSuperAdmin(user.ID) {
+		orgID, err := data.SessionOrganizationID(session.Key)
+		if err == nil && orgID != 0 {
+			if _, err := data.GetOrganizationByID(orgID); err == nil {
+				return orgID
+			}
+		}
+	}
+	return organizationIDForUser(user)
+}
+
+func organizationIDForUser(user data.User) int {
+	orgID, err := data.OrganizationIDForUser(user.ID)
+	if err != nil {
+		return 0
+	}
+	return orgID
+}
+
+func canAccessLocation(r *http.Request, locationID int) bool {
+	user, ok := currentUser(r)
+	if !ok {
+		return false
+	}
+	ok, err := data.UserCanAccessLocation(user, currentOrganizationID(r), locationID)
+	return err == nil && ok
 }
 
-func adminSessionPayload() string {
-	return strings.TrimSpace(os.Getenv("ADMIN_SESSION_TOKEN"))
+func renderForbidden(w http.ResponseWriter, r *http.Request) {
+	renderForbiddenMessage(w, r, "You do not have permission to view this page.")
+}
+
+func renderForbiddenMessage(w http.ResponseWriter, r *http.Request, message string) {
+	templateData := struct {
+		Title   string
+		Message string
+	}{
+		Title:   "Access Denied",
+		Message: message,
+	}
+	w.WriteHeader(http.StatusForbidden)
+	if err := renderTemplate(w, r, "forbidden.html", templateData); err != nil {
+		_, _ = w.Write([]byte(templateData.Message))
+	}
 }
 
 func currentUser(r *http.Request) (data.User, bool) {
@@ -120,3 +444,60 @@ func currentUser(r *http.Request) (data.User, bool) {
 	user, ok := val.(data.User)
 	return user, ok
 }
+
+func currentSession(r *http.Request) (data.Session, bool) {
+	val := vii.GetContext("auth_session", r)
+	session, ok := val.(data.Session)
+	return session, ok
+}
+
+// requireAPIToken authenticates JSON API routes with a bearer token created on
+// the users page. Failures are reported as JSON instead of redirects.
+func requireAPIToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		token, err := data.GetAPITokenBySecret(secret)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeJSONError(w, http.StatusUnauthorized, "invalid or revoked token")
+			return
+		}
+		if id, err := strconv.Atoi(r.PathValue("id")); err == nil {
+			if data.IsTrashed(data.TrashLocation, id) {
+				writeJSONError(w, http.StatusNotFound, "location not found")
+				return
+			}
+			if orgID, err := data.OrganizationIDForLocation(id); err != nil || orgID != token.OrganizationID {
+				writeJSONError(w, http.StatusNotFound, "location not found")
+				return
+			}
+			if !token.CanAccessLocation(id) {
+				writeJSONError(w, http.StatusForbidden, "token is not scoped to this location")
+				return
+			}
+		}
+		r = vii.SetContext("api_token", token, r)
+		next(w, r)
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	scheme, value, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	value = strings.TrimSpace(value)
+	return value, value != ""
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
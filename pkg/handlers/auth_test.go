@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/phillip-england/totem/pkg/data"
+	"github.com/phillip-england/vii"
+)
+
+func TestRequirePermissionProtectsSuperAdmins(t *testing.T) {
+	if err := data.Open(filepath.Join(t.TempDir(), "totem.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer data.DB.Close()
+	if _, err := data.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	// User 1 is a super-admin; users 2 and 3 are owners in the same
+	// organization who are not.
+	if _, err := data.DB.Exec("INSERT INTO super_admins (user_id) VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		actor  int
+		target string
+		status int
+	}{
+		{"owner on a super-admin", 2, "1", http.StatusForbidden},
+		{"owner on an owner", 2, "3", http.StatusOK},
+		{"super-admin on a super-admin", 1, "1", http.StatusOK},
+		{"super-admin on an owner", 1, "3", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		called := false
+		handler := requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})
+		r := httptest.NewRequest(http.MethodPost, "/admin/users/"+tt.target+"/password", nil)
+		r.SetPathValue("userId", tt.target)
+		r = vii.SetContext("auth_user", data.User{ID: tt.actor, Role: data.RoleOwner}, r)
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if called != (tt.status == http.StatusOK) {
+			t.Errorf("%s: handler ran = %v", tt.name, called)
+		}
+	}
+}
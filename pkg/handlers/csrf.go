@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/phillip-england/totem/pkg/data"
+)
+
+const (
+	csrfFormField  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// csrfKey is random for the life of the process unless Configure sets
+// CSRF_SECRET, which keeps tokens valid across restarts.
+var csrfKey = randomCSRFKey()
+
+func randomCSRFKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// csrfTokenForSession derives the per-session token. It is an HMAC of the
+// session key, so nothing extra needs to be stored and the token dies with
+// the session.
+func csrfTokenForSession(session data.Session) string {
+	if session.Key == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte(session.Key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func csrfToken(r *http.Request) string {
+	session, ok := currentSession(r)
+	if !ok {
+		return ""
+	}
+	return csrfTokenForSession(session)
+}
+
+func requiresCSRFCheck(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+func validCSRFToken(r *http.Request, session data.Session) bool {
+	expected := csrfTokenForSession(session)
+	if expected == "" {
+		return false
+	}
+	submitted := r.Header.Get(csrfHeaderName)
+	if submitted == "" {
+		submitted = r.FormValue(csrfFormField)
+	}
+	return hmac.Equal([]byte(submitted), []byte(expected))
+}
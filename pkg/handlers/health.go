@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/phillip-england/totem/pkg/data"
+	"github.com/phillip-england/vii"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+var draining atomic.Bool
+
+// BeginShutdown makes /readyz fail so a proxy or supervisor stops sending new
+// traffic while in-flight requests finish.
+func BeginShutdown() {
+	draining.Store(true)
+}
+
+func (s *server) registerHealthRoutes(app *vii.App) {
+	// Liveness: the process is up and can reach its databases.
+	app.At("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]string{
+			"database": checkDatabase(r.Context()),
+			"store":    s.checkStore(r.Context()),
+		}
+		writeHealth(app, w, checks)
+	})
+
+	// Readiness: also confirms templates render and the server isn't draining.
+	app.At("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]string{
+			"database":  checkDatabase(r.Context()),
+			"store":     s.checkStore(r.Context()),
+			"templates": checkTemplates(r),
+			"draining":  "ok",
+		}
+		if draining.Load() {
+			checks["draining"] = "shutting down"
+		}
+		writeHealth(app, w, checks)
+	})
+}
+
+func writeHealth(app *vii.App, w http.ResponseWriter, checks map[string]string) {
+	status, code := "ok", http.StatusOK
+	for _, result := range checks {
+		if result != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+			break
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	app.JSON(w, code, map[string]any{"status": status, "checks": checks})
+}
+
+func checkDatabase(ctx context.Context) string {
+	if data.DB == nil {
+		return "not initialized"
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if err := data.DB.PingContext(ctx); err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+func (s *server) checkStore(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if err := s.store.Ping(ctx); err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// checkTemplates renders the login page into a throwaway recorder, which fails
+// if the template set didn't load.
+func checkTemplates(r *http.Request) string {
+	probe := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(r.Context())
+	templateData := struct {
+		Title          string
+		Message        string
+		ShowForm       bool
+		RememberMeDays int
+	}{
+		Title:    "Login",
+		ShowForm: true,
+	}
+	if err := renderTemplate(httptest.NewRecorder(), probe, "index.html", templateData); err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/phillip-england/totem/pkg/data"
+)
+
+// importRow is one line on an import result page.
+type importRow struct {
+	Name   string
+	Change string
+	Reason string
+}
+
+type importResult struct {
+	Title    string
+	Location data.CfaLocation
+	// RunID links the page to the run in the import history; 0 when the
+	// import wasn't recorded.
+	RunID   int
+	Applied []importRow
+	Skipped []importRow
+	Failed  []importRow
+	// Unparsed rows couldn't be read; Unmatched rows were read but matched
+	// no employee, or more than one, and can be linked by hand from Roster.
+	Unparsed  []unparsedRow
+	Unmatched []unmatchedRow
+	Roster    []data.Employee
+	// RolledBack is set when the transaction failed and nothing was kept.
+	RolledBack bool
+	Error      string
+}
+
+// unmatchedRow is an import row waiting to be linked to an employee. Value is
+// what the import would set, such as a birthday or department.
+type unmatchedRow struct {
+	Name       string
+	Value      string
+	Change     string
+	Reason     string
+	Candidates []data.Employee
+}
+
+// reportUnmatched adds a row that matched several employees, or none, to the
+// result. When it matched several they are the candidates; otherwise the
+// suggestions are.
+func reportUnmatched(result *importResult, row unmatchedRow, matches, suggestions []data.Employee) {
+	if len(matches) > 1 {
+		row.Reason = fmt.Sprintf("Matches %d employees", len(matches))
+		row.Candidates = matches
+	} else {
+		row.Reason = "No employee with this name"
+		row.Candidates = suggestions
+	}
+	result.Unmatched = append(result.Unmatched, row)
+}
+
+// rosterByName sorts employees for the manual-link picker.
+func rosterByName(employees []data.Employee) []data.Employee {
+	sorted := append([]data.Employee(nil), employees...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].LastName != sorted[j].LastName {
+			return sorted[i].LastName < sorted[j].LastName
+		}
+		return sorted[i].FirstName < sorted[j].FirstName
+	})
+	return sorted
+}
+
+func birthdayChange(emp data.Employee, birthday string) data.ImportedChange {
+	change := employeeUpdate(emp)
+	change.Birthday = birthday
+	return data.ImportedChange{
+		Name:    emp.FirstName + " " + emp.LastName,
+		Summary: "Birthday " + birthday,
+		Change:  change,
+		Fields:  []data.FieldChange{{Field: "birthday", Before: emp.Birthday, After: birthday}},
+	}
+}
+
+func departmentChange(emp data.Employee, department string) data.ImportedChange {
+	change := employeeUpdate(emp)
+	change.Department = department
+	return data.ImportedChange{
+		Name:    emp.FirstName + " " + emp.LastName,
+		Summary: "Department " + department,
+		Change:  change,
+		Fields:  []data.FieldChange{{Field: "department", Before: emp.Department, After: department}},
+	}
+}
+
+// employeeUpdate is an update that leaves emp as it is, for callers to change
+// one field of.
+func employeeUpdate(emp data.Employee) data.EmployeeChange {
+	return data.EmployeeChange{
+		Kind:         data.EmployeeUpdate,
+		EmployeeID:   emp.ID,
+		FirstName:    emp.FirstName,
+		LastName:     emp.LastName,
+		Birthday:     emp.Birthday,
+		Department:   emp.Department,
+		AnnualSalary: emp.AnnualSalary,
+	}
+}
+
+// startImportRun stores the file behind an import so it shows up in the
+// import history. With a plan the run is pending until applyImportPlan
+// applies it; a pending run nobody applies is purged. The unmatched rows are
+// kept with the run, and are the only rows that can be linked to it later.
+func (s *server) startImportRun(r *http.Request, locationID int, kind, filename, contentType string, source []byte, plan []data.ImportedChange, unmatched []unmatchedRow) (int, error) {
+	run := data.ImportRun{
+		LocationID:  locationID,
+		Kind:        kind,
+		Filename:    filename,
+		ContentType: contentType,
+		Source:      source,
+		Plan:        plan,
+	}
+	for _, row := range unmatched {
+		run.Unmatched = append(run.Unmatched, data.UnmatchedImportRow{Name: row.Name, Value: row.Value})
+	}
+	if user, ok := currentUser(r); ok {
+		run.UserID = user.ID
+		run.Username = user.Username
+	}
+	return data.CreateImportRun(s.store, run)
+}
+
+// recordImport stores the file behind an import that has no preview, with
+// planned as its plan and result's unmatched rows, and applies all of it.
+func (s *server) recordImport(r *http.Request, locationID int, kind, filename, contentType string, source []byte, planned []data.ImportedChange, result *importResult) error {
+	runID, err := s.startImportRun(r, locationID, kind, filename, contentType, source, planned, result.Unmatched)
+	if err != nil {
+		return err
+	}
+	result.RunID = runID
+	if len(planned) == 0 {
+		return nil
+	}
+	selected := make([]int, len(planned))
+	for i := range selected {
+		selected[i] = i
+	}
+	return s.applyImportPlan(r, locationID, runID, selected, result)
+}
+
+// applyImportPlan applies the selected entries of a pending run's plan in one
+// transaction and reports them. It returns an error, and reports nothing,
+// when the run can't be applied at all; see writeImportRunError.
+func (s *server) applyImportPlan(r *http.Request, locationID, runID int, selected []int, result *importResult) error {
+	result.RunID = runID
+	run, err := data.ApplyImportPlan(s.store, locationID, runID, selected, importAudit(r, locationID))
+	if isImportRunError(err) {
+		return err
+	}
+	reportImport(run.Changes, err, result)
+	return nil
+}
+
+// appendImportChanges applies changes to an applied run, such as rows
+// linked by hand, and records them on it in one transaction. Errors are as
+// for applyImportPlan.
+func (s *server) appendImportChanges(r *http.Request, locationID, runID int, changes []data.ImportedChange, result *importResult) error {
+	result.RunID = runID
+	recorded, err := data.ApplyImportChanges(s.store, locationID, runID, changes, importAudit(r, locationID))
+	if isImportRunError(err) {
+		return err
+	}
+	if err != nil {
+		recorded = changes
+	}
+	reportImport(recorded, err, result)
+	return nil
+}
+
+// isImportRunError reports whether err is about the run itself rather than
+// one of its changes.
+func isImportRunError(err error) bool {
+	for _, target := range []error{data.ErrImportRunNotFound, data.ErrImportRunApplied, data.ErrImportRunNotApplied, data.ErrImportRunRolledBack, data.ErrImportPlanIndex} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeImportRunError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, data.ErrImportRunNotFound):
+		http.Error(w, "Import not found; upload the file again", http.StatusNotFound)
+	case errors.Is(err, data.ErrImportRunApplied):
+		http.Error(w, "This import has already been applied", http.StatusConflict)
+	case errors.Is(err, data.ErrImportRunNotApplied):
+		http.Error(w, "This import hasn't been applied", http.StatusConflict)
+	case errors.Is(err, data.ErrImportRunRolledBack):
+		http.Error(w, "This import was rolled back", http.StatusConflict)
+	case errors.Is(err, data.ErrImportPlanIndex):
+		http.Error(w, "Invalid selection", http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// reportImport reports the outcome of applying changes. On success every
+// change is reported as applied. On failure nothing was kept: the change
+// that broke the batch is reported with its error and the rest as rolled
+// back.
+func reportImport(changes []data.ImportedChange, err error, result *importResult) {
+	if err == nil {
+		for _, c := range changes {
+			result.Applied = append(result.Applied, importRow{Name: c.Name, Change: c.Summary})
+		}
+		return
+	}
+
+	result.RolledBack = true
+	result.Error = err.Error()
+	failed := -1
+	var changeErr *data.EmployeeChangeError
+	if errors.As(err, &changeErr) {
+		failed = changeErr.Index
+		result.Error = changeErr.Err.Error()
+	}
+	for i, c := range changes {
+		row := importRow{Name: c.Name, Change: c.Summary, Reason: "Not applied: the import was rolled back"}
+		if i == failed {
+			row.Reason = changeErr.Err.Error()
+		}
+		result.Failed = append(result.Failed, row)
+	}
+}
+
+// importAudit describes an imported change by the fields it altered, as
+// recorded when it was applied.
+func importAudit(r *http.Request, locationID int) func(data.ImportedChange) data.AuditEntry {
+	return func(c data.ImportedChange) data.AuditEntry {
+		if c.Created {
+			return auditEntry(r, locationID, "employee", c.Change.EmployeeID, nil, map[string]any{"first_name": c.Change.FirstName, "last_name": c.Change.LastName})
+		}
+		before, after := fieldValues(c.Fields)
+		return auditEntry(r, locationID, "employee", c.Change.EmployeeID, before, after)
+	}
+}
+
+// fieldValues splits fields into before and after maps for an audit entry.
+func fieldValues(fields []data.FieldChange) (before, after map[string]any) {
+	before = map[string]any{}
+	after = map[string]any{}
+	for _, f := range fields {
+		before[f.Field] = f.Before
+		after[f.Field] = f.After
+	}
+	return before, after
+}
+
+// rollBackImport puts back the fields the run changed, in one transaction,
+// then moves the employees it created to the trash. If any of those fields
+// has been edited since the import, nothing is rolled back and the edits are
+// listed instead.
+func (s *server) rollBackImport(r *http.Request, run data.ImportRun, result *importResult) {
+	user, _ := currentUser(r)
+	undo := func(c data.ImportedChange) data.AuditEntry {
+		after, before := fieldValues(c.Fields)
+		return auditEntry(r, run.LocationID, "employee", c.Change.EmployeeID, before, after)
+	}
+	run, err := data.RollBackImportRun(s.store, run.LocationID, run.ID, user.Username, undo)
+	if err != nil {
+		result.RolledBack = true
+		result.Error = err.Error()
+		var conflictErr *data.ImportConflictError
+		if errors.As(err, &conflictErr) {
+			result.Error = "Some of these employees have been edited since the import, so nothing was rolled back. Change them back by hand or leave the import in place."
+			for _, c := range conflictErr.Conflicts {
+				row := importRow{Name: c.Name, Change: "Undo: " + c.Field, Reason: fmt.Sprintf("Now %v; the import set %v", c.Current, c.Imported)}
+				if c.Current == nil {
+					row.Reason = "Employee no longer exists"
+				}
+				result.Failed = append(result.Failed, row)
+			}
+		}
+		return
+	}
+
+	for i := len(run.Changes) - 1; i >= 0; i-- {
+		c := run.Changes[i]
+		if c.Created {
+			row := importRow{Name: c.Name, Change: "Remove employee added by the import"}
+			audit := auditEntry(r, run.LocationID, "employee", c.Change.EmployeeID, c.Change, map[string]any{"trashed": true})
+			if err := data.MoveToTrash(data.TrashEmployee, c.Change.EmployeeID, run.LocationID, c.Name, user.ID, audit); err != nil {
+				row.Reason = err.Error()
+				result.Failed = append(result.Failed, row)
+				continue
+			}
+			result.Applied = append(result.Applied, row)
+			continue
+		}
+		if len(c.Fields) > 0 {
+			result.Applied = append(result.Applied, importRow{Name: c.Name, Change: "Undo: " + c.Summary})
+		}
+	}
+	recordAudit(r, run.LocationID, "import", run.ID, nil, map[string]any{"rolled_back": true, "changes": len(run.Changes)})
+}
+
+func renderImportResult(w http.ResponseWriter, r *http.Request, result importResult) {
+	status := http.StatusOK
+	if result.RolledBack {
+		status = http.StatusUnprocessableEntity
+	}
+	w.WriteHeader(status)
+	if err := renderTemplate(w, r, "import_result.html", result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/phillip-england/totem/pkg/data"
+	"github.com/phillip-england/totem/pkg/match"
+)
+
+// rosterMatcher matches names from imports and reports to a location's
+// employees.
+type rosterMatcher struct {
+	*match.Matcher
+	byID map[int]data.Employee
+}
+
+// newRosterMatcher knows each employee by their name, their time punch name
+// and their aliases.
+func newRosterMatcher(threshold float64, employees []data.Employee, aliases map[int][]data.EmployeeAlias) rosterMatcher {
+	m := rosterMatcher{byID: make(map[int]data.Employee, len(employees))}
+	candidates := make([]match.Candidate, 0, len(employees))
+	for _, emp := range employees {
+		m.byID[emp.ID] = emp
+		c := match.Candidate{ID: emp.ID, Names: []match.Name{{First: emp.FirstName, Last: emp.LastName}}}
+		if first, last, _, ok := splitTimePunchName(emp.TimePunchName); ok {
+			c.Names = append(c.Names, match.Name{First: first, Last: last})
+		}
+		for _, a := range aliases[emp.ID] {
+			c.Names = append(c.Names, match.Name{First: a.FirstName, Last: a.LastName})
+		}
+		candidates = append(candidates, c)
+	}
+	m.Matcher = match.New(threshold, candidates)
+	return m
+}
+
+// locationMatcher builds the matcher for employees at a location with the
+// organization's threshold. If the aliases can't be loaded, names are matched
+// without them rather than failing the import.
+func locationMatcher(r *http.Request, locationID int, employees []data.Employee) rosterMatcher {
+	aliases, _ := data.GetEmployeeAliases(locationID)
+	return newRosterMatcher(matchThreshold(currentOrganizationID(r)), employees, aliases)
+}
+
+func matchThreshold(orgID int) float64 {
+	return data.GetFloatSetting(orgID, data.SettingNameMatchThreshold, match.DefaultThreshold)
+}
+
+// match returns the employees names match: one for a match, several when it
+// is ambiguous, none when nothing clears the threshold.
+func (m rosterMatcher) match(names ...match.Name) []data.Employee {
+	return m.employees(m.Match(names...))
+}
+
+// suggest returns up to limit employees close enough to names to offer when
+// nothing matched.
+func (m rosterMatcher) suggest(limit int, names ...match.Name) []data.Employee {
+	return m.employees(m.Suggest(limit, names...))
+}
+
+func (m rosterMatcher) employees(results []match.Result) []data.Employee {
+	var employees []data.Employee
+	for _, r := range results {
+		employees = append(employees, m.byID[r.ID])
+	}
+	return employees
+}
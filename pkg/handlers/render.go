@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/phillip-england/vii"
+)
+
+// renderTemplate executes a template with page-wide values such as the CSRF
+// token merged into the handler's template data. Struct fields are copied into
+// a map, so templates keep addressing them as {{ .Field }}.
+func renderTemplate(w http.ResponseWriter, r *http.Request, name string, templateData any) error {
+	return vii.ExecuteTemplate(w, r, name, withPageContext(r, templateData))
+}
+
+func withPageContext(r *http.Request, templateData any) map[string]any {
+	page := map[string]any{}
+	if templateData != nil {
+		val := reflect.ValueOf(templateData)
+		for val.Kind() == reflect.Pointer && !val.IsNil() {
+			val = val.Elem()
+		}
+		switch val.Kind() {
+		case reflect.Struct:
+			typ := val.Type()
+			for i := 0; i < typ.NumField(); i++ {
+				field := typ.Field(i)
+				if field.IsExported() {
+					page[field.Name] = val.Field(i).Interface()
+				}
+			}
+		case reflect.Map:
+			if val.Type().Key().Kind() == reflect.String {
+				iter := val.MapRange()
+				for iter.Next() {
+					page[iter.Key().String()] = iter.Value().Interface()
+				}
+			}
+		}
+	}
+	page["CSRFToken"] = csrfToken(r)
+	page["CSRFField"] = csrfFormField
+	return page
+}
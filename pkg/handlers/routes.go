@@ -2,21 +2,27 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/extrame/xls"
+	"github.com/phillip-england/totem/pkg/calendar"
 	"github.com/phillip-england/totem/pkg/data"
+	"github.com/phillip-england/totem/pkg/match"
+	"github.com/phillip-england/totem/pkg/totp"
 	"github.com/phillip-england/vii"
 	"github.com/xuri/excelize/v2"
 )
@@ -73,14 +79,25 @@ func parseBioEmployeesFromSpreadsheet(reader io.Reader, filename string) ([]bioE
 }
 
 type birthdateRow struct {
+	FirstName     string
+	LastName      string
 	TimePunchName string
 	Birthday      string
 }
 
-func parseBirthdatesFromSpreadsheet(reader io.Reader, filename string) ([]birthdateRow, error) {
+// unparsedRow is a row an import couldn't read. It is reported back to the
+// user rather than dropped.
+type unparsedRow struct {
+	Line   int
+	Name   string
+	Value  string
+	Reason string
+}
+
+func parseBirthdatesFromSpreadsheet(reader io.Reader, filename string) ([]birthdateRow, []unparsedRow, error) {
 	rows, err := readRowsFromSpreadsheet(reader, filename)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	headerIndex := map[string]int{}
@@ -90,7 +107,7 @@ func parseBirthdatesFromSpreadsheet(reader io.Reader, filename string) ([]birthd
 
 	nameIdx, ok := headerIndex["employee name"]
 	if !ok {
-		return nil, fmt.Errorf("missing required column: employee name")
+		return nil, nil, fmt.Errorf("missing required column: employee name")
 	}
 
 	birthIdx := -1
@@ -104,31 +121,45 @@ func parseBirthdatesFromSpreadsheet(reader io.Reader, filename string) ([]birthd
 		birthIdx = idx
 	}
 	if birthIdx == -1 {
-		return nil, fmt.Errorf("missing required column: birth date")
+		return nil, nil, fmt.Errorf("missing required column: birth date")
 	}
 
 	var rowsOut []birthdateRow
-	for _, row := range rows[1:] {
+	var unparsed []unparsedRow
+	for i, row := range rows[1:] {
 		name := cellValue(row, nameIdx)
-		_, _, timePunch, ok := splitTimePunchName(name)
+		birthday := cellValue(row, birthIdx)
+		if name == "" && birthday == "" {
+			continue
+		}
+		// Line numbers count the header, matching what the spreadsheet shows.
+		bad := unparsedRow{Line: i + 2, Name: name, Value: birthday}
+		first, last, timePunch, ok := splitTimePunchName(name)
 		if !ok {
+			bad.Reason = "Name isn't \"Last, First\" or \"First Last\""
+			unparsed = append(unparsed, bad)
 			continue
 		}
-		birthday := cellValue(row, birthIdx)
 		if birthday == "" {
+			bad.Reason = "No birth date"
+			unparsed = append(unparsed, bad)
 			continue
 		}
 		normalizedBirthday, ok := normalizeBirthday(birthday)
 		if !ok {
+			bad.Reason = "Birth date isn't in a recognized format"
+			unparsed = append(unparsed, bad)
 			continue
 		}
 		rowsOut = append(rowsOut, birthdateRow{
+			FirstName:     first,
+			LastName:      last,
 			TimePunchName: timePunch,
 			Birthday:      normalizedBirthday,
 		})
 	}
 
-	return rowsOut, nil
+	return rowsOut, unparsed, nil
 }
 
 type hsJobRow struct {
@@ -138,15 +169,15 @@ type hsJobRow struct {
 	Department    string
 }
 
-func parseHotSchedulesDepartmentsFromHTML(value string) ([]hsJobRow, error) {
+func parseHotSchedulesDepartmentsFromHTML(value string) ([]hsJobRow, []unparsedRow, error) {
 	trimmed := strings.TrimSpace(value)
 	if trimmed == "" {
-		return nil, fmt.Errorf("hot schedules html is required")
+		return nil, nil, fmt.Errorf("hot schedules html is required")
 	}
 
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(trimmed))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	rows := doc.Find("#stafftable tbody tr")
@@ -157,11 +188,12 @@ func parseHotSchedulesDepartmentsFromHTML(value string) ([]hsJobRow, error) {
 		rows = doc.Find("table.data-table tbody tr")
 	}
 	if rows.Length() == 0 {
-		return nil, fmt.Errorf("could not find employee table rows")
+		return nil, nil, fmt.Errorf("could not find employee table rows")
 	}
 
 	var rowsOut []hsJobRow
-	rows.Each(func(_ int, row *goquery.Selection) {
+	var unparsed []unparsedRow
+	rows.Each(func(i int, row *goquery.Selection) {
 		cells := row.Find("td")
 		if cells.Length() < 7 {
 			return
@@ -172,8 +204,13 @@ func parseHotSchedulesDepartmentsFromHTML(value string) ([]hsJobRow, error) {
 			name = strings.TrimSpace(nameCell.Text())
 		}
 		name = strings.Join(strings.Fields(name), " ")
+		bad := unparsedRow{Line: i + 1, Name: name}
 		first, last, ok := splitFirstLastFromDisplayName(name)
 		if !ok {
+			if name != "" {
+				bad.Reason = "Name isn't \"Last, First\" or \"First Last\""
+				unparsed = append(unparsed, bad)
+			}
 			return
 		}
 
@@ -186,11 +223,16 @@ func parseHotSchedulesDepartmentsFromHTML(value string) ([]hsJobRow, error) {
 		jobCell := cells.Eq(6)
 		jobs := extractHotSchedulesJobs(jobCell)
 		if len(jobs) == 0 {
+			bad.Reason = "No jobs listed"
+			unparsed = append(unparsed, bad)
 			return
 		}
 
 		department, ok := mapDepartmentFromJobs(strings.Join(jobs, " | "))
 		if !ok {
+			bad.Value = strings.Join(jobs, ", ")
+			bad.Reason = "Jobs don't map to a department"
+			unparsed = append(unparsed, bad)
 			return
 		}
 		rowsOut = append(rowsOut, hsJobRow{
@@ -201,11 +243,11 @@ func parseHotSchedulesDepartmentsFromHTML(value string) ([]hsJobRow, error) {
 		})
 	})
 
-	if len(rowsOut) == 0 {
-		return nil, fmt.Errorf("no mappable employees found in html")
+	if len(rowsOut) == 0 && len(unparsed) == 0 {
+		return nil, nil, fmt.Errorf("no mappable employees found in html")
 	}
 
-	return rowsOut, nil
+	return rowsOut, unparsed, nil
 }
 
 func normalizeHeader(header string) string {
@@ -260,6 +302,187 @@ func canonicalTimePunchNameFromValue(value string) string {
 	return strings.ToLower(trimmed)
 }
 
+// employeeTimePunchKey is the key employees are matched on across imports:
+// their stored time punch name, or one built from their name.
+func employeeTimePunchKey(emp data.Employee) string {
+	if emp.TimePunchName != "" {
+		return canonicalTimePunchNameFromValue(emp.TimePunchName)
+	}
+	return canonicalTimePunchName(emp.FirstName, emp.LastName)
+}
+
+// bioImportPlan is the roster diff a Bio export would apply, shown for
+// confirmation before anything changes.
+type bioImportPlan struct {
+	Creates    []bioEmployeeRow
+	Renames    []bioRename
+	Reinstates []data.Employee
+	Terminates []data.Employee
+	// ActiveCount is how many employees are active before the import.
+	ActiveCount int
+}
+
+type bioRename struct {
+	Employee  data.Employee
+	FirstName string
+	LastName  string
+}
+
+func (p bioImportPlan) Empty() bool {
+	return len(p.Creates)+len(p.Renames)+len(p.Reinstates)+len(p.Terminates) == 0
+}
+
+// changes is the plan as the import run stores it: reinstatements, renames,
+// hires, then terminations as of terminationDate. The preview's checkboxes
+// post indexes into it; reinstatements are numbered from 0 and the other
+// sections by the *Index methods.
+func (p bioImportPlan) changes(terminationDate string) []data.ImportedChange {
+	var changes []data.ImportedChange
+	for _, emp := range p.Reinstates {
+		changes = append(changes, data.ImportedChange{
+			Name:    emp.FirstName + " " + emp.LastName,
+			Summary: "Reinstate",
+			Change:  data.EmployeeChange{Kind: data.EmployeeReinstate, EmployeeID: emp.ID},
+		})
+	}
+	for _, rename := range p.Renames {
+		emp := rename.Employee
+		change := employeeUpdate(emp)
+		change.FirstName = rename.FirstName
+		change.LastName = rename.LastName
+		changes = append(changes, data.ImportedChange{
+			Name:    emp.FirstName + " " + emp.LastName,
+			Summary: "Rename to " + rename.FirstName + " " + rename.LastName,
+			Change:  change,
+			Fields: []data.FieldChange{
+				{Field: "first_name", Before: emp.FirstName, After: rename.FirstName},
+				{Field: "last_name", Before: emp.LastName, After: rename.LastName},
+			},
+		})
+	}
+	for _, row := range p.Creates {
+		changes = append(changes, data.ImportedChange{
+			Name:    row.FirstName + " " + row.LastName,
+			Summary: "Add employee",
+			Change:  data.EmployeeChange{Kind: data.EmployeeCreate, FirstName: row.FirstName, LastName: row.LastName},
+		})
+	}
+	for _, emp := range p.Terminates {
+		changes = append(changes, data.ImportedChange{
+			Name:    emp.FirstName + " " + emp.LastName,
+			Summary: "Terminate as of " + terminationDate,
+			Change:  data.EmployeeChange{Kind: data.EmployeeTerminate, EmployeeID: emp.ID, TerminationDate: terminationDate},
+		})
+	}
+	return changes
+}
+
+func (p bioImportPlan) RenameIndex(i int) int {
+	return len(p.Reinstates) + i
+}
+
+func (p bioImportPlan) CreateIndex(i int) int {
+	return len(p.Reinstates) + len(p.Renames) + i
+}
+
+func (p bioImportPlan) TerminateIndex(i int) int {
+	return len(p.Reinstates) + len(p.Renames) + len(p.Creates) + i
+}
+
+// planBioImport works out how existing would change to match the active
+// employees in a Bio export: new names are created, returning employees are
+// reinstated, changed spellings are renamed and anyone missing is terminated.
+// Exact time punch names are paired first and the rest go through matcher, so
+// a nickname or typo becomes a rename instead of a hire and a termination.
+func planBioImport(bioEmployees []bioEmployeeRow, existing []data.Employee, matcher rosterMatcher) bioImportPlan {
+	var plan bioImportPlan
+	existingByTimePunch := make(map[string]data.Employee, len(existing))
+	for _, emp := range existing {
+		existingByTimePunch[employeeTimePunchKey(emp)] = emp
+		if !emp.Terminated {
+			plan.ActiveCount++
+		}
+	}
+
+	activeByTimePunch := make(map[string]bioEmployeeRow)
+	for _, emp := range bioEmployees {
+		if emp.Terminated {
+			continue
+		}
+		activeByTimePunch[emp.TimePunchName] = emp
+	}
+
+	paired := map[string]data.Employee{}
+	claimed := map[int]bool{}
+	var unpaired []bioEmployeeRow
+	for key, emp := range activeByTimePunch {
+		if existing, ok := existingByTimePunch[key]; ok {
+			paired[key] = existing
+			claimed[existing.ID] = true
+			continue
+		}
+		unpaired = append(unpaired, emp)
+	}
+	sort.Slice(unpaired, func(i, j int) bool { return unpaired[i].TimePunchName < unpaired[j].TimePunchName })
+	for _, emp := range unpaired {
+		var candidates []data.Employee
+		for _, c := range matcher.match(match.Name{First: emp.FirstName, Last: emp.LastName}) {
+			if !claimed[c.ID] {
+				candidates = append(candidates, c)
+			}
+		}
+		if len(candidates) != 1 {
+			plan.Creates = append(plan.Creates, emp)
+			continue
+		}
+		paired[emp.TimePunchName] = candidates[0]
+		claimed[candidates[0].ID] = true
+	}
+
+	for key, existing := range paired {
+		emp := activeByTimePunch[key]
+		if existing.Terminated {
+			plan.Reinstates = append(plan.Reinstates, existing)
+		}
+		if existing.FirstName != emp.FirstName || existing.LastName != emp.LastName {
+			plan.Renames = append(plan.Renames, bioRename{Employee: existing, FirstName: emp.FirstName, LastName: emp.LastName})
+		}
+	}
+	for _, emp := range existing {
+		if claimed[emp.ID] || emp.Terminated {
+			continue
+		}
+		plan.Terminates = append(plan.Terminates, emp)
+	}
+
+	sort.Slice(plan.Creates, func(i, j int) bool { return plan.Creates[i].TimePunchName < plan.Creates[j].TimePunchName })
+	sort.Slice(plan.Renames, func(i, j int) bool {
+		return employeeTimePunchKey(plan.Renames[i].Employee) < employeeTimePunchKey(plan.Renames[j].Employee)
+	})
+	byName := func(employees []data.Employee) {
+		sort.Slice(employees, func(i, j int) bool { return employeeTimePunchKey(employees[i]) < employeeTimePunchKey(employees[j]) })
+	}
+	byName(plan.Reinstates)
+	byName(plan.Terminates)
+	return plan
+}
+
+// validateBioChange returns why a change from a Bio import plan can't be
+// applied, or "" when it can.
+func validateBioChange(c data.EmployeeChange) string {
+	switch c.Kind {
+	case data.EmployeeCreate, data.EmployeeUpdate:
+		if c.FirstName == "" || c.LastName == "" {
+			return "First and last name are required"
+		}
+	case data.EmployeeTerminate:
+		if _, err := time.Parse("2006-01-02", c.TerminationDate); err != nil {
+			return "A valid termination date is required"
+		}
+	}
+	return ""
+}
+
 func isTerminated(status, terminationDate string) bool {
 	status = strings.ToLower(strings.TrimSpace(status))
 	terminationDate = strings.TrimSpace(terminationDate)
@@ -325,68 +548,6 @@ func splitFirstLastFromDisplayName(name string) (string, string, bool) {
 	return first, last, true
 }
 
-func normalizeNameKey(first, last string) string {
-	first = normalizeFirstName(first)
-	last = normalizeLastName(last)
-	if first == "" || last == "" {
-		return ""
-	}
-	return last + "|" + first
-}
-
-func normalizeFirstName(value string) string {
-	value = stripParenthetical(value)
-	value = normalizeNameText(value)
-	fields := strings.Fields(value)
-	if len(fields) == 0 {
-		return ""
-	}
-	return fields[0]
-}
-
-func normalizeLastName(value string) string {
-	value = normalizeNameText(value)
-	return strings.Join(strings.Fields(value), " ")
-}
-
-func normalizeNameText(value string) string {
-	value = strings.ToLower(strings.TrimSpace(value))
-	var b strings.Builder
-	lastWasSpace := false
-	for _, r := range value {
-		if unicode.IsLetter(r) {
-			b.WriteRune(r)
-			lastWasSpace = false
-			continue
-		}
-		if !lastWasSpace {
-			b.WriteByte(' ')
-			lastWasSpace = true
-		}
-	}
-	return strings.TrimSpace(b.String())
-}
-
-func stripParenthetical(value string) string {
-	var b strings.Builder
-	depth := 0
-	for _, r := range value {
-		switch r {
-		case '(':
-			depth++
-		case ')':
-			if depth > 0 {
-				depth--
-			}
-		default:
-			if depth == 0 {
-				b.WriteRune(r)
-			}
-		}
-	}
-	return b.String()
-}
-
 func extractHotSchedulesJobs(cell *goquery.Selection) []string {
 	var jobs []string
 	tooltip := ""
@@ -595,25 +756,23 @@ func summarizeTimePunchReport(text string, employees []data.Employee) (timePunch
 	if err != nil {
 		return timePunchSummary{}, err
 	}
-	return summarizeTimePunchReportFromParsed(employeeTotals, reportTotals, startDate, endDate, employees, nil)
+	matcher := newRosterMatcher(match.DefaultThreshold, employees, nil)
+	return summarizeTimePunchReportFromParsed(employeeTotals, reportTotals, startDate, endDate, employees, matcher, nil)
 }
 
-func summarizeTimePunchReportFromParsed(employeeTotals map[string]timePunchEmployeeTotals, reportTotals timePunchReportTotals, startDate, endDate time.Time, employees []data.Employee, payrollEvents []data.PayrollEvent) (timePunchSummary, error) {
+func summarizeTimePunchReportFromParsed(employeeTotals map[string]timePunchEmployeeTotals, reportTotals timePunchReportTotals, startDate, endDate time.Time, employees []data.Employee, matcher rosterMatcher, payrollEvents []data.PayrollEvent) (timePunchSummary, error) {
 
 	dayCount := 0
 	if !startDate.IsZero() && !endDate.IsZero() && !endDate.Before(startDate) {
 		dayCount = int(endDate.Sub(startDate).Hours()/24) + 1
 	}
 
-	employeeByKey := make(map[string]data.Employee, len(employees))
-	salaryEmployeesByKey := make(map[string]data.Employee)
+	// Salaried employees missing from the report are still paid, so they are
+	// added after the punches.
+	salaryEmployees := make(map[int]data.Employee)
 	for _, emp := range employees {
-		key := normalizeNameKey(emp.FirstName, emp.LastName)
-		if key != "" {
-			employeeByKey[key] = emp
-			if emp.AnnualSalary > 0 {
-				salaryEmployeesByKey[key] = emp
-			}
+		if emp.AnnualSalary > 0 {
+			salaryEmployees[emp.ID] = emp
 		}
 	}
 
@@ -629,12 +788,12 @@ func summarizeTimePunchReportFromParsed(employeeTotals map[string]timePunchEmplo
 		salaryEmployee := false
 		annualSalary := 0.0
 		if ok {
-			key := normalizeNameKey(first, last)
-			if emp, found := employeeByKey[key]; found {
+			if matches := matcher.match(match.Name{First: first, Last: last}); len(matches) == 1 {
+				emp := matches[0]
 				department = emp.Department
 				salaryEmployee = emp.AnnualSalary > 0
 				annualSalary = emp.AnnualSalary
-				delete(salaryEmployeesByKey, key)
+				delete(salaryEmployees, emp.ID)
 			} else {
 				unmatched++
 			}
@@ -667,7 +826,7 @@ func summarizeTimePunchReportFromParsed(employeeTotals map[string]timePunchEmplo
 	}
 
 	if dayCount > 0 {
-		for _, emp := range salaryEmployeesByKey {
+		for _, emp := range salaryEmployees {
 			department := emp.Department
 			prorated := (emp.AnnualSalary / 365.0) * float64(dayCount)
 			salaryAmount += prorated
@@ -835,18 +994,30 @@ func mapDepartmentFromJobs(value string) (string, bool) {
 	return "", false
 }
 
-func RegisterRoutes(app *vii.App) {
+// server carries the dependencies route handlers share. RegisterRoutes
+// builds one from its arguments, so two apps can run against different
+// stores. The store is read through data.WithoutTrash.
+type server struct {
+	store data.Store
+}
+
+func RegisterRoutes(app *vii.App, backend data.Store) {
+	s := &server{store: data.WithoutTrash(backend)}
+	s.registerHealthRoutes(app)
+
 	app.At("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
 		data := struct {
-			Title    string
-			Message  string
-			ShowForm bool
+			Title          string
+			Message        string
+			ShowForm       bool
+			RememberMeDays int
 		}{
-			Title:    "Login",
-			Message:  "Please Login",
-			ShowForm: true,
+			Title:          "Login",
+			Message:        "Please Login",
+			ShowForm:       true,
+			RememberMeDays: rememberMeDays(),
 		}
-		err := vii.ExecuteTemplate(w, r, "index.html", data)
+		err := renderTemplate(w, r, "index.html", data)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -855,101 +1026,156 @@ func RegisterRoutes(app *vii.App) {
 	app.At("POST /{$}", func(w http.ResponseWriter, r *http.Request) {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
+		ip := clientIP(r)
+
+		if blockedUntil, err := data.LoginBlockedUntil(username, ip); err == nil && !blockedUntil.IsZero() {
+			renderLoginPage(w, r, http.StatusTooManyRequests, "Too many failed attempts. Try again in "+formatWait(time.Until(blockedUntil))+".")
+			return
+		}
 
 		user, err := data.AuthenticateUser(username, password)
+		if err == nil && data.IsUserDisabled(user.ID) {
+			_ = data.RecordLoginAttempt(username, ip, false)
+			renderLoginPage(w, r, http.StatusForbidden, "This account has been disabled.")
+			return
+		}
 		if err == nil {
-			payload := user.PasswordHash
-			if isAdminUser(user) {
-				payload = adminSessionPayload()
-				if payload == "" {
-					payload = user.PasswordHash
+			rememberMe := rememberMeEnabled && r.FormValue("remember_me") == "on"
+			if needsSecondFactor(user) {
+				token, err := data.CreateLoginChallenge(user.ID, rememberMe)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
 				}
+				setLoginChallengeCookie(w, token)
+				http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+				return
 			}
-			expiresAt := time.Now().Add(24 * time.Hour)
-			sessionKey, err := data.CreateSession(user.ID, payload, expiresAt)
-			if err != nil {
+			_ = data.RecordLoginAttempt(username, ip, true)
+			if err := startSession(w, r, user, rememberMe); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			setSessionCookie(w, sessionKey, expiresAt)
 			http.Redirect(w, r, "/admin", http.StatusSeeOther)
 			return
 		}
+		_ = data.RecordLoginAttempt(username, ip, false)
 
-		data := struct {
-			Title    string
-			Message  string
-			ShowForm bool
-		}{
-			Title:    "Login Status",
-			Message:  "Login Failed. Try again.",
-			ShowForm: true,
+		renderLoginPage(w, r, http.StatusOK, "Login Failed. Try again.")
+	})
+
+	// Second login step for users with two-factor authentication. Users who
+	// are required to use 2FA but haven't enrolled are enrolled here instead.
+	app.At("GET /login/2fa", func(w http.ResponseWriter, r *http.Request) {
+		_, user, _, ok := loginChallengeFromRequest(r)
+		if !ok {
+			clearLoginChallengeCookie(w)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		renderLoginChallenge(w, r, user, "")
+	})
+
+	app.At("POST /login/2fa", func(w http.ResponseWriter, r *http.Request) {
+		challenge, user, token, ok := loginChallengeFromRequest(r)
+		if !ok {
+			clearLoginChallengeCookie(w)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		code := r.FormValue("code")
+		tf, err := data.GetTwoFactor(user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		err = vii.ExecuteTemplate(w, r, "index.html", data)
+		var recoveryCodes []string
+		if tf.Enabled {
+			err = data.VerifyTwoFactorCode(user.ID, code)
+		} else {
+			recoveryCodes, err = data.ConfirmTwoFactorEnrollment(user.ID, code)
+		}
 		if err != nil {
+			_ = data.RecordLoginChallengeFailure(token)
+			_ = data.RecordLoginAttempt(user.Username, clientIP(r), false)
+			renderLoginChallenge(w, r, user, "That code didn't work. Try again.")
+			return
+		}
+
+		_ = data.DeleteLoginChallenge(token)
+		clearLoginChallengeCookie(w)
+		_ = data.RecordLoginAttempt(user.Username, clientIP(r), true)
+		if err := startSession(w, r, user, challenge.RememberMe); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(recoveryCodes) > 0 {
+			templateData := struct {
+				RecoveryCodes []string
+			}{
+				RecoveryCodes: recoveryCodes,
+			}
+			if err := renderTemplate(w, r, "recovery_codes.html", templateData); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
 		}
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
 	})
 
 	app.At("GET /logout", func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie(sessionCookieName)
-		if err == nil && cookie != nil && cookie.Value != "" {
-			_ = data.DeleteSessionByKey(cookie.Value)
+		if session, ok := currentSession(r); ok {
+			_ = data.RevokeSession(session.Key)
 		}
 		clearSessionCookie(w)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	})
 
 	// Admin Dashboard - List Locations
-	app.At("GET /admin", func(w http.ResponseWriter, r *http.Request) {
-		locations, err := data.GetAllLocations()
+	app.At("GET /admin", requirePermission(data.PermViewSales, func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
+		locations, err := data.GetLocationsForUser(s.store, user, currentOrganizationID(r))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		templateData := struct {
-			Locations []data.CfaLocation
-		}{
-			Locations: locations,
-		}
-
-		err = vii.ExecuteTemplate(w, r, "admin.html", templateData)
+		organization, err := data.GetOrganizationByID(currentOrganizationID(r))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-	})
-
-	app.At("GET /admin/users", func(w http.ResponseWriter, r *http.Request) {
-		user, ok := currentUser(r)
-		if !ok || !isAdminUser(user) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		users, err := data.GetUsers()
-		if err != nil {
-			users = []data.User{}
+		var organizations []data.Organization
+		superAdmin := data.IsSuperAdmin(user.ID)
+		if superAdmin {
+			organizations, _ = data.GetOrganizations()
 		}
+
 		templateData := struct {
-			User    data.User
-			Users   []data.User
-			Message string
+			Locations     []data.CfaLocation
+			Organization  data.Organization
+			Organizations []data.Organization
+			IsSuperAdmin  bool
 		}{
-			User:  user,
-			Users: users,
+			Locations:     locations,
+			Organization:  organization,
+			Organizations: organizations,
+			IsSuperAdmin:  superAdmin,
 		}
-		if err := vii.ExecuteTemplate(w, r, "users.html", templateData); err != nil {
+
+		err = renderTemplate(w, r, "admin.html", templateData)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
-	app.At("POST /admin/users/update", func(w http.ResponseWriter, r *http.Request) {
-		user, ok := currentUser(r)
-		if !ok || !isAdminUser(user) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+	app.At("GET /admin/users", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		s.renderUsersPage(w, r, "")
+	}))
+
+	app.At("POST /admin/users/update", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 		if password == "" {
@@ -960,169 +1186,891 @@ func RegisterRoutes(app *vii.App) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		_ = data.DeleteSessionsByUserID(user.ID)
-		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
-	})
-
-	app.At("POST /admin/users/create", func(w http.ResponseWriter, r *http.Request) {
-		user, ok := currentUser(r)
-		if !ok || !isAdminUser(user) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		recordAudit(r, 0, "user", user.ID,
+			map[string]any{"username": user.Username},
+			map[string]any{"username": username, "password_changed": true})
+		if r.FormValue("logout_everywhere") == "on" {
+			if err := data.RevokeUserSessions(user.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			clearSessionCookie(w)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/users/create", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 		role := r.FormValue("role")
 		if role == "" {
-			role = "admin"
+			role = data.RoleOwner
+		}
+		if !data.IsValidRole(role) {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
 		}
-		if _, err := data.CreateUser(username, password, role); err != nil {
+		audit := auditEntry(r, 0, "user", nil, nil, map[string]any{"username": username, "role": role})
+		if _, err := data.CreateUserInOrganization(currentOrganizationID(r), username, password, role, audit); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
-	})
+	}))
 
-	// View Location Details
-	app.At("GET /admin/locations/{id}", func(w http.ResponseWriter, r *http.Request) {
-		idStr := r.PathValue("id")
-		id, err := strconv.Atoi(idStr)
+	app.At("POST /admin/users/{userId}/locations", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.PathValue("userId"))
 		if err != nil {
-			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		locationIDs := formLocationIDs(r)
+		before, _ := data.GetLocationIDsForUser(userID)
+		audit := auditEntry(r, 0, "user", userID, map[string]any{"location_ids": before}, map[string]any{"location_ids": locationIDs})
+		if err := data.SetUserLocations(userID, locationIDs, audit); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		loc, err := data.GetLocationByID(id)
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/users/tokens", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		locationIDs := formLocationIDs(r)
+		allLocations := r.FormValue("all_locations") == "on"
+		secret, err := data.CreateAPIToken(currentOrganizationID(r), r.FormValue("name"), user.ID, allLocations, locationIDs)
 		if err != nil {
-			http.Error(w, "Location not found", http.StatusNotFound)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		now := time.Now()
-		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
-		monthEnd := now
-		perfRecords, err := data.GetPerformanceReport(id, monthStart.Format("2006-01-02"), monthEnd.Format("2006-01-02"))
+		recordAudit(r, 0, "api_token", nil, nil, map[string]any{
+			"name":          r.FormValue("name"),
+			"all_locations": allLocations,
+			"location_ids":  locationIDs,
+		})
+		s.renderUsersPage(w, r, secret)
+	}))
+
+	app.At("POST /admin/users/tokens/{tokenId}/revoke", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		tokenID, err := strconv.Atoi(r.PathValue("tokenId"))
 		if err != nil {
-			perfRecords = []data.DailyPerformanceRecord{}
+			http.Error(w, "Invalid Token ID", http.StatusBadRequest)
+			return
 		}
-		perfSummary := data.CalculateSummary(perfRecords)
-		dayCount := now.Day()
-		avgSales := 0.0
-		avgHours := 0.0
-		if dayCount > 0 {
-			avgSales = perfSummary.Sales / float64(dayCount)
-			avgHours = perfSummary.Hours / float64(dayCount)
+		if err := data.RevokeAPIToken(currentOrganizationID(r), tokenID); err != nil {
+			if errors.Is(err, data.ErrAPITokenNotFound) {
+				http.Error(w, "Token not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		productivity := 0.0
-		if perfSummary.Hours > 0 {
-			productivity = perfSummary.Sales / perfSummary.Hours
+		recordAudit(r, 0, "api_token", tokenID, map[string]any{"revoked": false}, map[string]any{"revoked": true})
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	app.At("GET /admin/users/sessions", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		current, _ := currentSession(r)
+		users, err := data.GetUsersInOrganization(currentOrganizationID(r))
+		if err != nil {
+			users = []data.User{}
+		}
+		usernames := make(map[int]string, len(users))
+		for _, u := range users {
+			usernames[u.ID] = u.Username
+		}
+		_ = data.PurgeExpiredSessionInfos()
+		infos, err := data.GetActiveSessionInfos()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		type weekSummary struct {
-			Label        string
-			TotalSales   float64
-			TotalHours   float64
-			Productivity float64
+		type sessionRow struct {
+			data.SessionInfo
+			Username string
+			Current  bool
 		}
-		weekTotals := map[time.Time]*weekSummary{}
-		for _, rec := range perfRecords {
-			dateVal, err := time.Parse("2006-01-02", rec.Date)
-			if err != nil {
+		var sessions []sessionRow
+		for _, info := range infos {
+			// Sessions removed outside this page (logout, password change)
+			// leave their info row behind until it is swept here.
+			if _, err := data.GetSessionByKey(info.SessionKey); err != nil {
+				_ = data.DeleteSessionInfoByKey(info.SessionKey)
 				continue
 			}
-			offset := (int(dateVal.Weekday()) + 6) % 7
-			weekStart := time.Date(dateVal.Year(), dateVal.Month(), dateVal.Day()-offset, 0, 0, 0, 0, dateVal.Location())
-			if weekStart.Before(monthStart) {
-				weekStart = monthStart
-			}
-			entry, ok := weekTotals[weekStart]
-			if !ok {
-				weekEnd := weekStart.AddDate(0, 0, 6)
-				if weekEnd.After(monthEnd) {
-					weekEnd = monthEnd
-				}
-				entry = &weekSummary{
-					Label: weekStart.Format("Jan 2") + "–" + weekEnd.Format("Jan 2"),
-				}
-				weekTotals[weekStart] = entry
+			if _, ok := usernames[info.UserID]; !ok {
+				continue
 			}
-			entry.TotalSales += rec.TotalSales
-			entry.TotalHours += rec.TotalHours
+			sessions = append(sessions, sessionRow{
+				SessionInfo: info,
+				Username:    usernames[info.UserID],
+				Current:     info.SessionKey == current.Key,
+			})
 		}
-		var weeks []weekSummary
-		for _, entry := range weekTotals {
-			if entry.TotalHours > 0 {
-				entry.Productivity = entry.TotalSales / entry.TotalHours
+
+		// The signing key is shared by every organization.
+		var signingKeys []data.SessionSigningKey
+		superAdmin := isSuperAdmin(r)
+		if superAdmin {
+			signingKeys, err = data.GetSessionSigningKeys()
+			if err != nil {
+				signingKeys = []data.SessionSigningKey{}
 			}
-			weeks = append(weeks, *entry)
 		}
-		sort.Slice(weeks, func(i, j int) bool {
-			return weeks[i].Label < weeks[j].Label
-		})
 
 		templateData := struct {
-			Location      data.CfaLocation
-			MonthStart    string
-			MonthEnd      string
-			MonthSales    float64
-			AvgDailySales float64
-			AvgDailyHours float64
-			Productivity  float64
-			WeekSummaries []weekSummary
+			Sessions     []sessionRow
+			Users        []data.User
+			SigningKeys  []data.SessionSigningKey
+			GracePeriod  time.Duration
+			Now          time.Time
+			IsSuperAdmin bool
 		}{
-			Location:      loc,
-			MonthStart:    monthStart.Format("2006-01-02"),
-			MonthEnd:      monthEnd.Format("2006-01-02"),
-			MonthSales:    perfSummary.Sales,
-			AvgDailySales: avgSales,
-			AvgDailyHours: avgHours,
-			Productivity:  productivity,
-			WeekSummaries: weeks,
+			Sessions:     sessions,
+			Users:        users,
+			SigningKeys:  signingKeys,
+			GracePeriod:  data.SessionKeyGracePeriod,
+			Now:          time.Now(),
+			IsSuperAdmin: superAdmin,
+		}
+		if err := renderTemplate(w, r, "sessions.html", templateData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+
+	app.At("POST /admin/users/sessions/{sessionId}/revoke", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		sessionID, err := strconv.Atoi(r.PathValue("sessionId"))
+		if err != nil {
+			http.Error(w, "Invalid Session ID", http.StatusBadRequest)
+			return
 		}
-		err = vii.ExecuteTemplate(w, r, "location_details.html", templateData)
+		info, err := data.GetSessionInfoByID(sessionID)
 		if err != nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if orgID, err := data.OrganizationIDForUser(info.UserID); err != nil || orgID != currentOrganizationID(r) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if err := data.RevokeSession(info.SessionKey); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		recordAudit(r, 0, "session", info.ID,
+			map[string]any{"user_id": info.UserID, "ip": info.IP, "created_at": info.CreatedAt}, nil)
+		http.Redirect(w, r, "/admin/users/sessions", http.StatusSeeOther)
+	}))
+
+	// Self-service two-factor setup, available to every signed-in user.
+	app.At("GET /admin/users/2fa", func(w http.ResponseWriter, r *http.Request) {
+		renderTwoFactorSetup(w, r, nil, "")
 	})
 
-	// Create Location
-	app.At("POST /admin/locations", func(w http.ResponseWriter, r *http.Request) {
-		name := r.FormValue("name")
-		number := r.FormValue("number")
-		if name != "" && number != "" {
-			err := data.CreateLocation(name, number)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	app.At("POST /admin/users/2fa/setup", func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
+		if _, err := data.BeginTwoFactorEnrollment(user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		recordAudit(r, 0, "two_factor", user.ID, nil, map[string]any{"status": "pending"})
+		http.Redirect(w, r, "/admin/users/2fa", http.StatusSeeOther)
 	})
 
-	// Delete Location
-	app.At("POST /admin/locations/{id}/delete", func(w http.ResponseWriter, r *http.Request) {
-		idStr := r.PathValue("id")
-		id, err := strconv.Atoi(idStr)
+	app.At("POST /admin/users/2fa/confirm", func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
+		codes, err := data.ConfirmTwoFactorEnrollment(user.ID, r.FormValue("code"))
 		if err != nil {
-			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			renderTwoFactorSetup(w, r, nil, err.Error())
+			return
+		}
+		recordAudit(r, 0, "two_factor", user.ID, map[string]any{"status": "pending"}, map[string]any{"status": "enabled"})
+		renderTwoFactorSetup(w, r, codes, "")
+	})
+
+	app.At("POST /admin/users/2fa/recovery-codes", func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
+		if err := data.VerifyTwoFactorCode(user.ID, r.FormValue("code")); err != nil {
+			renderTwoFactorSetup(w, r, nil, err.Error())
 			return
 		}
-		err = data.DeleteLocation(id)
+		codes, err := data.RegenerateRecoveryCodes(user.ID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		recordAudit(r, 0, "two_factor", user.ID, nil, map[string]any{"recovery_codes": "regenerated"})
+		renderTwoFactorSetup(w, r, codes, "")
 	})
 
-	// Payroll Events Page
-	app.At("GET /admin/locations/{id}/payroll", func(w http.ResponseWriter, r *http.Request) {
-		idStr := r.PathValue("id")
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			http.Error(w, "Invalid ID", http.StatusBadRequest)
+	app.At("POST /admin/users/2fa/disable", func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
+		if isAdminUser(user) && data.GetBoolSetting(organizationIDForUser(user), data.SettingRequireAdmin2FA) {
+			renderTwoFactorSetup(w, r, nil, "Two-factor authentication is required for admin accounts.")
 			return
 		}
-		loc, err := data.GetLocationByID(id)
-		if err != nil {
+		if err := data.VerifyTwoFactorCode(user.ID, r.FormValue("code")); err != nil {
+			renderTwoFactorSetup(w, r, nil, err.Error())
+			return
+		}
+		if err := data.DisableTwoFactor(user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, 0, "two_factor", user.ID, map[string]any{"status": "enabled"}, map[string]any{"status": "disabled"})
+		http.Redirect(w, r, "/admin/users/2fa", http.StatusSeeOther)
+	})
+
+	app.At("POST /admin/users/2fa/require", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		orgID := currentOrganizationID(r)
+		before := data.GetBoolSetting(orgID, data.SettingRequireAdmin2FA)
+		required := r.FormValue("required") == "on"
+		if err := data.SetBoolSetting(orgID, data.SettingRequireAdmin2FA, required); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, 0, "setting", data.SettingRequireAdmin2FA, before, required)
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/users/{userId}/2fa/reset", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.PathValue("userId"))
+		if err != nil {
+			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+		if err := data.DisableTwoFactor(userID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, 0, "two_factor", userID, map[string]any{"status": "enabled"}, map[string]any{"status": "reset"})
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/users/{userId}/role", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.PathValue("userId"))
+		if err != nil {
+			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+		before, err := data.GetUserByID(userID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		audit := auditEntry(r, 0, "user", userID, map[string]any{"role": before.Role}, map[string]any{"role": data.NormalizeRole(r.FormValue("role"))})
+		if err := data.UpdateUserRole(userID, r.FormValue("role"), audit); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/users/{userId}/password", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.PathValue("userId"))
+		if err != nil {
+			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+		audit := auditEntry(r, 0, "user", userID, nil, map[string]any{"password_reset": true})
+		if err := data.ResetUserPassword(userID, r.FormValue("password"), audit); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/users/{userId}/disable", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.PathValue("userId"))
+		if err != nil {
+			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+		audit := auditEntry(r, 0, "user", userID, map[string]any{"disabled": false}, map[string]any{"disabled": true})
+		if err := data.DisableUser(userID, audit); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/users/{userId}/enable", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.PathValue("userId"))
+		if err != nil {
+			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+		audit := auditEntry(r, 0, "user", userID, map[string]any{"disabled": true}, map[string]any{"disabled": false})
+		if err := data.EnableUser(userID, audit); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/users/{userId}/delete", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.PathValue("userId"))
+		if err != nil {
+			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+		before, err := data.GetUserByID(userID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		audit := auditEntry(r, 0, "user", userID, map[string]any{"username": before.Username, "role": before.Role}, nil)
+		if err := data.DeleteUser(userID, audit); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	// Log a single user out everywhere.
+	app.At("POST /admin/users/{userId}/sessions/revoke", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.PathValue("userId"))
+		if err != nil {
+			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+		if err := data.RevokeUserSessions(userID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, 0, "session", nil, map[string]any{"user_id": userID}, nil)
+		http.Redirect(w, r, "/admin/users/sessions", http.StatusSeeOther)
+	}))
+
+	// Log everyone in the organization out, including the admin performing
+	// the action.
+	app.At("POST /admin/users/sessions/revoke-all", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		users, err := data.GetUsersInOrganization(currentOrganizationID(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Recorded first: the actor's own session is revoked along with
+		// everyone else's.
+		recordAudit(r, 0, "session", nil, map[string]any{"scope": "organization"}, nil)
+		for _, u := range users {
+			if err := data.RevokeUserSessions(u.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		clearSessionCookie(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/users/sessions/rotate-key", requireSuperAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if err := data.RotateSessionSigningKey(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, 0, "setting", "session_signing_key", nil, map[string]any{"rotated": true})
+		http.Redirect(w, r, "/admin/users/sessions", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/users/lockouts/clear", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		kind := r.FormValue("kind")
+		if kind != data.ThrottleUsername && kind != data.ThrottleIP {
+			http.Error(w, "Invalid lockout kind", http.StatusBadRequest)
+			return
+		}
+		if !isSuperAdmin(r) {
+			scope, err := loginScope(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !scope.HasThrottle(kind, r.FormValue("key")) {
+				http.Error(w, "Lockout not found", http.StatusNotFound)
+				return
+			}
+		}
+		before, _ := data.GetLoginThrottle(kind, r.FormValue("key"))
+		if err := data.ClearLoginThrottle(kind, r.FormValue("key")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, 0, "login_lockout", kind+":"+before.Key, before, nil)
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}))
+
+	// Database Backup Download
+	// Backups hold every organization's data.
+	app.At("GET /admin/backup", requireSuperAdmin(requirePermission(data.PermManageBackups, func(w http.ResponseWriter, r *http.Request) {
+		dir, err := os.MkdirTemp("", "totem-backup-")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.RemoveAll(dir)
+		name := data.BackupFileName(time.Now())
+		path := filepath.Join(dir, name)
+		if err := data.BackupTo(path); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, 0, "backup", name, nil, map[string]any{"bytes": info.Size()})
+		w.Header().Set("Content-Type", "application/vnd.sqlite3")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		http.ServeContent(w, r, name, info.ModTime(), file)
+	})))
+
+	// Audit Log
+	app.At("GET /admin/audit", requirePermission(data.PermViewAudit, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := data.AuditFilter{
+			OrganizationID: currentOrganizationID(r),
+			EntityType:     query.Get("entity"),
+			StartDate:      query.Get("start"),
+			EndDate:        query.Get("end"),
+		}
+		filter.LocationID, _ = strconv.Atoi(query.Get("location"))
+		filter.UserID, _ = strconv.Atoi(query.Get("user"))
+
+		entries, err := data.GetAuditEntries(s.store, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		user, _ := currentUser(r)
+		locations, err := data.GetLocationsForUser(s.store, user, currentOrganizationID(r))
+		if err != nil {
+			locations = []data.CfaLocation{}
+		}
+		locationNames := make(map[int]string, len(locations))
+		for _, loc := range locations {
+			locationNames[loc.ID] = loc.Name
+		}
+		users, err := data.GetUsersInOrganization(filter.OrganizationID)
+		if err != nil {
+			users = []data.User{}
+		}
+
+		templateData := struct {
+			Entries       []data.AuditEntry
+			Filter        data.AuditFilter
+			Locations     []data.CfaLocation
+			LocationNames map[int]string
+			Users         []data.User
+			EntityTypes   []string
+		}{
+			Entries:       entries,
+			Filter:        filter,
+			Locations:     locations,
+			LocationNames: locationNames,
+			Users:         users,
+			EntityTypes:   data.AuditEntityTypes,
+		}
+		if err := renderTemplate(w, r, "audit.html", templateData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+
+	// Organizations
+	app.At("GET /admin/organizations", requireSuperAdmin(func(w http.ResponseWriter, r *http.Request) {
+		organizations, err := data.GetOrganizations()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		locations, err := data.GetActiveLocations(s.store)
+		if err != nil {
+			locations = []data.CfaLocation{}
+		}
+		users, err := data.GetUsers()
+		if err != nil {
+			users = []data.User{}
+		}
+		superAdmins, err := data.GetSuperAdminIDs()
+		if err != nil {
+			superAdmins = map[int]bool{}
+		}
+
+		type locationRow struct {
+			data.CfaLocation
+			OrganizationID int
+		}
+		type userRow struct {
+			data.User
+			OrganizationID int
+			SuperAdmin     bool
+		}
+		locationCounts := map[int]int{}
+		userCounts := map[int]int{}
+		locationRows := make([]locationRow, 0, len(locations))
+		for _, loc := range locations {
+			orgID, _ := data.OrganizationIDForLocation(loc.ID)
+			locationCounts[orgID]++
+			locationRows = append(locationRows, locationRow{CfaLocation: loc, OrganizationID: orgID})
+		}
+		userRows := make([]userRow, 0, len(users))
+		for _, u := range users {
+			orgID, _ := data.OrganizationIDForUser(u.ID)
+			userCounts[orgID]++
+			userRows = append(userRows, userRow{User: u, OrganizationID: orgID, SuperAdmin: superAdmins[u.ID]})
+		}
+
+		templateData := struct {
+			Organizations  []data.Organization
+			CurrentID      int
+			LocationCounts map[int]int
+			UserCounts     map[int]int
+			Locations      []locationRow
+			Users          []userRow
+		}{
+			Organizations:  organizations,
+			CurrentID:      currentOrganizationID(r),
+			LocationCounts: locationCounts,
+			UserCounts:     userCounts,
+			Locations:      locationRows,
+			Users:          userRows,
+		}
+		if err := renderTemplate(w, r, "organizations.html", templateData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+
+	app.At("POST /admin/organizations", requireSuperAdmin(func(w http.ResponseWriter, r *http.Request) {
+		name := r.FormValue("name")
+		orgID, err := data.CreateOrganization(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recordAudit(r, 0, "organization", orgID, nil, map[string]any{"name": strings.TrimSpace(name)})
+		http.Redirect(w, r, "/admin/organizations", http.StatusSeeOther)
+	}))
+
+	// Switch the super-admin's working organization for this session.
+	// Everything else they see is scoped to it until they switch again; their
+	// own organization membership is left alone.
+	app.At("POST /admin/organizations/switch", requireSuperAdmin(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := currentSession(r)
+		if !ok {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		orgID, err := strconv.Atoi(r.FormValue("organization_id"))
+		if err != nil {
+			http.Error(w, "Invalid Organization ID", http.StatusBadRequest)
+			return
+		}
+		if _, err := data.GetOrganizationByID(orgID); err != nil {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		before := currentOrganizationID(r)
+		if err := data.SetSessionOrganization(session.Key, orgID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, 0, "organization", orgID, map[string]any{"current": before}, map[string]any{"current": orgID})
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/organizations/locations/move", requireSuperAdmin(func(w http.ResponseWriter, r *http.Request) {
+		locationID, err := strconv.Atoi(r.FormValue("location_id"))
+		if err != nil {
+			http.Error(w, "Invalid Location ID", http.StatusBadRequest)
+			return
+		}
+		orgID, err := strconv.Atoi(r.FormValue("organization_id"))
+		if err != nil {
+			http.Error(w, "Invalid Organization ID", http.StatusBadRequest)
+			return
+		}
+		if _, err := s.store.GetLocationByID(locationID); err != nil {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		if _, err := data.GetOrganizationByID(orgID); err != nil {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		before, _ := data.OrganizationIDForLocation(locationID)
+		if err := data.SetLocationOrganization(locationID, orgID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, locationID, "location", locationID, map[string]any{"organization_id": before}, map[string]any{"organization_id": orgID})
+		http.Redirect(w, r, "/admin/organizations", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/organizations/users/move", requireSuperAdmin(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.FormValue("user_id"))
+		if err != nil {
+			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+		orgID, err := strconv.Atoi(r.FormValue("organization_id"))
+		if err != nil {
+			http.Error(w, "Invalid Organization ID", http.StatusBadRequest)
+			return
+		}
+		if _, err := data.GetUserByID(userID); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if _, err := data.GetOrganizationByID(orgID); err != nil {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		before, _ := data.OrganizationIDForUser(userID)
+		audit := auditEntry(r, 0, "user", userID, map[string]any{"organization_id": before}, map[string]any{"organization_id": orgID})
+		if err := data.MoveUserToOrganization(userID, orgID, audit); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/organizations", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/organizations/users/{userId}/super-admin", requireSuperAdmin(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.PathValue("userId"))
+		if err != nil {
+			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+		if _, err := data.GetUserByID(userID); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		enabled := r.FormValue("enabled") == "true"
+		audit := auditEntry(r, 0, "user", userID, map[string]any{"super_admin": !enabled}, map[string]any{"super_admin": enabled})
+		if err := data.SetSuperAdmin(userID, enabled, audit); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/organizations", http.StatusSeeOther)
+	}))
+
+	// View Location Details
+	app.At("GET /admin/locations/{id}", requirePermission(data.PermViewSales, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		loc, err := s.store.GetLocationByID(id)
+		if err != nil {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		profile, err := s.store.GetLocationProfile(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		now := profile.Now()
+		cal := profile.Calendar
+		today := calendar.Date(now)
+		period := cal.Period(now)
+		periodToDate := calendar.Range{Start: period.Start, End: today}
+		perfRecords, err := s.store.GetPerformanceReport(id, period.Start.Format(calendar.DateLayout), today.Format(calendar.DateLayout))
+		if err != nil {
+			perfRecords = []data.DailyPerformanceRecord{}
+		}
+		closed, err := s.locationClosedDays(profile)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		perfSummary := data.CalculateOperatingSummary(perfRecords, closed, periodToDate)
+		productivity := 0.0
+		if perfSummary.Hours > 0 {
+			productivity = perfSummary.Sales / perfSummary.Hours
+		}
+		weeks := data.RollupPerformance(perfRecords, cal, calendar.UnitWeek, periodToDate, now.Location(), closed)
+
+		year, fiscalYear := cal.Year(now)
+		yearToDate := calendar.Range{Start: year.Start, End: today}
+		ytdRecords, err := s.store.GetPerformanceReport(id, year.Start.Format(calendar.DateLayout), today.Format(calendar.DateLayout))
+		if err != nil {
+			ytdRecords = []data.DailyPerformanceRecord{}
+		}
+		periods := data.RollupPerformance(ytdRecords, cal, calendar.UnitPeriod, yearToDate, now.Location(), closed)
+
+		templateData := struct {
+			Location      data.CfaLocation
+			Profile       data.LocationProfile
+			Hours         []weekdayHours
+			Period        calendar.Period
+			PeriodStart   string
+			PeriodEnd     string
+			PeriodSales   float64
+			OperatingDays int
+			ClosedDays    int
+			AvgDailySales float64
+			AvgDailyHours float64
+			Productivity  float64
+			LaborPercent  float64
+			Weeks         []data.PerformanceRollup
+			FiscalYear    int
+			YearStart     string
+			YearToDate    data.OperatingSummary
+			PeriodsToDate []data.PerformanceRollup
+		}{
+			Location:      loc,
+			Profile:       profile,
+			Hours:         hoursByWeekday(profile),
+			Period:        period,
+			PeriodStart:   period.Start.Format(calendar.DateLayout),
+			PeriodEnd:     today.Format(calendar.DateLayout),
+			PeriodSales:   perfSummary.Sales,
+			OperatingDays: perfSummary.OperatingDays,
+			ClosedDays:    perfSummary.ClosedDays,
+			AvgDailySales: perfSummary.AvgDailySales,
+			AvgDailyHours: perfSummary.AvgDailyHours,
+			Productivity:  productivity,
+			LaborPercent:  perfSummary.LaborPercent,
+			Weeks:         weeks,
+			FiscalYear:    fiscalYear,
+			YearStart:     year.Start.Format(calendar.DateLayout),
+			YearToDate:    data.CalculateOperatingSummary(ytdRecords, closed, yearToDate),
+			PeriodsToDate: periods,
+		}
+		err = renderTemplate(w, r, "location_details.html", templateData)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+
+	// Create Location
+	app.At("POST /admin/locations", requirePermission(data.PermManageLocations, func(w http.ResponseWriter, r *http.Request) {
+		name := r.FormValue("name")
+		number := r.FormValue("number")
+		if name != "" && number != "" {
+			id, err := s.store.CreateLocation(currentOrganizationID(r), name, number)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			recordAudit(r, id, "location", id, nil, map[string]any{"name": name, "number": number})
+		}
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}))
+
+	// Delete Location
+	app.At("POST /admin/locations/{id}/delete", requirePermission(data.PermManageLocations, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		before, err := s.store.GetLocationByID(id)
+		if err != nil {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		user, _ := currentUser(r)
+		audit := auditEntry(r, id, "location", id, before, map[string]any{"trashed": true})
+		err = data.MoveToTrash(data.TrashLocation, id, id, before.Name, user.ID, audit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}))
+
+	// Trash
+	app.At("GET /admin/trash", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		items, err := data.GetTrash()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		locations, err := s.store.GetAllLocations()
+		if err != nil {
+			locations = []data.CfaLocation{}
+		}
+		locationNames := make(map[int]string, len(locations))
+		for _, loc := range locations {
+			locationNames[loc.ID] = loc.Name
+		}
+		var visible []data.TrashItem
+		for _, item := range items {
+			if canManageTrashItem(r, item) {
+				visible = append(visible, item)
+			}
+		}
+
+		templateData := struct {
+			Items         []data.TrashItem
+			LocationNames map[int]string
+			RetentionDays int
+		}{
+			Items:         visible,
+			LocationNames: locationNames,
+			RetentionDays: int(data.TrashRetention.Hours() / 24),
+		}
+		if err := renderTemplate(w, r, "trash.html", templateData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+
+	app.At("POST /admin/trash/{entity}/{entityId}/restore", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		item, ok := trashItemFromRequest(w, r)
+		if !ok {
+			return
+		}
+		audit := auditEntry(r, item.LocationID, item.EntityType, item.EntityID, map[string]any{"trashed": true}, map[string]any{"trashed": false})
+		if err := data.RestoreFromTrash(item.EntityType, item.EntityID, audit); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/trash/{entity}/{entityId}/purge", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		item, ok := trashItemFromRequest(w, r)
+		if !ok {
+			return
+		}
+		if err := data.PurgeTrashedItem(s.store, item.EntityType, item.EntityID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, item.LocationID, item.EntityType, item.EntityID, item, nil)
+		http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+	}))
+
+	// Payroll Events Page
+	app.At("GET /admin/locations/{id}/payroll", requirePermission(data.PermViewPayroll, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		loc, err := s.store.GetLocationByID(id)
+		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
@@ -1132,16 +2080,16 @@ func RegisterRoutes(app *vii.App) {
 
 		// Default to last 90 days if no filter
 		if startDate == "" && endDate == "" {
-			now := time.Now()
+			now := s.locationNow(id)
 			endDate = now.Format("2006-01-02")
 			startDate = now.AddDate(0, 0, -90).Format("2006-01-02")
 		}
 
-		events, err := data.GetPayrollEventsByLocation(id, startDate, endDate)
+		events, err := s.store.GetPayrollEventsByLocation(id, startDate, endDate)
 		if err != nil {
 			events = []data.PayrollEvent{}
 		}
-		employees, err := data.GetAllEmployeesByLocation(id)
+		employees, err := s.store.GetAllEmployeesByLocation(id)
 		if err != nil {
 			employees = []data.Employee{}
 		}
@@ -1151,7 +2099,7 @@ func RegisterRoutes(app *vii.App) {
 			totalAmount += e.Amount
 		}
 
-		ranges := getCommonRanges()
+		ranges := getCommonRanges(s.locationProfile(id))
 
 		templateData := struct {
 			Location    data.CfaLocation
@@ -1162,7 +2110,7 @@ func RegisterRoutes(app *vii.App) {
 			EndDate     string
 			Today       string
 			TotalAmount float64
-			Ranges      struct{ MonthStart, NinetyStart, YTDStart, Today string }
+			Ranges      commonRanges
 		}{
 			Location:    loc,
 			Events:      events,
@@ -1170,18 +2118,18 @@ func RegisterRoutes(app *vii.App) {
 			Employees:   employees,
 			StartDate:   startDate,
 			EndDate:     endDate,
-			Today:       time.Now().Format("2006-01-02"),
+			Today:       s.locationNow(id).Format("2006-01-02"),
 			TotalAmount: totalAmount,
 			Ranges:      ranges,
 		}
-		err = vii.ExecuteTemplate(w, r, "payroll_events.html", templateData)
+		err = renderTemplate(w, r, "payroll_events.html", templateData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
 	// Create Payroll Event
-	app.At("POST /admin/locations/{id}/payroll", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/payroll", requirePermission(data.PermEditPayroll, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
@@ -1203,40 +2151,62 @@ func RegisterRoutes(app *vii.App) {
 			http.Error(w, "Invalid input", http.StatusBadRequest)
 			return
 		}
-		err = data.CreatePayrollEvent(id, employeeID, date, eventType, description, amount)
+		err = s.updateAudited(func(tx *data.Tx) (data.AuditEntry, error) {
+			eventID, err := data.CreatePayrollEventTx(tx, id, employeeID, date, eventType, description, amount)
+			return auditEntry(r, id, "payroll_event", eventID, nil, map[string]any{
+				"employee_id": employeeID,
+				"date":        date,
+				"event_type":  eventType,
+				"description": description,
+				"amount":      amount,
+			}), err
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		http.Redirect(w, r, "/admin/locations/"+idStr+"/payroll", http.StatusSeeOther)
-	})
+	}))
 
 	// Delete Payroll Event
-	app.At("POST /admin/locations/{id}/payroll/{eventId}/delete", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/payroll/{eventId}/delete", requirePermission(data.PermEditPayroll, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
 		eventIdStr := r.PathValue("eventId")
 		eventId, err := strconv.Atoi(eventIdStr)
 		if err != nil {
 			http.Error(w, "Invalid Event ID", http.StatusBadRequest)
 			return
 		}
-		err = data.DeletePayrollEvent(eventId)
+		event, ok := s.payrollEventForLocation(eventId, id)
+		if !ok {
+			http.Error(w, "Payroll event not found", http.StatusNotFound)
+			return
+		}
+		user, _ := currentUser(r)
+		label := event.EventType + ": " + event.Description
+		audit := auditEntry(r, id, "payroll_event", eventId, event, map[string]any{"trashed": true})
+		err = data.MoveToTrash(data.TrashPayrollEvent, eventId, id, label, user.ID, audit)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		http.Redirect(w, r, "/admin/locations/"+idStr+"/payroll", http.StatusSeeOther)
-	})
+	}))
 
 	// Employees Page
-	app.At("GET /admin/locations/{id}/employees", func(w http.ResponseWriter, r *http.Request) {
+	app.At("GET /admin/locations/{id}/employees", requirePermission(data.PermViewEmployees, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		loc, err := data.GetLocationByID(id)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
@@ -1250,12 +2220,12 @@ func RegisterRoutes(app *vii.App) {
 		var employees []data.Employee
 		switch status {
 		case "terminated":
-			employees, err = data.GetTerminatedEmployeesByLocation(id)
+			employees, err = s.store.GetTerminatedEmployeesByLocation(id)
 		case "all":
-			employees, err = data.GetAllEmployeesByLocation(id)
+			employees, err = s.store.GetAllEmployeesByLocation(id)
 		default:
 			status = "active"
-			employees, err = data.GetActiveEmployeesByLocation(id)
+			employees, err = s.store.GetActiveEmployeesByLocation(id)
 		}
 		if err != nil {
 			employees = []data.Employee{}
@@ -1270,14 +2240,14 @@ func RegisterRoutes(app *vii.App) {
 			Employees: employees,
 			Status:    status,
 		}
-		err = vii.ExecuteTemplate(w, r, "employees.html", templateData)
+		err = renderTemplate(w, r, "employees.html", templateData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
 	// Create Employee
-	app.At("POST /admin/locations/{id}/employees", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/employees", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
@@ -1290,22 +2260,28 @@ func RegisterRoutes(app *vii.App) {
 			http.Error(w, "First name and last name are required", http.StatusBadRequest)
 			return
 		}
-		err = data.CreateEmployee(id, firstName, lastName)
+		change := data.EmployeeChange{Kind: data.EmployeeCreate, FirstName: firstName, LastName: lastName}
+		err = s.applyEmployeeChange(r, id, change, nil, map[string]any{"first_name": firstName, "last_name": lastName})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees", http.StatusSeeOther)
-	})
+	}))
 
 	// Import Employees from Bio XLSX
-	app.At("POST /admin/locations/{id}/employees/import", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/employees/import", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
+		loc, err := s.store.GetLocationByID(id)
+		if err != nil {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
 
 		file, header, err := r.FormFile("bio_file")
 		if err != nil {
@@ -1313,211 +2289,503 @@ func RegisterRoutes(app *vii.App) {
 			return
 		}
 		defer file.Close()
+		source, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		bioEmployees, err := parseBioEmployeesFromSpreadsheet(file, header.Filename)
+		bioEmployees, err := parseBioEmployeesFromSpreadsheet(bytes.NewReader(source), header.Filename)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		existingEmployees, err := data.GetAllEmployeesByLocation(id)
+		existingEmployees, err := s.store.GetAllEmployeesByLocation(id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		existingByTimePunch := make(map[string]data.Employee, len(existingEmployees))
-		for _, emp := range existingEmployees {
-			key := canonicalTimePunchName(emp.FirstName, emp.LastName)
-			if emp.TimePunchName != "" {
-				key = canonicalTimePunchNameFromValue(emp.TimePunchName)
+		terminationDate := strings.TrimSpace(r.FormValue("termination_date"))
+		if terminationDate == "" {
+			terminationDate = s.locationNow(id).Format("2006-01-02")
+		}
+		if _, err := time.Parse("2006-01-02", terminationDate); err != nil {
+			http.Error(w, "Invalid termination date", http.StatusBadRequest)
+			return
+		}
+
+		plan := planBioImport(bioEmployees, existingEmployees, locationMatcher(r, id, existingEmployees))
+		// A truncated or wrong export shows up as a wave of terminations;
+		// make the user opt in to each one.
+		massTermination := len(plan.Terminates) > 0 && len(plan.Terminates)*4 > plan.ActiveCount
+
+		// The plan is saved with the file, and applying it posts only which
+		// entries to keep, so nothing on the preview form can change what
+		// is applied.
+		var runID int
+		if !plan.Empty() {
+			runID, err = s.startImportRun(r, id, data.ImportBio, header.Filename, header.Header.Get("Content-Type"), source, plan.changes(terminationDate), nil)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		templateData := struct {
+			Location        data.CfaLocation
+			Filename        string
+			ImportRunID     int
+			Plan            bioImportPlan
+			MassTermination bool
+			TerminationDate string
+		}{
+			Location:        loc,
+			Filename:        header.Filename,
+			ImportRunID:     runID,
+			Plan:            plan,
+			MassTermination: massTermination,
+			TerminationDate: terminationDate,
+		}
+		if err := renderTemplate(w, r, "employee_import_preview.html", templateData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+
+	app.At("POST /admin/locations/{id}/employees/import/apply", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		loc, err := s.store.GetLocationByID(id)
+		if err != nil {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		runID, _ := strconv.Atoi(r.FormValue("import_run"))
+		run, err := data.GetImportRun(s.store, id, runID)
+		if err != nil {
+			writeImportRunError(w, data.ErrImportRunNotFound)
+			return
+		}
+		if run.Status != data.ImportPending {
+			writeImportRunError(w, data.ErrImportRunApplied)
+			return
+		}
+
+		chosen := map[int]bool{}
+		var selected []int
+		for _, value := range r.Form["change"] {
+			i, err := strconv.Atoi(value)
+			if err != nil || i < 0 || i >= len(run.Plan) {
+				writeImportRunError(w, data.ErrImportPlanIndex)
+				return
+			}
+			if !chosen[i] {
+				chosen[i] = true
+				selected = append(selected, i)
+			}
+		}
+		sort.Ints(selected)
+
+		result := importResult{Title: "Employee Import (Bio)", Location: loc, RunID: runID}
+		var valid []data.ImportedChange
+		for i, c := range run.Plan {
+			row := importRow{Name: c.Name, Change: c.Summary}
+			if !chosen[i] {
+				row.Reason = "Not selected"
+				result.Skipped = append(result.Skipped, row)
+				continue
+			}
+			if reason := validateBioChange(c.Change); reason != "" {
+				row.Reason = reason
+				result.Failed = append(result.Failed, row)
+				continue
+			}
+			valid = append(valid, c)
+		}
+
+		// Rows that can't be applied fail the whole import rather than
+		// leaving the roster half-synced.
+		if len(result.Failed) > 0 {
+			result.RolledBack = true
+			result.Error = "Some changes were invalid, so nothing was imported."
+			for _, c := range valid {
+				result.Failed = append(result.Failed, importRow{Name: c.Name, Change: c.Summary, Reason: "Not applied: the import was rolled back"})
 			}
-			existingByTimePunch[key] = emp
+			renderImportResult(w, r, result)
+			return
+		}
+		if err := s.applyImportPlan(r, id, runID, selected, &result); err != nil {
+			writeImportRunError(w, err)
+			return
+		}
+		renderImportResult(w, r, result)
+	}))
+
+	// Import Employee Birthdates
+	app.At("POST /admin/locations/{id}/employees/birthdates/import", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		loc, err := s.store.GetLocationByID(id)
+		if err != nil {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+
+		file, header, err := r.FormFile("birthdate_file")
+		if err != nil {
+			http.Error(w, "Birthdate XLSX file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		source, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		birthdateRows, unparsed, err := parseBirthdatesFromSpreadsheet(bytes.NewReader(source), header.Filename)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		existingEmployees, err := s.store.GetEmployeesByLocation(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		activeByTimePunch := make(map[string]bioEmployeeRow)
-		for _, emp := range bioEmployees {
-			if emp.Terminated {
+		matcher := locationMatcher(r, id, existingEmployees)
+		result := importResult{
+			Title:    "Birthdate Import",
+			Location: loc,
+			Unparsed: unparsed,
+			Roster:   rosterByName(existingEmployees),
+		}
+		var planned []data.ImportedChange
+		for _, row := range birthdateRows {
+			name := match.Name{First: row.FirstName, Last: row.LastName}
+			matches := matcher.match(name)
+			if len(matches) != 1 {
+				unmatched := unmatchedRow{Name: row.FirstName + " " + row.LastName, Value: row.Birthday, Change: "Birthday " + row.Birthday}
+				reportUnmatched(&result, unmatched, matches, matcher.suggest(3, name))
 				continue
 			}
-			activeByTimePunch[emp.TimePunchName] = emp
+			existing := matches[0]
+			if existing.Birthday == row.Birthday {
+				result.Skipped = append(result.Skipped, importRow{Name: existing.FirstName + " " + existing.LastName, Change: "Birthday " + row.Birthday, Reason: "Already up to date"})
+				continue
+			}
+			planned = append(planned, birthdayChange(existing, row.Birthday))
 		}
 
-		terminationDate := time.Now().Format("2006-01-02")
+		if err := s.recordImport(r, id, data.ImportBirthdates, header.Filename, header.Header.Get("Content-Type"), source, planned, &result); err != nil {
+			writeImportRunError(w, err)
+			return
+		}
+		renderImportResult(w, r, result)
+	}))
 
-		for key, emp := range activeByTimePunch {
-			if existing, ok := existingByTimePunch[key]; ok {
-				// If employee was terminated but now appears in active bio, reinstate them
-				if existing.Terminated {
-					if err := data.ReinstateEmployee(existing.ID); err != nil {
-						http.Error(w, err.Error(), http.StatusInternalServerError)
-						return
-					}
-				}
-				if existing.FirstName != emp.FirstName || existing.LastName != emp.LastName {
-					err := data.UpdateEmployee(existing.ID, emp.FirstName, emp.LastName, existing.Birthday, existing.Department, existing.AnnualSalary)
-					if err != nil {
-						http.Error(w, err.Error(), http.StatusInternalServerError)
-						return
-					}
-				}
+	// Import Employee Departments from HotSchedules
+	app.At("POST /admin/locations/{id}/employees/departments/import", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		loc, err := s.store.GetLocationByID(id)
+		if err != nil {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+
+		htmlValue := r.FormValue("department_html")
+		departmentRows, unparsed, err := parseHotSchedulesDepartmentsFromHTML(htmlValue)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		existingEmployees, err := s.store.GetEmployeesByLocation(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		matcher := locationMatcher(r, id, existingEmployees)
+		result := importResult{
+			Title:    "Department Import (HotSchedules)",
+			Location: loc,
+			Unparsed: unparsed,
+			Roster:   rosterByName(existingEmployees),
+		}
+		var planned []data.ImportedChange
+		for _, row := range departmentRows {
+			names := []match.Name{{First: row.FirstName, Last: row.LastName}}
+			if row.PreferredName != "" {
+				names = append(names, match.Name{First: row.PreferredName, Last: row.LastName})
+			}
+			matches := matcher.match(names...)
+			if len(matches) != 1 {
+				unmatched := unmatchedRow{Name: row.FirstName + " " + row.LastName, Value: row.Department, Change: "Department " + row.Department}
+				reportUnmatched(&result, unmatched, matches, matcher.suggest(3, names...))
 				continue
 			}
-			err := data.CreateEmployee(id, emp.FirstName, emp.LastName)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+			existing := matches[0]
+			if existing.Department == row.Department {
+				result.Skipped = append(result.Skipped, importRow{Name: existing.FirstName + " " + existing.LastName, Change: "Department " + row.Department, Reason: "Already up to date"})
+				continue
 			}
+			planned = append(planned, departmentChange(existing, row.Department))
+		}
+
+		if err := s.recordImport(r, id, data.ImportDepartments, "hotschedules-departments.html", "text/html; charset=utf-8", []byte(htmlValue), planned, &result); err != nil {
+			writeImportRunError(w, err)
+			return
+		}
+		renderImportResult(w, r, result)
+	}))
+
+	// Link unmatched import rows to employees by hand
+	app.At("POST /admin/locations/{id}/imports/{runId}/link", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		loc, err := s.store.GetLocationByID(id)
+		if err != nil {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		runID, err := strconv.Atoi(r.PathValue("runId"))
+		if err != nil {
+			http.Error(w, "Invalid Import ID", http.StatusBadRequest)
+			return
+		}
+		run, err := data.GetImportRun(s.store, id, runID)
+		if err != nil {
+			http.Error(w, "Import not found", http.StatusNotFound)
+			return
+		}
+		if run.RolledBack() {
+			http.Error(w, "This import was rolled back", http.StatusConflict)
+			return
+		}
+		var change func(data.Employee, string) data.ImportedChange
+		switch run.Kind {
+		case data.ImportBirthdates:
+			change = birthdayChange
+		case data.ImportDepartments:
+			change = departmentChange
+		default:
+			http.Error(w, "Rows from this import can't be linked", http.StatusBadRequest)
+			return
 		}
 
-		for _, existing := range existingEmployees {
-			key := canonicalTimePunchName(existing.FirstName, existing.LastName)
-			if existing.TimePunchName != "" {
-				key = canonicalTimePunchNameFromValue(existing.TimePunchName)
+		result := importResult{Title: "Link " + run.KindLabel() + " Rows", Location: loc}
+		var planned []data.ImportedChange
+		// Each link also teaches the matcher the spelling from the file, so the
+		// next import matches it without help.
+		var aliases []data.EmployeeAlias
+		// Only the rows saved with the run can be linked, and only to the
+		// value read from the file; the form picks an employee per row.
+		for i, row := range run.Unmatched {
+			empID, err := strconv.Atoi(r.FormValue("link_" + strconv.Itoa(i)))
+			if err != nil {
+				result.Skipped = append(result.Skipped, importRow{Name: row.Name, Change: row.Value, Reason: "Not linked"})
+				continue
 			}
-			if _, ok := activeByTimePunch[key]; ok {
+			emp, ok := s.employeeForLocation(empID, id)
+			if !ok {
+				result.Failed = append(result.Failed, importRow{Name: row.Name, Change: row.Value, Reason: "Employee not found"})
 				continue
 			}
-			// Only terminate if not already terminated
-			if !existing.Terminated {
-				if err := data.TerminateEmployee(existing.ID, terminationDate); err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
+			switch run.Kind {
+			case data.ImportBirthdates:
+				if _, err := time.Parse("2006-01-02", row.Value); err != nil {
+					result.Failed = append(result.Failed, importRow{Name: row.Name, Change: row.Value, Reason: "Invalid birth date"})
+					continue
+				}
+			case data.ImportDepartments:
+				if !contains(data.Departments, row.Value) {
+					result.Failed = append(result.Failed, importRow{Name: row.Name, Change: row.Value, Reason: "Unknown department"})
+					continue
+				}
+			}
+			c := change(emp, row.Value)
+			c.Name += " (linked from " + row.Name + ")"
+			planned = append(planned, c)
+			if first, last, ok := splitFirstLastFromDisplayName(row.Name); ok {
+				alias := match.Name{First: first, Last: last}
+				if match.Score(alias, match.Name{First: emp.FirstName, Last: emp.LastName}) < 1 {
+					aliases = append(aliases, data.EmployeeAlias{EmployeeID: emp.ID, FirstName: first, LastName: last})
 				}
 			}
 		}
 
-		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees", http.StatusSeeOther)
-	})
+		if len(result.Failed) > 0 {
+			result.RolledBack = true
+			result.Error = "Some links were invalid, so nothing was changed."
+			for _, c := range planned {
+				result.Failed = append(result.Failed, importRow{Name: c.Name, Change: c.Summary, Reason: "Not applied: the import was rolled back"})
+			}
+			renderImportResult(w, r, result)
+			return
+		}
+		if err := s.appendImportChanges(r, id, runID, planned, &result); err != nil {
+			writeImportRunError(w, err)
+			return
+		}
+		if !result.RolledBack {
+			for _, a := range aliases {
+				audit := auditEntry(r, id, "employee_alias", a.EmployeeID, nil, map[string]any{"first_name": a.FirstName, "last_name": a.LastName})
+				if err := data.AddEmployeeAlias(id, a.EmployeeID, a.FirstName, a.LastName, audit); err != nil {
+					result.Failed = append(result.Failed, importRow{Name: a.FirstName + " " + a.LastName, Change: "Alias", Reason: "Alias not saved: " + err.Error()})
+				}
+			}
+		}
+		renderImportResult(w, r, result)
+	}))
 
-	// Import Employee Birthdates
-	app.At("POST /admin/locations/{id}/employees/birthdates/import", func(w http.ResponseWriter, r *http.Request) {
+	// Import History
+	app.At("GET /admin/locations/{id}/imports", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-
-		file, header, err := r.FormFile("birthdate_file")
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
-			http.Error(w, "Birthdate XLSX file is required", http.StatusBadRequest)
+			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
-		defer file.Close()
-
-		birthdateRows, err := parseBirthdatesFromSpreadsheet(file, header.Filename)
+		runs, err := data.GetImportRuns(s.store, id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		templateData := struct {
+			Location         data.CfaLocation
+			Runs             []data.ImportRun
+			MatchThreshold   int
+			CanEditThreshold bool
+		}{
+			Location:         loc,
+			Runs:             runs,
+			MatchThreshold:   int(math.Round(matchThreshold(currentOrganizationID(r)) * 100)),
+			CanEditThreshold: hasPermission(r, data.PermManageUsers),
+		}
+		if err := renderTemplate(w, r, "import_history.html", templateData); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
 
-		existingEmployees, err := data.GetEmployeesByLocation(id)
-		if err != nil {
+	// The threshold is an organization setting, so changing it takes the
+	// organization-wide user-management permission, not just access to this
+	// location's employees.
+	app.At("POST /admin/locations/{id}/imports/matching", requirePermission(data.PermManageUsers, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		if _, err := strconv.Atoi(idStr); err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		percent, err := strconv.Atoi(r.FormValue("threshold"))
+		if err != nil || percent < 50 || percent > 100 {
+			http.Error(w, "Match threshold must be a whole percent from 50 to 100", http.StatusBadRequest)
+			return
+		}
+		orgID := currentOrganizationID(r)
+		before := matchThreshold(orgID)
+		threshold := float64(percent) / 100
+		if err := data.SetFloatSetting(orgID, data.SettingNameMatchThreshold, threshold); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		recordAudit(r, 0, "setting", data.SettingNameMatchThreshold, before, threshold)
+		http.Redirect(w, r, "/admin/locations/"+idStr+"/imports", http.StatusSeeOther)
+	}))
 
-		existingByTimePunch := make(map[string]data.Employee, len(existingEmployees))
-		for _, emp := range existingEmployees {
-			key := canonicalTimePunchName(emp.FirstName, emp.LastName)
-			if emp.TimePunchName != "" {
-				key = canonicalTimePunchNameFromValue(emp.TimePunchName)
-			}
-			existingByTimePunch[key] = emp
+	app.At("GET /admin/locations/{id}/imports/{runId}/source", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
 		}
-
-		for _, row := range birthdateRows {
-			existing, ok := existingByTimePunch[row.TimePunchName]
-			if !ok {
-				continue
-			}
-			if existing.Birthday == row.Birthday {
-				continue
-			}
-			if err := data.UpdateEmployee(existing.ID, existing.FirstName, existing.LastName, row.Birthday, existing.Department, existing.AnnualSalary); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		runID, err := strconv.Atoi(r.PathValue("runId"))
+		if err != nil {
+			http.Error(w, "Invalid Import ID", http.StatusBadRequest)
+			return
 		}
+		run, err := data.GetImportRun(s.store, id, runID)
+		if err != nil {
+			http.Error(w, "Import not found", http.StatusNotFound)
+			return
+		}
+		contentType := run.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(filepath.Base(run.Filename), `"`, "")+`"`)
+		http.ServeContent(w, r, run.Filename, run.CreatedAt, bytes.NewReader(run.Source))
+	}))
 
-		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees", http.StatusSeeOther)
-	})
-
-	// Import Employee Departments from HotSchedules
-	app.At("POST /admin/locations/{id}/employees/departments/import", func(w http.ResponseWriter, r *http.Request) {
-		idStr := r.PathValue("id")
-		id, err := strconv.Atoi(idStr)
+	app.At("POST /admin/locations/{id}/imports/{runId}/rollback", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-
-		htmlValue := r.FormValue("department_html")
-		departmentRows, err := parseHotSchedulesDepartmentsFromHTML(htmlValue)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
-
-		existingEmployees, err := data.GetEmployeesByLocation(id)
+		runID, err := strconv.Atoi(r.PathValue("runId"))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Invalid Import ID", http.StatusBadRequest)
 			return
 		}
-
-		existingByTimePunch := make(map[string]data.Employee, len(existingEmployees))
-		existingByNameKey := make(map[string]data.Employee, len(existingEmployees))
-		for _, emp := range existingEmployees {
-			key := canonicalTimePunchName(emp.FirstName, emp.LastName)
-			if emp.TimePunchName != "" {
-				key = canonicalTimePunchNameFromValue(emp.TimePunchName)
-			}
-			existingByTimePunch[key] = emp
-			nameKey := normalizeNameKey(emp.FirstName, emp.LastName)
-			if nameKey != "" {
-				existingByNameKey[nameKey] = emp
-			}
+		run, err := data.GetImportRun(s.store, id, runID)
+		if err != nil {
+			http.Error(w, "Import not found", http.StatusNotFound)
+			return
 		}
-
-		for _, row := range departmentRows {
-			primaryKey := normalizeNameKey(row.FirstName, row.LastName)
-			preferredKey := ""
-			if row.PreferredName != "" {
-				preferredKey = normalizeNameKey(row.PreferredName, row.LastName)
-			}
-			existing, ok := existingByNameKey[primaryKey]
-			if !ok && preferredKey != "" {
-				existing, ok = existingByNameKey[preferredKey]
-			}
-			if !ok {
-				timePunch := canonicalTimePunchName(row.FirstName, row.LastName)
-				existing, ok = existingByTimePunch[timePunch]
-			}
-			if !ok {
-				continue
-			}
-			if existing.Department == row.Department {
-				continue
-			}
-			if err := data.UpdateEmployee(existing.ID, existing.FirstName, existing.LastName, existing.Birthday, row.Department, existing.AnnualSalary); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		if !run.CanRollBack() {
+			http.Error(w, "This import has nothing to roll back", http.StatusConflict)
+			return
 		}
 
-		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees", http.StatusSeeOther)
-	})
+		result := importResult{Title: "Roll Back " + run.KindLabel() + " Import", Location: loc, RunID: run.ID}
+		s.rollBackImport(r, run, &result)
+		renderImportResult(w, r, result)
+	}))
 
 	// Time Punch Summary
-	app.At("GET /admin/locations/{id}/timepunch", func(w http.ResponseWriter, r *http.Request) {
+	app.At("GET /admin/locations/{id}/timepunch", requirePermission(data.PermViewPayroll, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		loc, err := data.GetLocationByID(id)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
@@ -1529,25 +2797,25 @@ func RegisterRoutes(app *vii.App) {
 		}{
 			Location: loc,
 		}
-		if err := vii.ExecuteTemplate(w, r, "time_punch_summary.html", templateData); err != nil {
+		if err := renderTemplate(w, r, "time_punch_summary.html", templateData); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
-	app.At("POST /admin/locations/{id}/timepunch", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/timepunch", requirePermission(data.PermViewPayroll, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		loc, err := data.GetLocationByID(id)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
 		text := r.FormValue("time_punch_text")
-		employees, err := data.GetEmployeesByLocation(id)
+		employees, err := s.store.GetEmployeesByLocation(id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -1562,21 +2830,25 @@ func RegisterRoutes(app *vii.App) {
 				Location: loc,
 				Error:    err.Error(),
 			}
-			if err := vii.ExecuteTemplate(w, r, "time_punch_summary.html", templateData); err != nil {
+			if err := renderTemplate(w, r, "time_punch_summary.html", templateData); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
 			return
 		}
 
+		// The pasted report changes no employees but is kept in the import
+		// history so it can be downloaded again.
+		_, _ = s.startImportRun(r, id, data.ImportTimePunch, "time-punch-report.txt", "text/plain; charset=utf-8", []byte(text), nil, nil)
+
 		payrollEvents := []data.PayrollEvent{}
 		if !startDate.IsZero() && !endDate.IsZero() && !endDate.Before(startDate) {
-			payrollEvents, err = data.GetPayrollEventsByLocation(id, formatDateRange(startDate), formatDateRange(endDate))
+			payrollEvents, err = s.store.GetPayrollEventsByLocation(id, formatDateRange(startDate), formatDateRange(endDate))
 			if err != nil {
 				payrollEvents = []data.PayrollEvent{}
 			}
 		}
 
-		summary, err := summarizeTimePunchReportFromParsed(employeeTotals, reportTotals, startDate, endDate, employees, payrollEvents)
+		summary, err := summarizeTimePunchReportFromParsed(employeeTotals, reportTotals, startDate, endDate, employees, locationMatcher(r, id, employees), payrollEvents)
 		templateData := struct {
 			Location data.CfaLocation
 			Summary  *timePunchSummary
@@ -1585,7 +2857,7 @@ func RegisterRoutes(app *vii.App) {
 			Location: loc,
 		}
 		if err == nil && !startDate.IsZero() && !endDate.IsZero() && !endDate.Before(startDate) {
-			totalSales, err := data.GetTotalSalesByLocation(id, formatDateRange(startDate), formatDateRange(endDate))
+			totalSales, err := s.store.GetTotalSalesByLocation(id, formatDateRange(startDate), formatDateRange(endDate))
 			if err == nil {
 				summary.TotalSales = totalSales
 				if summary.TotalHours > 0 {
@@ -1598,13 +2870,13 @@ func RegisterRoutes(app *vii.App) {
 		} else {
 			templateData.Summary = &summary
 		}
-		if err := vii.ExecuteTemplate(w, r, "time_punch_summary.html", templateData); err != nil {
+		if err := renderTemplate(w, r, "time_punch_summary.html", templateData); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
 	// Edit Employee Form
-	app.At("GET /admin/locations/{id}/employees/{empId}/edit", func(w http.ResponseWriter, r *http.Request) {
+	app.At("GET /admin/locations/{id}/employees/{empId}/edit", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
@@ -1617,40 +2889,57 @@ func RegisterRoutes(app *vii.App) {
 			http.Error(w, "Invalid Employee ID", http.StatusBadRequest)
 			return
 		}
-		loc, err := data.GetLocationByID(id)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
-		employee, err := data.GetEmployeeByID(empId)
-		if err != nil {
+		employee, ok := s.employeeForLocation(empId, id)
+		if !ok {
 			http.Error(w, "Employee not found", http.StatusNotFound)
 			return
 		}
+		aliases, err := data.GetEmployeeAliases(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		templateData := struct {
 			Location    data.CfaLocation
 			Employee    data.Employee
 			Departments []string
+			Aliases     []data.EmployeeAlias
 		}{
 			Location:    loc,
 			Employee:    employee,
 			Departments: data.Departments,
+			Aliases:     aliases[empId],
 		}
-		err = vii.ExecuteTemplate(w, r, "employee_edit.html", templateData)
+		err = renderTemplate(w, r, "employee_edit.html", templateData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
 	// Update Employee
-	app.At("POST /admin/locations/{id}/employees/{empId}", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/employees/{empId}", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
 		empIdStr := r.PathValue("empId")
 		empId, err := strconv.Atoi(empIdStr)
 		if err != nil {
 			http.Error(w, "Invalid Employee ID", http.StatusBadRequest)
 			return
 		}
+		employee, ok := s.employeeForLocation(empId, id)
+		if !ok {
+			http.Error(w, "Employee not found", http.StatusNotFound)
+			return
+		}
 		firstName := r.FormValue("first_name")
 		lastName := r.FormValue("last_name")
 		birthday := r.FormValue("birthday")
@@ -1668,129 +2957,382 @@ func RegisterRoutes(app *vii.App) {
 			http.Error(w, "First name and last name are required", http.StatusBadRequest)
 			return
 		}
-		err = data.UpdateEmployee(empId, firstName, lastName, birthday, department, annualSalary)
+		updated := employee
+		updated.FirstName = firstName
+		updated.LastName = lastName
+		updated.Birthday = birthday
+		updated.Department = department
+		updated.AnnualSalary = annualSalary
+		change := data.EmployeeChange{
+			Kind:         data.EmployeeUpdate,
+			EmployeeID:   empId,
+			FirstName:    firstName,
+			LastName:     lastName,
+			Birthday:     birthday,
+			Department:   department,
+			AnnualSalary: annualSalary,
+		}
+		err = s.applyEmployeeChange(r, id, change, employee, updated)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees", http.StatusSeeOther)
-	})
+	}))
 
 	// Delete Employee
-	app.At("POST /admin/locations/{id}/employees/{empId}/delete", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/employees/{empId}/delete", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		empIdStr := r.PathValue("empId")
+		empId, err := strconv.Atoi(empIdStr)
+		if err != nil {
+			http.Error(w, "Invalid Employee ID", http.StatusBadRequest)
+			return
+		}
+		employee, ok := s.employeeForLocation(empId, id)
+		if !ok {
+			http.Error(w, "Employee not found", http.StatusNotFound)
+			return
+		}
+		user, _ := currentUser(r)
+		audit := auditEntry(r, id, "employee", empId, employee, map[string]any{"trashed": true})
+		err = data.MoveToTrash(data.TrashEmployee, empId, id, employee.FirstName+" "+employee.LastName, user.ID, audit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees", http.StatusSeeOther)
+	}))
+
+	// Employee Aliases
+	app.At("POST /admin/locations/{id}/employees/{empId}/aliases", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		empIdStr := r.PathValue("empId")
+		empId, err := strconv.Atoi(empIdStr)
+		if err != nil {
+			http.Error(w, "Invalid Employee ID", http.StatusBadRequest)
+			return
+		}
+		if _, ok := s.employeeForLocation(empId, id); !ok {
+			http.Error(w, "Employee not found", http.StatusNotFound)
+			return
+		}
+		first, last, ok := splitFirstLastFromDisplayName(r.FormValue("alias_name"))
+		if !ok {
+			http.Error(w, "Alias must be a first and last name", http.StatusBadRequest)
+			return
+		}
+		audit := auditEntry(r, id, "employee_alias", empId, nil, map[string]any{"first_name": first, "last_name": last})
+		if err := data.AddEmployeeAlias(id, empId, first, last, audit); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees/"+empIdStr+"/edit", http.StatusSeeOther)
+	}))
+
+	app.At("POST /admin/locations/{id}/employees/{empId}/aliases/{aliasId}/delete", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		empIdStr := r.PathValue("empId")
+		empId, err := strconv.Atoi(empIdStr)
+		if err != nil {
+			http.Error(w, "Invalid Employee ID", http.StatusBadRequest)
+			return
+		}
+		aliasID, err := strconv.Atoi(r.PathValue("aliasId"))
+		if err != nil {
+			http.Error(w, "Invalid Alias ID", http.StatusBadRequest)
+			return
+		}
+		if _, ok := s.employeeForLocation(empId, id); !ok {
+			http.Error(w, "Employee not found", http.StatusNotFound)
+			return
+		}
+		audit := auditEntry(r, id, "employee_alias", empId, map[string]any{"alias_id": aliasID}, nil)
+		if err := data.DeleteEmployeeAlias(empId, aliasID, audit); err != nil {
+			if errors.Is(err, data.ErrAliasNotFound) {
+				http.Error(w, "Alias not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees/"+empIdStr+"/edit", http.StatusSeeOther)
+	}))
+
+	// Terminate Employee
+	app.At("POST /admin/locations/{id}/employees/{empId}/terminate", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		empIdStr := r.PathValue("empId")
+		empId, err := strconv.Atoi(empIdStr)
+		if err != nil {
+			http.Error(w, "Invalid Employee ID", http.StatusBadRequest)
+			return
+		}
+		employee, ok := s.employeeForLocation(empId, id)
+		if !ok {
+			http.Error(w, "Employee not found", http.StatusNotFound)
+			return
+		}
+		terminationDate := s.locationNow(id).Format("2006-01-02")
+		err = s.applyEmployeeChange(r, id,
+			data.EmployeeChange{Kind: data.EmployeeTerminate, EmployeeID: empId, TerminationDate: terminationDate},
+			map[string]any{"terminated": employee.Terminated},
+			map[string]any{"terminated": true, "termination_date": terminationDate})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees", http.StatusSeeOther)
+	}))
+
+	// Reinstate Employee
+	app.At("POST /admin/locations/{id}/employees/{empId}/reinstate", requirePermission(data.PermManageEmployees, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
 		empIdStr := r.PathValue("empId")
 		empId, err := strconv.Atoi(empIdStr)
 		if err != nil {
-			http.Error(w, "Invalid Employee ID", http.StatusBadRequest)
+			http.Error(w, "Invalid Employee ID", http.StatusBadRequest)
+			return
+		}
+		employee, ok := s.employeeForLocation(empId, id)
+		if !ok {
+			http.Error(w, "Employee not found", http.StatusNotFound)
+			return
+		}
+		err = s.applyEmployeeChange(r, id,
+			data.EmployeeChange{Kind: data.EmployeeReinstate, EmployeeID: empId},
+			map[string]any{"terminated": employee.Terminated, "termination_date": employee.TerminationDate},
+			map[string]any{"terminated": false})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees?status=terminated", http.StatusSeeOther)
+	}))
+
+	// Edit Location Form
+	app.At("GET /admin/locations/{id}/edit", requirePermission(data.PermManageLocations, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		loc, err := s.store.GetLocationByID(id)
+		if err != nil {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		profile, err := s.store.GetLocationProfile(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		renderEditLocation(w, r, http.StatusOK, loc, profile, "")
+	}))
+
+	// Update Location
+	app.At("POST /admin/locations/{id}/update", requirePermission(data.PermManageLocations, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		name := r.FormValue("name")
+		number := r.FormValue("number")
+		before, err := s.store.GetLocationByID(id)
+		if err != nil {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		beforeProfile, err := s.store.GetLocationProfile(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		after := data.CfaLocation{ID: id, Name: name, Number: number}
+		profile := locationProfileFromForm(r, id)
+		profile.Holidays = beforeProfile.Holidays
+		if err := profile.Validate(); err != nil {
+			renderEditLocation(w, r, http.StatusBadRequest, after, profile, err.Error())
+			return
+		}
+		err = s.updateAudited(func(tx *data.Tx) (data.AuditEntry, error) {
+			if err := data.UpdateLocationTx(tx, id, name, number); err != nil {
+				return data.AuditEntry{}, err
+			}
+			err := data.SaveLocationProfileTx(tx, profile)
+			return auditEntry(r, id, "location", id,
+				map[string]any{"location": before, "profile": beforeProfile},
+				map[string]any{"location": after, "profile": profile}), err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/locations/"+idStr, http.StatusSeeOther)
+	}))
+
+	// Closed Days
+	app.At("GET /admin/locations/{id}/closures", requirePermission(data.PermManageLocations, func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		err = data.DeleteEmployee(empId)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
-		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees", http.StatusSeeOther)
-	})
+		s.renderClosures(w, r, http.StatusOK, loc, "")
+	}))
 
-	// Terminate Employee
-	app.At("POST /admin/locations/{id}/employees/{empId}/terminate", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/closures/holidays", requirePermission(data.PermManageLocations, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
-		empIdStr := r.PathValue("empId")
-		empId, err := strconv.Atoi(empIdStr)
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			http.Error(w, "Invalid Employee ID", http.StatusBadRequest)
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		terminationDate := time.Now().Format("2006-01-02")
-		err = data.TerminateEmployee(empId, terminationDate)
+		profile, err := s.store.GetLocationProfile(id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees", http.StatusSeeOther)
-	})
-
-	// Reinstate Employee
-	app.At("POST /admin/locations/{id}/employees/{empId}/reinstate", func(w http.ResponseWriter, r *http.Request) {
-		idStr := r.PathValue("id")
-		empIdStr := r.PathValue("empId")
-		empId, err := strconv.Atoi(empIdStr)
-		if err != nil {
-			http.Error(w, "Invalid Employee ID", http.StatusBadRequest)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
 			return
 		}
-		err = data.ReinstateEmployee(empId)
+		before := profile.Holidays
+		profile.Holidays = nil
+		for _, h := range calendar.Holidays {
+			if r.Form.Has("holiday_" + h.Key) {
+				profile.Holidays = append(profile.Holidays, h.Key)
+			}
+		}
+		err = s.updateAudited(func(tx *data.Tx) (data.AuditEntry, error) {
+			err := data.SaveLocationProfileTx(tx, profile)
+			return auditEntry(r, id, "closure", "holidays", before, profile.Holidays), err
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		http.Redirect(w, r, "/admin/locations/"+idStr+"/employees?status=terminated", http.StatusSeeOther)
-	})
+		http.Redirect(w, r, "/admin/locations/"+idStr+"/closures", http.StatusSeeOther)
+	}))
 
-	// Edit Location Form
-	app.At("GET /admin/locations/{id}/edit", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/closures", requirePermission(data.PermManageLocations, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		loc, err := data.GetLocationByID(id)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
-		templateData := struct {
-			Location data.CfaLocation
-		}{
-			Location: loc,
+		date := strings.TrimSpace(r.FormValue("date"))
+		name := strings.TrimSpace(r.FormValue("name"))
+		if err := data.ValidateClosure(date, name); err != nil {
+			s.renderClosures(w, r, http.StatusBadRequest, loc, err.Error())
+			return
 		}
-		err = vii.ExecuteTemplate(w, r, "edit_location.html", templateData)
+		err = s.updateAudited(func(tx *data.Tx) (data.AuditEntry, error) {
+			err := data.AddClosureTx(tx, id, date, name)
+			return auditEntry(r, id, "closure", date, nil, map[string]any{"date": date, "name": name}), err
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-	})
+		http.Redirect(w, r, "/admin/locations/"+idStr+"/closures", http.StatusSeeOther)
+	}))
 
-	// Update Location
-	app.At("POST /admin/locations/{id}/update", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/closures/{closureId}/delete", requirePermission(data.PermManageLocations, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		name := r.FormValue("name")
-		number := r.FormValue("number")
-		err = data.UpdateLocation(id, name, number)
+		closureID, err := strconv.Atoi(r.PathValue("closureId"))
+		if err != nil {
+			http.Error(w, "Invalid closure ID", http.StatusBadRequest)
+			return
+		}
+		err = s.updateAudited(func(tx *data.Tx) (data.AuditEntry, error) {
+			err := data.DeleteClosureTx(tx, id, closureID)
+			return auditEntry(r, id, "closure", closureID, map[string]any{"id": closureID}, nil), err
+		})
+		if errors.Is(err, data.ErrClosureNotFound) {
+			http.Error(w, "Closure not found", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		http.Redirect(w, r, "/admin", http.StatusSeeOther)
-	})
+		http.Redirect(w, r, "/admin/locations/"+idStr+"/closures", http.StatusSeeOther)
+	}))
 
 	// Sales Form
-	app.At("GET /admin/locations/{id}/sales/new", func(w http.ResponseWriter, r *http.Request) {
+	app.At("GET /admin/locations/{id}/sales/new", requirePermission(data.PermEnterSales, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		loc, err := data.GetLocationByID(id)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
 
+		profile := s.locationProfile(id)
 		today := r.URL.Query().Get("date")
 		if today == "" {
-			today = time.Now().Format("2006-01-02")
+			today = profile.Now().Format("2006-01-02")
+		}
+		closed, err := s.locationClosedDays(profile)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		closedReason, _ := closed.ReasonForDate(today)
 
 		// Fetch existing sales data for this date
-		existingSales, _ := data.GetSalesByDate(id, today)
+		existingSales, _ := s.store.GetSalesByDate(id, today)
 		dayPartValues := make(map[string]float64)
 		destinationValues := make(map[string]float64)
 		for _, sale := range existingSales {
@@ -1806,6 +3348,8 @@ func RegisterRoutes(app *vii.App) {
 			DayParts          []string
 			Destinations      []string
 			Today             string
+			ClosedReason      string
+			Saved             bool
 			DayPartValues     map[string]float64
 			DestinationValues map[string]float64
 		}{
@@ -1813,17 +3357,19 @@ func RegisterRoutes(app *vii.App) {
 			DayParts:          data.DayParts,
 			Destinations:      data.Destinations,
 			Today:             today,
+			ClosedReason:      closedReason,
+			Saved:             r.URL.Query().Get("saved") == "1",
 			DayPartValues:     dayPartValues,
 			DestinationValues: destinationValues,
 		}
-		err = vii.ExecuteTemplate(w, r, "sales_form.html", templateData)
+		err = renderTemplate(w, r, "sales_form.html", templateData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
 	// Save Sales
-	app.At("POST /admin/locations/{id}/sales", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/sales", requirePermission(data.PermEnterSales, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
@@ -2012,27 +3558,39 @@ func RegisterRoutes(app *vii.App) {
 		}
 
 		if len(records) > 0 {
-			err = data.SaveSalesBatch(id, date, records)
+			before, _ := s.store.GetSalesByDate(id, date)
+			err = s.updateAudited(func(tx *data.Tx) (data.AuditEntry, error) {
+				err := data.SaveSalesBatchTx(tx, id, date, records)
+				return auditEntry(r, id, "sales", date, before, records), err
+			})
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 		}
 
+		// Sales on a closed day are kept but left out of averages; send the
+		// user back to the form so they see the warning.
+		if closed, err := s.locationClosedDays(s.locationProfile(id)); err == nil {
+			if _, isClosed := closed.ReasonForDate(date); isClosed {
+				http.Redirect(w, r, "/admin/locations/"+idStr+"/sales/new?date="+url.QueryEscape(date)+"&saved=1", http.StatusSeeOther)
+				return
+			}
+		}
 		http.Redirect(w, r, "/admin/locations/"+idStr, http.StatusSeeOther)
 
-	})
+	}))
 
 	// Sales History List (with Range Filter)
 
-	app.At("GET /admin/locations/{id}/sales/history", func(w http.ResponseWriter, r *http.Request) {
+	app.At("GET /admin/locations/{id}/sales/history", requirePermission(data.PermViewSales, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		loc, err := data.GetLocationByID(id)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
@@ -2043,16 +3601,26 @@ func RegisterRoutes(app *vii.App) {
 
 		// Default to last 90 days if no filter provided
 		if startDate == "" && endDate == "" {
-			now := time.Now()
+			now := s.locationNow(id)
 			endDate = now.Format("2006-01-02")
 			startDate = now.AddDate(0, 0, -90).Format("2006-01-02")
 		}
 
-		dailySummaries, rangeSummary, err := data.GetSalesSummaries(id, startDate, endDate)
+		dailySummaries, rangeSummary, err := s.store.GetSalesSummaries(id, startDate, endDate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		profile := s.locationProfile(id)
+		closed, err := s.locationClosedDays(profile)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if err := data.ExcludeClosedDaysFromAverages(s.store, id, dailySummaries, &rangeSummary, closed); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		// Ensure all categories are present in rangeSummary for consistent display
 		for _, item := range data.DayParts {
@@ -2083,7 +3651,11 @@ func RegisterRoutes(app *vii.App) {
 			return dailySummaries[i].Date > dailySummaries[j].Date
 		})
 
-		ranges := getCommonRanges()
+		ranges := getCommonRanges(profile)
+		closedDates := map[string]string{}
+		if within, ok := dateRange(startDate, endDate, profile.TimeLocation()); ok {
+			closedDates = closed.ClosedDates(within)
+		}
 
 		templateData := struct {
 			Location       data.CfaLocation
@@ -2091,6 +3663,7 @@ func RegisterRoutes(app *vii.App) {
 			EndDate        string
 			DailySummaries []data.DailySummary
 			RangeSummary   data.RangeSummary
+			ClosedDates    map[string]string
 			Ranges         interface{}
 		}{
 			Location:       loc,
@@ -2098,17 +3671,18 @@ func RegisterRoutes(app *vii.App) {
 			EndDate:        endDate,
 			DailySummaries: dailySummaries,
 			RangeSummary:   rangeSummary,
+			ClosedDates:    closedDates,
 			Ranges:         ranges,
 		}
 
-		err = vii.ExecuteTemplate(w, r, "sales_list.html", templateData)
+		err = renderTemplate(w, r, "sales_list.html", templateData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
 	// Daily Sales Detail
-	app.At("GET /admin/locations/{id}/sales/date/{date}", func(w http.ResponseWriter, r *http.Request) {
+	app.At("GET /admin/locations/{id}/sales/date/{date}", requirePermission(data.PermViewSales, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
@@ -2117,13 +3691,13 @@ func RegisterRoutes(app *vii.App) {
 		}
 		dateStr := r.PathValue("date")
 
-		loc, err := data.GetLocationByID(id)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
 
-		sales, err := data.GetSalesByDate(id, dateStr)
+		sales, err := s.store.GetSalesByDate(id, dateStr)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -2189,21 +3763,21 @@ func RegisterRoutes(app *vii.App) {
 			DestinationTotal: destTotal,
 		}
 
-		err = vii.ExecuteTemplate(w, r, "sales_day_detail.html", templateData)
+		err = renderTemplate(w, r, "sales_day_detail.html", templateData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
 	// Labor Form
-	app.At("GET /admin/locations/{id}/labor/new", func(w http.ResponseWriter, r *http.Request) {
+	app.At("GET /admin/locations/{id}/labor/new", requirePermission(data.PermEnterLabor, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		loc, err := data.GetLocationByID(id)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
@@ -2211,11 +3785,11 @@ func RegisterRoutes(app *vii.App) {
 
 		today := r.URL.Query().Get("date")
 		if today == "" {
-			today = time.Now().Format("2006-01-02")
+			today = s.locationNow(id).Format("2006-01-02")
 		}
 
 		// Fetch existing labor data for this date
-		existingLabor, _ := data.GetLaborByDate(id, today)
+		existingLabor, _ := s.store.GetLaborByDate(id, today)
 
 		templateData := struct {
 			Location data.CfaLocation
@@ -2226,14 +3800,14 @@ func RegisterRoutes(app *vii.App) {
 			Today:    today,
 			Existing: existingLabor,
 		}
-		err = vii.ExecuteTemplate(w, r, "labor_form.html", templateData)
+		err = renderTemplate(w, r, "labor_form.html", templateData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
 	// Save Labor
-	app.At("POST /admin/locations/{id}/labor", func(w http.ResponseWriter, r *http.Request) {
+	app.At("POST /admin/locations/{id}/labor", requirePermission(data.PermEnterLabor, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
@@ -2304,7 +3878,16 @@ func RegisterRoutes(app *vii.App) {
 		}
 
 		if date != "" {
-			err := data.SaveLabor(id, date, regular, overtime, regularWages, overtimeWages)
+			before, _ := s.store.GetLaborByDate(id, date)
+			err := s.updateAudited(func(tx *data.Tx) (data.AuditEntry, error) {
+				err := data.SaveLaborTx(tx, id, date, regular, overtime, regularWages, overtimeWages)
+				return auditEntry(r, id, "labor", date, before, map[string]any{
+					"regular":        regular,
+					"overtime":       overtime,
+					"regular_wages":  regularWages,
+					"overtime_wages": overtimeWages,
+				}), err
+			})
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -2312,67 +3895,99 @@ func RegisterRoutes(app *vii.App) {
 		}
 
 		http.Redirect(w, r, "/admin/locations/"+idStr, http.StatusSeeOther)
-	})
+	}))
 
 	// Labor History (Consolidated Performance View)
-	app.At("GET /admin/locations/{id}/labor/history", func(w http.ResponseWriter, r *http.Request) {
+	app.At("GET /admin/locations/{id}/labor/history", requirePermission(data.PermViewSales, func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		loc, err := data.GetLocationByID(id)
+		loc, err := s.store.GetLocationByID(id)
 		if err != nil {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
+		profile, err := s.store.GetLocationProfile(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		startDate := r.URL.Query().Get("start")
 		endDate := r.URL.Query().Get("end")
 
 		// Default to last 90 days if no filter provided
 		if startDate == "" && endDate == "" {
-			now := time.Now()
+			now := profile.Now()
 			endDate = now.Format("2006-01-02")
 			startDate = now.AddDate(0, 0, -90).Format("2006-01-02")
 		}
 
-		records, err := data.GetPerformanceReport(id, startDate, endDate)
+		records, err := s.store.GetPerformanceReport(id, startDate, endDate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		closed, err := s.locationClosedDays(profile)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		// Calculate Range Summary
-		summary := data.CalculateSummary(records)
+		summary := data.OperatingSummary{PerformanceSummary: data.CalculateSummary(records)}
+		closedDates := map[string]string{}
+
+		var weeks, periods []data.PerformanceRollup
+		if within, ok := dateRange(startDate, endDate, profile.TimeLocation()); ok {
+			summary = data.CalculateOperatingSummary(records, closed, within)
+			closedDates = closed.ClosedDates(within)
+			weeks = data.RollupPerformance(records, profile.Calendar, calendar.UnitWeek, within, within.Start.Location(), closed)
+			periods = data.RollupPerformance(records, profile.Calendar, calendar.UnitPeriod, within, within.Start.Location(), closed)
+		}
 
-		ranges := getCommonRanges()
+		ranges := getCommonRanges(profile)
 
 		templateData := struct {
-			Location  data.CfaLocation
-			Records   []data.DailyPerformanceRecord
-			StartDate string
-			EndDate   string
-			Ranges    interface{}
-			Summary   data.PerformanceSummary
+			Location    data.CfaLocation
+			Profile     data.LocationProfile
+			Records     []data.DailyPerformanceRecord
+			StartDate   string
+			EndDate     string
+			Ranges      interface{}
+			Summary     data.OperatingSummary
+			ClosedDates map[string]string
+			Weeks       []data.PerformanceRollup
+			Periods     []data.PerformanceRollup
 		}{
-			Location:  loc,
-			Records:   records,
-			StartDate: startDate,
-			EndDate:   endDate,
-			Ranges:    ranges,
-			Summary:   summary,
+			Location:    loc,
+			Profile:     profile,
+			Records:     records,
+			StartDate:   startDate,
+			EndDate:     endDate,
+			Ranges:      ranges,
+			Summary:     summary,
+			ClosedDates: closedDates,
+			Weeks:       weeks,
+			Periods:     periods,
 		}
 
-		err = vii.ExecuteTemplate(w, r, "labor_history.html", templateData)
+		err = renderTemplate(w, r, "labor_history.html", templateData)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}))
 
 	// API: Performance Summary
-	app.At("GET /api/locations/{id}/performance", func(w http.ResponseWriter, r *http.Request) {
+	app.At("GET /api/locations/{id}/performance", requireAPIToken(func(w http.ResponseWriter, r *http.Request) {
+		if !performanceAPIEnabled {
+			writeJSONError(w, http.StatusNotFound, "the performance API is disabled")
+			return
+		}
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
@@ -2387,47 +4002,588 @@ func RegisterRoutes(app *vii.App) {
 		// For API, explicit or empty (all) is usually better, but let's match the UI behavior for consistency if not specified.
 		// Actually, standard API: if not specified, maybe just today?
 		// Let's use the same default: 90 days.
+		profile := s.locationProfile(id)
 		if startDate == "" && endDate == "" {
-			now := time.Now()
+			now := profile.Now()
 			endDate = now.Format("2006-01-02")
 			startDate = now.AddDate(0, 0, -90).Format("2006-01-02")
 		}
 
-		records, err := data.GetPerformanceReport(id, startDate, endDate)
+		records, err := s.store.GetPerformanceReport(id, startDate, endDate)
+		if err != nil {
+			app.JSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		closed, err := s.locationClosedDays(profile)
+		if err != nil {
+			app.JSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		summary := data.OperatingSummary{PerformanceSummary: data.CalculateSummary(records)}
+		weeks := []data.PerformanceRollup{}
+		periods := []data.PerformanceRollup{}
+		if within, ok := dateRange(startDate, endDate, profile.TimeLocation()); ok {
+			summary = data.CalculateOperatingSummary(records, closed, within)
+			weeks = data.RollupPerformance(records, profile.Calendar, calendar.UnitWeek, within, within.Start.Location(), closed)
+			periods = data.RollupPerformance(records, profile.Calendar, calendar.UnitPeriod, within, within.Start.Location(), closed)
+		}
+
+		// Fiscal year to date as of today, independent of the requested range.
+		now := profile.Now()
+		year, fiscalYear := profile.Calendar.Year(now)
+		yearStart := year.Start.Format(calendar.DateLayout)
+		today := now.Format(calendar.DateLayout)
+		ytdRecords, err := s.store.GetPerformanceReport(id, yearStart, today)
 		if err != nil {
 			app.JSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 			return
 		}
 
-		summary := data.CalculateSummary(records)
 		app.JSON(w, http.StatusOK, map[string]interface{}{
 			"summary":   summary,
 			"records":   records,
 			"startDate": startDate,
 			"endDate":   endDate,
+			"weeks":     weeks,
+			"periods":   periods,
+			"yearToDate": map[string]interface{}{
+				"fiscalYear": fiscalYear,
+				"startDate":  yearStart,
+				"endDate":    today,
+				"summary":    data.CalculateOperatingSummary(ytdRecords, closed, calendar.Range{Start: year.Start, End: calendar.Date(now)}),
+			},
 		})
-	})
+	}))
+}
+
+type weekdayHours struct {
+	Day     string
+	Weekday int
+	data.DayHours
+}
+
+// hoursByWeekday lists a location's hours Monday first, the way staff read a
+// schedule.
+func hoursByWeekday(profile data.LocationProfile) []weekdayHours {
+	rows := make([]weekdayHours, 0, 7)
+	for i := 1; i <= 7; i++ {
+		day := time.Weekday(i % 7)
+		rows = append(rows, weekdayHours{Day: day.String(), Weekday: int(day), DayHours: profile.Hours[day]})
+	}
+	return rows
+}
+
+func locationProfileFromForm(r *http.Request, locationID int) data.LocationProfile {
+	profile := data.NewLocationProfile(locationID)
+	profile.Timezone = strings.TrimSpace(r.FormValue("timezone"))
+	profile.Address = strings.TrimSpace(r.FormValue("address"))
+	profile.TargetProductivity, _ = strconv.ParseFloat(strings.TrimSpace(r.FormValue("target_productivity")), 64)
+	profile.TargetLaborPercent, _ = strconv.ParseFloat(strings.TrimSpace(r.FormValue("target_labor_percent")), 64)
+	if weekStart, err := strconv.Atoi(r.FormValue("week_start")); err == nil {
+		profile.Calendar.WeekStart = time.Weekday(weekStart)
+	}
+	if pattern := r.FormValue("fiscal_pattern"); pattern != "" {
+		profile.Calendar.Pattern = pattern
+	}
+	if month, err := strconv.Atoi(r.FormValue("fiscal_year_start_month")); err == nil {
+		profile.Calendar.YearStartMonth = time.Month(month)
+	}
+	if day, err := strconv.Atoi(r.FormValue("fiscal_year_start_day")); err == nil {
+		profile.Calendar.YearStartDay = day
+	}
+	for day := range profile.Hours {
+		prefix := "hours_" + strconv.Itoa(day) + "_"
+		profile.Hours[day] = data.DayHours{
+			Open:   strings.TrimSpace(r.FormValue(prefix + "open")),
+			Close:  strings.TrimSpace(r.FormValue(prefix + "close")),
+			Closed: r.FormValue(prefix+"closed") == "on",
+		}
+	}
+	return profile
+}
+
+func renderEditLocation(w http.ResponseWriter, r *http.Request, status int, loc data.CfaLocation, profile data.LocationProfile, message string) {
+	templateData := struct {
+		Location  data.CfaLocation
+		Profile   data.LocationProfile
+		Hours     []weekdayHours
+		Timezones []string
+		Weekdays  []time.Weekday
+		Months    []time.Month
+		Patterns  []string
+		Message   string
+	}{
+		Location:  loc,
+		Profile:   profile,
+		Hours:     hoursByWeekday(profile),
+		Timezones: commonTimezones,
+		Weekdays:  []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+		Months:    []time.Month{time.January, time.February, time.March, time.April, time.May, time.June, time.July, time.August, time.September, time.October, time.November, time.December},
+		Patterns:  calendar.Patterns,
+		Message:   message,
+	}
+	w.WriteHeader(status)
+	if err := renderTemplate(w, r, "edit_location.html", templateData); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// commonTimezones seeds the timezone picker; any IANA name is accepted.
+var commonTimezones = []string{
+	"America/New_York",
+	"America/Chicago",
+	"America/Denver",
+	"America/Phoenix",
+	"America/Los_Angeles",
+	"America/Anchorage",
+	"Pacific/Honolulu",
+	"America/Puerto_Rico",
 }
 
-func getCommonRanges() struct{ MonthStart, NinetyStart, YTDStart, Today string } {
-	now := time.Now()
-	today := now.Format("2006-01-02")
+type commonRanges struct {
+	WeekStart    string
+	PeriodStart  string
+	QuarterStart string
+	NinetyStart  string
+	YTDStart     string
+	Today        string
+}
+
+// getCommonRanges returns the quick-range dates for a location, following
+// its timezone and fiscal calendar.
+func getCommonRanges(profile data.LocationProfile) commonRanges {
+	now := profile.Now()
+	cal := profile.Calendar
+	quarter, _ := cal.Quarter(now)
+	year, _ := cal.Year(now)
+	return commonRanges{
+		WeekStart:    cal.Week(now).Start.Format(calendar.DateLayout),
+		PeriodStart:  cal.Period(now).Start.Format(calendar.DateLayout),
+		QuarterStart: quarter.Start.Format(calendar.DateLayout),
+		NinetyStart:  now.AddDate(0, 0, -90).Format(calendar.DateLayout),
+		YTDStart:     year.Start.Format(calendar.DateLayout),
+		Today:        now.Format(calendar.DateLayout),
+	}
+}
+
+// dateRange parses a start and end date in loc. It reports false when either
+// is missing or malformed.
+func dateRange(start, end string, loc *time.Location) (calendar.Range, bool) {
+	startDate, err := time.ParseInLocation(calendar.DateLayout, start, loc)
+	if err != nil {
+		return calendar.Range{}, false
+	}
+	endDate, err := time.ParseInLocation(calendar.DateLayout, end, loc)
+	if err != nil {
+		return calendar.Range{}, false
+	}
+	return calendar.Range{Start: startDate, End: endDate}, true
+}
+
+func renderLoginPage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	templateData := struct {
+		Title          string
+		Message        string
+		ShowForm       bool
+		RememberMeDays int
+	}{
+		Title:          "Login Status",
+		Message:        message,
+		ShowForm:       true,
+		RememberMeDays: rememberMeDays(),
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	if err := renderTemplate(w, r, "index.html", templateData); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// rememberMeDays is the "remember me" lifetime shown on the login form, or 0
+// when the option is turned off.
+func rememberMeDays() int {
+	if !rememberMeEnabled {
+		return 0
+	}
+	return int(rememberMeMaxLifetime.Hours() / 24)
+}
+
+// formatWait renders a lockout duration for humans, rounding up so a user is
+// never told to retry before the lock actually lifts.
+func formatWait(d time.Duration) string {
+	if d < time.Minute {
+		seconds := int((d + time.Second - 1) / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+func renderLoginChallenge(w http.ResponseWriter, r *http.Request, user data.User, message string) {
+	tf, err := data.GetTwoFactor(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !tf.Enabled && tf.Secret == "" {
+		if tf.Secret, err = data.BeginTwoFactorEnrollment(user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	templateData := struct {
+		Username        string
+		Enroll          bool
+		Secret          string
+		ProvisioningURI string
+		Message         string
+	}{
+		Username: user.Username,
+		Enroll:   !tf.Enabled,
+		Message:  message,
+	}
+	if templateData.Enroll {
+		templateData.Secret = tf.Secret
+		templateData.ProvisioningURI = totp.ProvisioningURI(totpIssuer, user.Username, tf.Secret)
+	}
+	if err := renderTemplate(w, r, "two_factor.html", templateData); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// renderTwoFactorSetup shows the signed-in user's 2FA status. recoveryCodes
+// are only passed right after they are generated, since only hashes are kept.
+func renderTwoFactorSetup(w http.ResponseWriter, r *http.Request, recoveryCodes []string, message string) {
+	user, _ := currentUser(r)
+	tf, err := data.GetTwoFactor(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	remaining, _ := data.CountUnusedRecoveryCodes(user.ID)
+	templateData := struct {
+		User            data.User
+		TwoFactor       data.TwoFactor
+		Pending         bool
+		ProvisioningURI string
+		RecoveryCodes   []string
+		RemainingCodes  int
+		Required        bool
+		Message         string
+	}{
+		User:           user,
+		TwoFactor:      tf,
+		Pending:        !tf.Enabled && tf.Secret != "",
+		RecoveryCodes:  recoveryCodes,
+		RemainingCodes: remaining,
+		Required:       isAdminUser(user) && data.GetBoolSetting(organizationIDForUser(user), data.SettingRequireAdmin2FA),
+		Message:        message,
+	}
+	if templateData.Pending {
+		templateData.ProvisioningURI = totp.ProvisioningURI(totpIssuer, user.Username, tf.Secret)
+	}
+	if err := renderTemplate(w, r, "two_factor_setup.html", templateData); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// loginScope is the part of the login throttles the current user's
+// organization owns.
+func loginScope(r *http.Request) (data.LoginScope, error) {
+	users, err := data.GetUsersInOrganization(currentOrganizationID(r))
+	if err != nil {
+		return data.LoginScope{}, err
+	}
+	return data.LoginScopeForUsers(users)
+}
+
+// renderUsersPage shows the user administration page. newToken carries a
+// freshly created API token secret, which is only ever displayed once.
+func (s *server) renderUsersPage(w http.ResponseWriter, r *http.Request, newToken string) {
+	user, _ := currentUser(r)
+	orgID := currentOrganizationID(r)
+	users, err := data.GetUsersInOrganization(orgID)
+	if err != nil {
+		users = []data.User{}
+	}
+	locations, err := data.GetLocationsForUser(s.store, user, currentOrganizationID(r))
+	if err != nil {
+		locations = []data.CfaLocation{}
+	}
+	tokens, err := data.GetAPITokens(orgID)
+	if err != nil {
+		tokens = []data.APIToken{}
+	}
+	// Lockouts and login attempts are keyed by username and IP across every
+	// organization. Super-admins see them all; other admins see their own
+	// users and the IPs those users were tried from.
+	superAdmin := data.IsSuperAdmin(user.ID)
+	throttles, err := data.GetLoginThrottles()
+	if err != nil {
+		throttles = []data.LoginThrottle{}
+	}
+	attempts, err := data.GetRecentLoginAttempts(500)
+	if err != nil {
+		attempts = []data.LoginAttempt{}
+	}
+	if !superAdmin {
+		scope, err := data.LoginScopeForUsers(users)
+		if err != nil {
+			scope = data.LoginScope{}
+		}
+		var scopedThrottles []data.LoginThrottle
+		for _, t := range throttles {
+			if scope.HasThrottle(t.Kind, t.Key) {
+				scopedThrottles = append(scopedThrottles, t)
+			}
+		}
+		var scopedAttempts []data.LoginAttempt
+		for _, a := range attempts {
+			if scope.HasAttempt(a) {
+				scopedAttempts = append(scopedAttempts, a)
+			}
+		}
+		throttles, attempts = scopedThrottles, scopedAttempts
+	}
+	if len(attempts) > 50 {
+		attempts = attempts[:50]
+	}
+	twoFactorUsers, err := data.GetTwoFactorEnabledUserIDs()
+	if err != nil {
+		twoFactorUsers = map[int]bool{}
+	}
+	disabledUsers, err := data.GetDisabledUserIDs()
+	if err != nil {
+		disabledUsers = map[int]bool{}
+	}
+	type userRow struct {
+		data.User
+		AllLocations bool
+		LocationIDs  map[int]bool
+		TwoFactor    bool
+		Disabled     bool
+		Self         bool
+		RoleValue    string
+	}
+	rows := make([]userRow, 0, len(users))
+	for _, u := range users {
+		row := userRow{
+			User:         u,
+			AllLocations: data.HasAllLocationAccess(u),
+			LocationIDs:  map[int]bool{},
+			TwoFactor:    twoFactorUsers[u.ID],
+			Disabled:     disabledUsers[u.ID],
+			Self:         u.ID == user.ID,
+			RoleValue:    data.NormalizeRole(u.Role),
+		}
+		if ids, err := data.GetLocationIDsForUser(u.ID); err == nil {
+			for _, id := range ids {
+				row.LocationIDs[id] = true
+			}
+		}
+		rows = append(rows, row)
+	}
+	templateData := struct {
+		User            data.User
+		Users           []userRow
+		Locations       []data.CfaLocation
+		Roles           []string
+		RoleLabels      map[string]string
+		Tokens          []data.APIToken
+		NewToken        string
+		Throttles       []data.LoginThrottle
+		Attempts        []data.LoginAttempt
+		Now             time.Time
+		RequireAdmin2FA bool
+		IsSuperAdmin    bool
+		Message         string
+	}{
+		User:            user,
+		Users:           rows,
+		Locations:       locations,
+		Roles:           data.Roles,
+		RoleLabels:      data.RoleLabels,
+		Tokens:          tokens,
+		NewToken:        newToken,
+		Throttles:       throttles,
+		Attempts:        attempts,
+		Now:             time.Now(),
+		RequireAdmin2FA: data.GetBoolSetting(orgID, data.SettingRequireAdmin2FA),
+		IsSuperAdmin:    superAdmin,
+	}
+	if err := renderTemplate(w, r, "users.html", templateData); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var trashPermissions = map[string]data.Permission{
+	data.TrashLocation:     data.PermManageLocations,
+	data.TrashEmployee:     data.PermManageEmployees,
+	data.TrashPayrollEvent: data.PermEditPayroll,
+}
+
+// canManageTrashItem applies the same permission and location checks as the
+// delete route that trashed the item.
+func canManageTrashItem(r *http.Request, item data.TrashItem) bool {
+	perm, ok := trashPermissions[item.EntityType]
+	if !ok || !hasPermission(r, perm) {
+		return false
+	}
+	if item.EntityType == data.TrashLocation {
+		orgID, err := data.OrganizationIDForLocation(item.LocationID)
+		return err == nil && orgID == currentOrganizationID(r)
+	}
+	return canAccessLocation(r, item.LocationID)
+}
+
+func trashItemFromRequest(w http.ResponseWriter, r *http.Request) (data.TrashItem, bool) {
+	entityID, err := strconv.Atoi(r.PathValue("entityId"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return data.TrashItem{}, false
+	}
+	item, err := data.GetTrashedItem(r.PathValue("entity"), entityID)
+	if err != nil {
+		http.Error(w, "Item not found in trash", http.StatusNotFound)
+		return data.TrashItem{}, false
+	}
+	if !canManageTrashItem(r, item) {
+		renderForbidden(w, r)
+		return data.TrashItem{}, false
+	}
+	return item, true
+}
+
+// locationProfile loads a location's profile, falling back to the defaults so
+// pages still render when it can't be read.
+func (s *server) locationProfile(locationID int) data.LocationProfile {
+	profile, err := s.store.GetLocationProfile(locationID)
+	if err != nil {
+		return data.NewLocationProfile(locationID)
+	}
+	return profile
+}
+
+// locationClosedDays builds the closed-day calendar for a location's profile.
+func (s *server) locationClosedDays(profile data.LocationProfile) (data.ClosedDays, error) {
+	closures, err := s.store.GetClosures(profile.LocationID)
+	if err != nil {
+		return data.ClosedDays{}, err
+	}
+	return data.NewClosedDays(profile, closures), nil
+}
+
+func (s *server) renderClosures(w http.ResponseWriter, r *http.Request, status int, loc data.CfaLocation, message string) {
+	profile, err := s.store.GetLocationProfile(loc.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	closures, err := s.store.GetClosures(loc.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type holidayRow struct {
+		calendar.Holiday
+		Next    string
+		Enabled bool
+	}
+	now := profile.Now()
+	enabled := map[string]bool{}
+	for _, key := range profile.Holidays {
+		enabled[key] = true
+	}
+	var holidays []holidayRow
+	for _, h := range calendar.Holidays {
+		next := h.In(now.Year(), now.Location())
+		if next.Before(calendar.Date(now)) {
+			next = h.In(now.Year()+1, now.Location())
+		}
+		holidays = append(holidays, holidayRow{Holiday: h, Next: next.Format("Mon Jan 2, 2006"), Enabled: enabled[h.Key]})
+	}
+	var closedWeekdays []string
+	for _, row := range hoursByWeekday(profile) {
+		if row.Closed {
+			closedWeekdays = append(closedWeekdays, row.Day)
+		}
+	}
 
-	// Current Month
-	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Format("2006-01-02")
+	templateData := struct {
+		Location       data.CfaLocation
+		ClosedWeekdays []string
+		Holidays       []holidayRow
+		Closures       []data.Closure
+		Today          string
+		Message        string
+	}{
+		Location:       loc,
+		ClosedWeekdays: closedWeekdays,
+		Holidays:       holidays,
+		Closures:       closures,
+		Today:          now.Format(calendar.DateLayout),
+		Message:        message,
+	}
+	w.WriteHeader(status)
+	if err := renderTemplate(w, r, "location_closures.html", templateData); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 
-	// 90 Days
-	ninetyStart := now.AddDate(0, 0, -90).Format("2006-01-02")
+// locationNow is the current time in the location's timezone. Date defaults,
+// "today" and week boundaries are all computed from it.
+func (s *server) locationNow(locationID int) time.Time {
+	return s.locationProfile(locationID).Now()
+}
+
+// formLocationIDs returns the location_id values posted with a form, dropping
+// any the current user can't access.
+func formLocationIDs(r *http.Request) []int {
+	var locationIDs []int
+	for _, value := range r.Form["location_id"] {
+		locationID, err := strconv.Atoi(value)
+		if err != nil || !canAccessLocation(r, locationID) {
+			continue
+		}
+		locationIDs = append(locationIDs, locationID)
+	}
+	return locationIDs
+}
 
-	// YTD
-	ytdStart := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location()).Format("2006-01-02")
+// payrollEventForLocation is the payroll counterpart of employeeForLocation.
+func (s *server) payrollEventForLocation(eventID, locationID int) (data.PayrollEvent, bool) {
+	if data.IsTrashed(data.TrashPayrollEvent, eventID) {
+		return data.PayrollEvent{}, false
+	}
+	events, err := s.store.GetPayrollEventsByLocation(locationID, "0001-01-01", "9999-12-31")
+	if err != nil {
+		return data.PayrollEvent{}, false
+	}
+	for _, event := range events {
+		if event.ID == eventID {
+			return event, true
+		}
+	}
+	return data.PayrollEvent{}, false
+}
 
-	return struct{ MonthStart, NinetyStart, YTDStart, Today string }{
-		MonthStart:  monthStart,
-		NinetyStart: ninetyStart,
-		YTDStart:    ytdStart,
-		Today:       today,
+// employeeForLocation guards routes that take both a location {id} and an
+// {empId} so a user assigned to one store can't reach another store's staff.
+func (s *server) employeeForLocation(empID, locationID int) (data.Employee, bool) {
+	employee, err := s.store.GetEmployeeByID(empID)
+	if err != nil || employee.LocationID != locationID || data.IsTrashed(data.TrashEmployee, empID) {
+		return data.Employee{}, false
 	}
+	return employee, true
 }
 
 // Helper to check slice containment
@@ -0,0 +1,274 @@
+// Package match decides whether names from different systems (Bio,
+// HotSchedules, time punch reports) belong to the same person. Names are
+// compared after folding case, accents, punctuation, middle initials and
+// suffixes, with nicknames and per-person aliases counted as the same name.
+package match
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DefaultThreshold is the score a name must reach to match when no threshold
+// is configured.
+const DefaultThreshold = 0.85
+
+// SuggestionFloor is the lowest score offered as a suggestion for a person to
+// confirm.
+const SuggestionFloor = 0.5
+
+// margin is how far the best candidate must lead the next one for a fuzzy
+// match to count as unambiguous.
+const margin = 0.05
+
+type Name struct {
+	First string
+	Last  string
+}
+
+// Candidate is someone a name can match, known by their own name and any
+// aliases.
+type Candidate struct {
+	ID    int
+	Names []Name
+}
+
+type Result struct {
+	ID    int
+	Score float64
+}
+
+type Matcher struct {
+	threshold  float64
+	candidates []Candidate
+}
+
+// New returns a Matcher over candidates. A threshold outside (0, 1] falls
+// back to DefaultThreshold.
+func New(threshold float64, candidates []Candidate) *Matcher {
+	if threshold <= 0 || threshold > 1 {
+		threshold = DefaultThreshold
+	}
+	return &Matcher{threshold: threshold, candidates: candidates}
+}
+
+func (m *Matcher) Threshold() float64 {
+	return m.threshold
+}
+
+// Rank scores every candidate against the best of names and returns those at
+// or above floor, best first.
+func (m *Matcher) Rank(floor float64, names ...Name) []Result {
+	return m.rank(floor, false, names)
+}
+
+// rank is Rank, optionally counting only pairs whose given names are the
+// same or nicknames of each other.
+func (m *Matcher) rank(floor float64, sameFirst bool, names []Name) []Result {
+	var results []Result
+	for _, c := range m.candidates {
+		best := 0.0
+		for _, name := range names {
+			for _, known := range c.Names {
+				s, same := score(name, known)
+				if sameFirst && !same {
+					continue
+				}
+				best = max(best, s)
+			}
+		}
+		if best >= floor && best > 0 {
+			results = append(results, Result{ID: c.ID, Score: best})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return results
+}
+
+// Match returns the candidates that clear the threshold. A single result is a
+// match; several are ambiguous. Only candidates whose given name is the same
+// or a known nickname can match: "Mark" and "Mary" are one letter apart but
+// different people, so near misses like that are left to Suggest. An exact
+// match beats fuzzy ones, and a fuzzy match wins outright when it leads the
+// runner-up by a clear margin.
+func (m *Matcher) Match(names ...Name) []Result {
+	results := m.rank(m.threshold, true, names)
+	if len(results) < 2 {
+		return results
+	}
+	best, next := results[0].Score, results[1].Score
+	if (best == 1 && next < 1) || (best < 1 && best-next >= margin) {
+		return results[:1]
+	}
+	if best == 1 {
+		var exact []Result
+		for _, r := range results {
+			if r.Score == 1 {
+				exact = append(exact, r)
+			}
+		}
+		return exact
+	}
+	return results
+}
+
+// Suggest returns up to limit candidates scoring at least SuggestionFloor, for
+// a person to choose between.
+func (m *Matcher) Suggest(limit int, names ...Name) []Result {
+	results := m.Rank(SuggestionFloor, names...)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// Score rates how likely a and b are the same person, from 0 to 1. First and
+// last names count equally.
+func Score(a, b Name) float64 {
+	s, _ := score(a, b)
+	return s
+}
+
+// score is Score, also reporting whether the given names are the same or
+// nicknames of each other rather than merely similar.
+func score(a, b Name) (float64, bool) {
+	aFirst, bFirst := firstTokens(a.First), firstTokens(b.First)
+	aLast, bLast := lastTokens(a.Last), lastTokens(b.Last)
+	if len(aFirst) == 0 || len(bFirst) == 0 || len(aLast) == 0 || len(bLast) == 0 {
+		return 0, false
+	}
+	first, same := firstScore(aFirst, bFirst)
+	return (first + lastScore(aLast, bLast)) / 2, same
+}
+
+// firstScore compares every pair of given-name tokens, so "Mary Ann" matches
+// "Ann" and "Robert (Bob)" matches "Bob". It reports whether some pair is the
+// same name or nicknames; a shared prefix or a typo only makes them similar.
+func firstScore(a, b []string) (float64, bool) {
+	best, nickname := 0.0, false
+	for _, x := range a {
+		for _, y := range b {
+			switch {
+			case x == y:
+				return 1, true
+			case SameNickname(x, y):
+				nickname = true
+			case len(x) >= 3 && len(y) >= 3 && (strings.HasPrefix(x, y) || strings.HasPrefix(y, x)):
+				best = max(best, 0.9)
+			default:
+				best = max(best, Similarity(x, y))
+			}
+		}
+	}
+	if nickname {
+		return 0.95, true
+	}
+	return best, false
+}
+
+// lastScore compares whole last names, but a shared part of a hyphenated or
+// two-part name ("Garcia-Lopez" and "Garcia") counts nearly as well.
+func lastScore(a, b []string) float64 {
+	best := Similarity(strings.Join(a, " "), strings.Join(b, " "))
+	if best == 1 {
+		return 1
+	}
+	for _, x := range a {
+		for _, y := range b {
+			if x == y && len(x) >= 3 {
+				best = max(best, 0.95)
+			}
+		}
+	}
+	return best
+}
+
+// Similarity is 1 minus the edit distance between a and b over the longer
+// length, so identical strings score 1 and unrelated ones near 0.
+func Similarity(a, b string) float64 {
+	ar, br := []rune(a), []rune(b)
+	longest := max(len(ar), len(br))
+	if longest == 0 {
+		return 0
+	}
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(br)])/float64(longest)
+}
+
+// firstTokens drops middle initials, keeping a lone initial when it is all
+// there is.
+func firstTokens(value string) []string {
+	tokens := tokenize(value)
+	var kept []string
+	for _, t := range tokens {
+		if len(t) > 1 {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == 0 {
+		return tokens
+	}
+	return kept
+}
+
+var suffixes = map[string]bool{"jr": true, "sr": true, "ii": true, "iii": true, "iv": true}
+
+// lastTokens drops initials and generational suffixes.
+func lastTokens(value string) []string {
+	var kept []string
+	for _, t := range tokenize(value) {
+		if len(t) > 1 && !suffixes[t] {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// tokenize lowercases value, folds accents, drops apostrophes so "O'Brien"
+// stays one word, and splits on everything else that isn't a letter.
+func tokenize(value string) []string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(value) {
+		switch {
+		case r == '\'' || r == '’':
+		case unicode.IsLetter(r):
+			if folded, ok := accents[r]; ok {
+				b.WriteString(folded)
+			} else {
+				b.WriteRune(r)
+			}
+		default:
+			b.WriteByte(' ')
+		}
+	}
+	return strings.Fields(b.String())
+}
+
+var accents = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a",
+	'ç': "c", 'č': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i",
+	'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o",
+	'š': "s", 'ß': "ss",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u",
+	'ý': "y", 'ÿ': "y",
+	'ž': "z",
+}
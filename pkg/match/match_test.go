@@ -0,0 +1,258 @@
+package match
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      Name
+		candidates []Candidate
+		want       []int
+	}{
+		{
+			name:       "exact",
+			input:      Name{"John", "Smith"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"John", "Smith"}}}},
+			want:       []int{1},
+		},
+		{
+			name:       "nickname",
+			input:      Name{"Bob", "Smith"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Robert", "Smith"}}}},
+			want:       []int{1},
+		},
+		{
+			name:       "nickname in parentheses",
+			input:      Name{"Robert (Bob)", "Smith"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Bob", "Smith"}}}},
+			want:       []int{1},
+		},
+		{
+			name:       "accents",
+			input:      Name{"José", "Núñez"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Jose", "Nunez"}}}},
+			want:       []int{1},
+		},
+		{
+			name:       "apostrophe",
+			input:      Name{"Patrick", "O'Brien"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Pat", "OBrien"}}}},
+			want:       []int{1},
+		},
+		{
+			name:       "suffix",
+			input:      Name{"John", "Smith Jr."},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"John", "Smith"}}}},
+			want:       []int{1},
+		},
+		{
+			name:       "middle initial",
+			input:      Name{"John A.", "Smith"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"John", "Smith"}}}},
+			want:       []int{1},
+		},
+		{
+			name:       "hyphenated last name",
+			input:      Name{"Maria", "Garcia-Lopez"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Maria", "Garcia"}}}},
+			want:       []int{1},
+		},
+		{
+			name:       "last name typo",
+			input:      Name{"John", "Smyth"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"John", "Smith"}}}},
+			want:       []int{1},
+		},
+		{
+			name:       "alias",
+			input:      Name{"Jimmy", "Nguyen"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Thanh", "Nguyen"}, {"Jimmy", "Nguyen"}}}},
+			want:       []int{1},
+		},
+		{
+			name:       "first names one letter apart",
+			input:      Name{"Mark", "Smith"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Mary", "Smith"}}}},
+			want:       nil,
+		},
+		{
+			name:       "Eric and Erin",
+			input:      Name{"Eric", "Jones"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Erin", "Jones"}}}},
+			want:       nil,
+		},
+		{
+			name:       "John and Joan",
+			input:      Name{"John", "Lee"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Joan", "Lee"}}}},
+			want:       nil,
+		},
+		{
+			name:       "shared prefix",
+			input:      Name{"Dan", "Brown"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Daniela", "Brown"}}}},
+			want:       nil,
+		},
+		{
+			name:       "longer shared prefix",
+			input:      Name{"Alex", "Brown"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"Alexis", "Brown"}}}},
+			want:       nil,
+		},
+		{
+			name:       "different last name",
+			input:      Name{"John", "Smith"},
+			candidates: []Candidate{{ID: 1, Names: []Name{{"John", "Brown"}}}},
+			want:       nil,
+		},
+		{
+			name:  "near miss does not make an exact match ambiguous",
+			input: Name{"Mark", "Smith"},
+			candidates: []Candidate{
+				{ID: 1, Names: []Name{{"Mary", "Smith"}}},
+				{ID: 2, Names: []Name{{"Mark", "Smith"}}},
+			},
+			want: []int{2},
+		},
+		{
+			name:  "exact beats nickname",
+			input: Name{"Bob", "Smith"},
+			candidates: []Candidate{
+				{ID: 1, Names: []Name{{"Robert", "Smith"}}},
+				{ID: 2, Names: []Name{{"Bob", "Smith"}}},
+			},
+			want: []int{2},
+		},
+		{
+			name:  "same name twice",
+			input: Name{"John", "Smith"},
+			candidates: []Candidate{
+				{ID: 1, Names: []Name{{"John", "Smith"}}},
+				{ID: 2, Names: []Name{{"John", "Smith"}}},
+			},
+			want: []int{1, 2},
+		},
+		{
+			name:  "nickname shared by two names",
+			input: Name{"Chris", "Lee"},
+			candidates: []Candidate{
+				{ID: 1, Names: []Name{{"Christopher", "Lee"}}},
+				{ID: 2, Names: []Name{{"Christina", "Lee"}}},
+			},
+			want: []int{1, 2},
+		},
+		{
+			name:  "clear lead wins",
+			input: Name{"John", "Smyth"},
+			candidates: []Candidate{
+				{ID: 1, Names: []Name{{"John", "Smith"}}},
+				{ID: 2, Names: []Name{{"Johnny", "Smithers"}}},
+			},
+			want: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := New(DefaultThreshold, tt.candidates).Match(tt.input)
+			var got []int
+			for _, r := range results {
+				got = append(got, r.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match(%v) = %v (%v), want %v", tt.input, got, results, tt.want)
+			}
+		})
+	}
+}
+
+// Near misses never match but are still offered for a person to confirm.
+func TestSuggestNearMisses(t *testing.T) {
+	tests := []struct {
+		input, candidate Name
+	}{
+		{Name{"Mark", "Smith"}, Name{"Mary", "Smith"}},
+		{Name{"Eric", "Jones"}, Name{"Erin", "Jones"}},
+		{Name{"John", "Lee"}, Name{"Joan", "Lee"}},
+		{Name{"Dan", "Brown"}, Name{"Daniela", "Brown"}},
+		{Name{"Alex", "Brown"}, Name{"Alexis", "Brown"}},
+		{Name{"Jonh", "Smith"}, Name{"John", "Smith"}},
+	}
+	for _, tt := range tests {
+		m := New(SuggestionFloor, []Candidate{{ID: 1, Names: []Name{tt.candidate}}})
+		if got := m.Match(tt.input); len(got) != 0 {
+			t.Errorf("Match(%v) at the lowest threshold = %v, want no match", tt.input, got)
+		}
+		if got := m.Suggest(3, tt.input); len(got) != 1 || got[0].ID != 1 {
+			t.Errorf("Suggest(%v) = %v, want candidate 1", tt.input, got)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		a, b Name
+		want float64
+	}{
+		{Name{"John", "Smith"}, Name{"john", "SMITH"}, 1},
+		{Name{"José", "Núñez"}, Name{"Jose", "Nunez"}, 1},
+		{Name{"John", "Smith Jr."}, Name{"John", "Smith"}, 1},
+		{Name{"John A.", "Smith"}, Name{"John", "Smith"}, 1},
+		{Name{"Bob", "Smith"}, Name{"Robert", "Smith"}, 0.975},
+		{Name{"Maria", "Garcia-Lopez"}, Name{"Maria", "Garcia"}, 0.975},
+		{Name{"John", "Smith"}, Name{"", "Smith"}, 0},
+		{Name{"John", "Jr."}, Name{"John", "Smith"}, 0},
+	}
+	for _, tt := range tests {
+		if got := Score(tt.a, tt.b); got != tt.want {
+			t.Errorf("Score(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"smith", "smith", 1},
+		{"smith", "smyth", 0.8},
+		{"mark", "mary", 0.75},
+		{"", "", 0},
+		{"abc", "", 0},
+	}
+	for _, tt := range tests {
+		if got := Similarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("Similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSameNickname(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"bob", "robert", true},
+		{"chris", "christina", true},
+		{"chris", "christopher", true},
+		{"christina", "christopher", false},
+		{"mark", "mary", false},
+	}
+	for _, tt := range tests {
+		if got := SameNickname(tt.a, tt.b); got != tt.want {
+			t.Errorf("SameNickname(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestNewDefaultsThreshold(t *testing.T) {
+	for _, threshold := range []float64{0, -1, 1.5} {
+		if got := New(threshold, nil).Threshold(); got != DefaultThreshold {
+			t.Errorf("New(%v).Threshold() = %v, want %v", threshold, got, DefaultThreshold)
+		}
+	}
+}
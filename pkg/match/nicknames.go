@@ -0,0 +1,107 @@
+package match
+
+// nicknames groups given names that are commonly used for one another. A name
+// may appear in more than one group ("chris" is both Christopher and
+// Christina).
+var nicknames = [][]string{
+	{"abigail", "abby", "abbie"},
+	{"abraham", "abe"},
+	{"alexander", "alex", "al", "xander", "sandy"},
+	{"alexandra", "alex", "alexa", "lexi", "sandra", "sandy"},
+	{"alfred", "al", "alfie", "fred"},
+	{"allison", "ally", "allie"},
+	{"andrew", "andy", "drew"},
+	{"anthony", "tony"},
+	{"barbara", "barb", "barbie"},
+	{"benjamin", "ben", "benny", "benji"},
+	{"bradley", "brad"},
+	{"caleb", "cal"},
+	{"catherine", "cathy", "cat", "kate", "katie", "kathy"},
+	{"charles", "charlie", "chuck", "chas"},
+	{"christian", "chris"},
+	{"christina", "chris", "christy", "tina"},
+	{"christopher", "chris", "topher", "kit"},
+	{"cynthia", "cindy"},
+	{"daniel", "dan", "danny"},
+	{"david", "dave", "davey"},
+	{"deborah", "deb", "debbie"},
+	{"donald", "don", "donnie"},
+	{"dorothy", "dot", "dottie"},
+	{"edward", "ed", "eddie", "ted", "ned"},
+	{"elizabeth", "liz", "lizzie", "beth", "betsy", "betty", "eliza", "libby"},
+	{"emily", "em", "emmy"},
+	{"evan", "ev"},
+	{"frances", "fran", "frankie"},
+	{"francis", "frank", "frankie"},
+	{"frederick", "fred", "freddie", "freddy"},
+	{"gabriel", "gabe"},
+	{"gabriela", "gabby", "gabi"},
+	{"gerald", "gerry", "jerry"},
+	{"gregory", "greg"},
+	{"harold", "hal", "harry"},
+	{"henry", "hank", "harry"},
+	{"isabella", "bella", "izzy"},
+	{"jacob", "jake"},
+	{"james", "jim", "jimmy", "jamie"},
+	{"jennifer", "jen", "jenny"},
+	{"jessica", "jess", "jessie"},
+	{"jonathan", "jon", "jonny"},
+	{"john", "jack", "johnny", "jon"},
+	{"joseph", "joe", "joey"},
+	{"joshua", "josh"},
+	{"katherine", "kate", "katie", "kathy", "kat", "kay", "kit"},
+	{"kenneth", "ken", "kenny"},
+	{"kimberly", "kim", "kimmy"},
+	{"lawrence", "larry"},
+	{"leonard", "leo", "len", "lenny"},
+	{"madeline", "maddie", "maddy"},
+	{"margaret", "maggie", "meg", "peggy", "marge"},
+	{"matthew", "matt"},
+	{"michael", "mike", "mikey", "mick"},
+	{"nathan", "nate"},
+	{"nathaniel", "nate", "nat"},
+	{"nicholas", "nick", "nicky"},
+	{"olivia", "liv", "livvy"},
+	{"patricia", "pat", "patty", "trish"},
+	{"patrick", "pat", "paddy"},
+	{"peter", "pete"},
+	{"philip", "phil"},
+	{"rebecca", "becky", "becca"},
+	{"richard", "rick", "ricky", "rich", "dick"},
+	{"robert", "rob", "robbie", "bob", "bobby", "bert"},
+	{"ronald", "ron", "ronnie"},
+	{"samantha", "sam", "sammy"},
+	{"samuel", "sam", "sammy"},
+	{"stephen", "steve", "stevie"},
+	{"steven", "steve", "stevie"},
+	{"susan", "sue", "susie"},
+	{"theodore", "ted", "teddy", "theo"},
+	{"thomas", "tom", "tommy"},
+	{"timothy", "tim", "timmy"},
+	{"victoria", "vicky", "tori"},
+	{"william", "will", "bill", "billy", "liam", "willie"},
+	{"zachary", "zach", "zack"},
+}
+
+var nicknameGroups = func() map[string][]int {
+	groups := map[string][]int{}
+	for i, group := range nicknames {
+		for _, name := range group {
+			groups[name] = append(groups[name], i)
+		}
+	}
+	return groups
+}()
+
+// SameNickname reports whether a and b are in a nickname group together.
+// Both must already be lowercase.
+func SameNickname(a, b string) bool {
+	for _, i := range nicknameGroups[a] {
+		for _, j := range nicknameGroups[b] {
+			if i == j {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed from RFC 6238 appendix B, "12345678901234567890"
+// in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeAtRFC6238(t *testing.T) {
+	// RFC 6238 lists 8 digit codes; the last 6 are the 6 digit code for the
+	// same step.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := CodeAt(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("CodeAt(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("CodeAt(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeAtLowercaseSecret(t *testing.T) {
+	got, err := CodeAt("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", Step(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Errorf("CodeAt with a lowercase secret = %q, %v, want 287082", got, err)
+	}
+}
+
+func TestCodeAtInvalidSecret(t *testing.T) {
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Error("CodeAt accepted a secret that isn't base32")
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		skew   int
+		ok     bool
+	}{
+		{"current step", 0, 0, true},
+		{"previous step without skew", -1, 0, false},
+		{"previous step", -1, 1, true},
+		{"next step", 1, 1, true},
+		{"two steps back", -2, 1, false},
+		{"two steps ahead", 2, 1, false},
+		{"two steps back with wider skew", -2, 2, true},
+	}
+
+	for _, tt := range tests {
+		code, err := CodeAt(rfcSecret, current+tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now, tt.skew)
+		if ok != tt.ok {
+			t.Errorf("%s: Validate = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("%s: Validate matched step %d, want %d", tt.name, step, current+tt.offset)
+		}
+	}
+}
+
+func TestValidateFormatting(t *testing.T) {
+	now := time.Unix(59, 0)
+	tests := []struct {
+		code string
+		ok   bool
+	}{
+		{"287082", true},
+		{" 287 082 ", true},
+		{"28708", false},
+		{"2870820", false},
+		{"287083", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if _, ok := Validate(rfcSecret, tt.code, now, 0); ok != tt.ok {
+			t.Errorf("Validate(%q) = %v, want %v", tt.code, ok, tt.ok)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 32 || a == b {
+		t.Errorf("GenerateSecret = %q and %q, want two different 32 character secrets", a, b)
+	}
+	if _, err := CodeAt(a, 1); err != nil {
+		t.Errorf("generated secret doesn't decode: %v", err)
+	}
+}